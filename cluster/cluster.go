@@ -17,7 +17,8 @@ type cluster struct {
 
 	providers map[db.Provider]provider.Provider
 
-	mark bool /* For mark and sweep garbage collection. */
+	mark bool          /* For mark and sweep garbage collection. */
+	stop chan struct{} /* Closed when the cluster is removed. */
 }
 
 // Run continually checks 'conn' for new clusters and implements the policies they
@@ -50,6 +51,7 @@ func Run(conn db.Conn) {
 			} else {
 				clst.fm.stop()
 				clst.trigger.Stop()
+				close(clst.stop)
 				delete(clusters, k)
 			}
 		}
@@ -63,9 +65,11 @@ func newCluster(conn db.Conn, id int, namespace string) cluster {
 		trigger:   conn.TriggerTick(30, db.MachineTable),
 		fm:        newForeman(conn, id),
 		providers: make(map[db.Provider]provider.Provider),
+		stop:      make(chan struct{}),
 	}
 
-	for _, p := range []db.Provider{db.AmazonSpot, db.Google, db.Azure, db.Vagrant} {
+	for _, p := range []db.Provider{db.AmazonSpot, db.Google, db.Azure, db.Vagrant,
+		db.Local} {
 		inst := provider.New(p)
 		err := inst.Start(conn, id, namespace)
 		if err == nil {
@@ -75,17 +79,35 @@ func newCluster(conn db.Conn, id int, namespace string) cluster {
 	go func() {
 		rateLimit := time.NewTicker(5 * time.Second)
 		defer rateLimit.Stop()
-		for range clst.trigger.C {
+		for {
+			select {
+			case <-clst.trigger.C:
+			case <-clst.stop:
+				clst.teardown()
+				for _, p := range clst.providers {
+					p.Disconnect()
+				}
+				return
+			}
+
 			<-rateLimit.C
 			clst.sync()
 		}
-		for _, p := range clst.providers {
-			p.Disconnect()
-		}
 	}()
 	return clst
 }
 
+// teardown terminates all of the cluster's machines.
+func (clst cluster) teardown() {
+	cloudMachines, err := clst.get()
+	if err != nil {
+		log.WithError(err).Error("Failed to list machines for teardown.")
+		return
+	}
+
+	clst.updateCloud(cloudMachines, false)
+}
+
 func (clst cluster) get() ([]provider.Machine, error) {
 	var cloudMachines []provider.Machine
 	for _, p := range clst.providers {
@@ -159,7 +181,7 @@ func (clst cluster) sync() {
 		}
 
 		var dbMachines []db.Machine
-		clst.conn.Transact(func(view db.Database) error {
+		clst.conn.ReadTransact(func(view db.Database) error {
 			dbMachines = view.SelectFromMachine(func(m db.Machine) bool {
 				return m.ClusterID == clst.id
 			})
@@ -237,7 +259,9 @@ func syncDB(cloudMachines []provider.Machine, dbMachines []db.Machine) (pairs []
 			Provider: m.Provider,
 			Region:   m.Region,
 			DiskSize: m.DiskSize,
-			SSHKeys:  m.SSHKeys})
+			SSHKeys:  m.SSHKeys,
+			Role:     m.Role,
+			Labels:   m.Labels})
 	}
 
 	return pairs, bootSet, terminateSet
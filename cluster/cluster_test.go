@@ -233,6 +233,30 @@ func TestSync(t *testing.T) {
 	checkSync(clst, FakeAmazonSpot, []bootRequest{amazonXLargeBoot}, []string{toRemove.CloudID})
 }
 
+func TestTeardown(t *testing.T) {
+	clst := newTestCluster()
+	clst.conn.Transact(func(view db.Database) error {
+		for _, p := range []db.Provider{FakeAmazonSpot, FakeVagrant} {
+			m := view.InsertMachine()
+			m.ClusterID = clst.id
+			m.Role = db.Master
+			m.Provider = p
+			view.Commit(m)
+		}
+		return nil
+	})
+	clst.sync()
+
+	clst.teardown()
+	for p, inst := range clst.providers {
+		fake := inst.(*fakeProvider)
+		if len(fake.machines) != 0 || len(fake.stopRequests) != 1 {
+			t.Errorf("%s machines weren't torn down: %v", p,
+				spew.Sdump(fake.machines))
+		}
+	}
+}
+
 func emptySlices(slice1 interface{}, slice2 interface{}) bool {
 	return reflect.ValueOf(slice1).Len() == 0 && reflect.ValueOf(slice2).Len() == 0
 }
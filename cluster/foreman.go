@@ -3,6 +3,7 @@ package cluster
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
@@ -35,6 +36,7 @@ type foreman struct {
 
 	minions map[string]*minion
 	spec    string
+	secrets map[string]string // The secrets referenced by 'spec'.
 
 	// Making this a struct member allows us to mock it out.
 	newClient func(string) (client, error)
@@ -111,7 +113,7 @@ func (fm *foreman) init() {
 
 func (fm *foreman) runOnce() {
 	var machines []db.Machine
-	fm.conn.Transact(func(view db.Database) error {
+	fm.conn.ReadTransact(func(view db.Database) error {
 		machines = view.SelectFromMachine(func(m db.Machine) bool {
 			return m.ClusterID == fm.clusterID && m.PublicIP != "" &&
 				m.PrivateIP != "" && m.CloudID != ""
@@ -122,8 +124,10 @@ func (fm *foreman) runOnce() {
 		})
 
 		fm.spec = ""
+		fm.secrets = nil
 		if len(clusters) == 1 {
 			fm.spec = clusters[0].Spec
+			fm.secrets = clusterSecrets(view, clusters[0])
 		}
 
 		return nil
@@ -171,9 +175,10 @@ func (fm *foreman) runOnce() {
 			Role:      db.RoleToPB(m.machine.Role),
 			PrivateIP: m.machine.PrivateIP,
 			Spec:      fm.spec,
+			Secrets:   fm.secrets,
 		}
 
-		if newConfig == m.config {
+		if reflect.DeepEqual(newConfig, m.config) {
 			return
 		}
 
@@ -189,6 +194,28 @@ func (fm *foreman) runOnce() {
 	})
 }
 
+// clusterSecrets returns the values of the secrets that the spec of 'cluster'
+// refers to.
+func clusterSecrets(view db.Database, cluster db.Cluster) map[string]string {
+	names := map[string]struct{}{}
+	for _, name := range cluster.Secrets {
+		names[name] = struct{}{}
+	}
+
+	var secrets map[string]string
+	for _, s := range view.SelectFromSecret(nil) {
+		if _, ok := names[s.Name]; !ok {
+			continue
+		}
+
+		if secrets == nil {
+			secrets = map[string]string{}
+		}
+		secrets[s.Name] = s.Value
+	}
+	return secrets
+}
+
 func (fm *foreman) updateMinionMap(machines []db.Machine) {
 	for _, m := range machines {
 		min, ok := fm.minions[m.PublicIP]
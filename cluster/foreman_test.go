@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/NetSys/di/db"
@@ -122,6 +123,38 @@ func TestBoot(t *testing.T) {
 	}
 }
 
+func TestSecrets(t *testing.T) {
+	fm, clients := startTest()
+	fm.conn.Transact(func(view db.Database) error {
+		c := view.InsertCluster()
+		c.Spec = "spec"
+		c.Secrets = []string{"used"}
+		view.Commit(c)
+
+		for _, name := range []string{"used", "unused"} {
+			s := view.InsertSecret()
+			s.Name = name
+			s.Value = name + "Value"
+			view.Commit(s)
+		}
+
+		m := view.InsertMachine()
+		m.ClusterID = c.ID
+		m.PublicIP = "1.1.1.1"
+		m.PrivateIP = "1.1.1.1"
+		m.CloudID = "ID"
+		view.Commit(m)
+		return nil
+	})
+
+	fm.runOnce()
+	mc := clients.clients["1.1.1.1"].mc
+	exp := map[string]string{"used": "usedValue"}
+	if mc.Spec != "spec" || !reflect.DeepEqual(mc.Secrets, exp) {
+		t.Errorf("bad minion config: %s", spew.Sdump(mc))
+	}
+}
+
 func startTest() (foreman, *clients) {
 	fm := createForeman(db.New(), 1)
 	clients := &clients{make(map[string]*fakeClient), 0}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+// An Administrator may log into the machines of a cluster with its SSH keys, from
+// the source addresses in its ACLs.
+type Administrator struct {
+	ID        int
+	ClusterID int
+
+	Name    string   // The github username, or empty for plaintext keys.
+	SSHKeys []string `rowStringer:"omit"`
+	ACLs    []string // Source CIDRs, or "local" for the IP of the controller.
+}
+
+// InsertAdministrator creates a new administrator row and inserts it into the
+// database.
+func (db Database) InsertAdministrator() Administrator {
+	result := Administrator{ID: db.nextID()}
+	db.insert(result)
+	return result
+}
+
+// SelectFromAdministrator gets all administrators in the database that satisfy
+// 'check'.
+func (db Database) SelectFromAdministrator(
+	check func(Administrator) bool) []Administrator {
+	var result []Administrator
+	for _, row := range db.tables[AdministratorTable].rows {
+		if check == nil || check(row.(Administrator)) {
+			result = append(result, row.(Administrator))
+		}
+	}
+
+	return result
+}
+
+// SortAdministrators returns a slice of administrators sorted according to the
+// default database sort order.
+func SortAdministrators(admins []Administrator) []Administrator {
+	rows := make([]row, 0, len(admins))
+	for _, a := range admins {
+		rows = append(rows, a)
+	}
+
+	sort.Sort(rowSlice(rows))
+
+	admins = make([]Administrator, 0, len(admins))
+	for _, r := range rows {
+		admins = append(admins, r.(Administrator))
+	}
+
+	return admins
+}
+
+func (a Administrator) String() string {
+	name := a.Name
+	if name == "" {
+		name = "plaintext"
+	}
+	return fmt.Sprintf("Administrator-%d{%s, ACLs: %s}", a.ID, name, a.ACLs)
+}
+
+func (a Administrator) less(r row) bool {
+	o := r.(Administrator)
+
+	switch {
+	case a.ClusterID != o.ClusterID:
+		return a.ClusterID < o.ClusterID
+	case a.Name != o.Name:
+		return a.Name < o.Name
+	default:
+		return a.ID < o.ID
+	}
+}
@@ -9,8 +9,36 @@ type Cluster struct {
 	Namespace string // Cloud Provider Namespace
 	Spec      string
 
-	/* XXX: These belong in a separate Adminstration table of some sort. */
+	// The source CIDRs allowed through the cloud firewalls.  Derived from the
+	// administrator table and the IPs of the cluster's machines.
 	ACLs []string
+
+	// The port ranges that must be reachable from the public internet, as
+	// required by the spec's connections from "public".
+	PublicPorts []PortRange
+
+	// The names of the secrets the spec refers to, which are sent to the
+	// cluster's minions along with it.
+	Secrets []string
+}
+
+// A PortRange is an inclusive range of ports of a protocol.
+type PortRange struct {
+	Protocol string // "tcp" or "udp".
+	MinPort  int
+	MaxPort  int
+}
+
+// Ports returns the range of ports, without its protocol.
+func (pr PortRange) Ports() string {
+	if pr.MinPort == pr.MaxPort {
+		return fmt.Sprintf("%d", pr.MinPort)
+	}
+	return fmt.Sprintf("%d-%d", pr.MinPort, pr.MaxPort)
+}
+
+func (pr PortRange) String() string {
+	return pr.Ports() + "/" + pr.Protocol
 }
 
 // InsertCluster creates a new Cluster and interts it into 'db'.
@@ -41,8 +69,8 @@ func (c Cluster) tt() TableType {
 }
 
 func (c Cluster) String() string {
-	return fmt.Sprintf("Cluster-%d{%s, ACL: %s}",
-		c.ID, c.Namespace, c.ACLs)
+	return fmt.Sprintf("Cluster-%d{%s, ACL: %s, PublicPorts: %s}",
+		c.ID, c.Namespace, c.ACLs, c.PublicPorts)
 }
 
 func (c Cluster) less(r row) bool {
@@ -3,14 +3,19 @@ package db
 import "fmt"
 
 // A Connection allows the members of two labels to speak to each other on the port range
-// [MinPort, MaxPort] inclusive.
+// [MinPort, MaxPort] inclusive.  An empty Protocol allows tcp, udp, and icmp.
+//
+// If Deny is set, the traffic is instead forbidden, even if another connection
+// allows it.
 type Connection struct {
 	ID int
 
-	From    string
-	To      string
-	MinPort int
-	MaxPort int
+	From     string
+	To       string
+	Protocol string
+	MinPort  int
+	MaxPort  int
+	Deny     bool
 }
 
 // InsertConnection creates a new connection row and inserts it into the database.
@@ -38,7 +43,21 @@ func (c Connection) String() string {
 		port += fmt.Sprintf("-%d", c.MaxPort)
 	}
 
-	return fmt.Sprintf("Connection-%d{%s->%s:%s}", c.ID, c.From, c.To, port)
+	switch c.Protocol {
+	case "":
+	case "icmp":
+		port = c.Protocol
+	default:
+		port = c.Protocol + "/" + port
+	}
+
+	arrow := "->"
+	if c.Deny {
+		arrow = "-x"
+	}
+
+	return fmt.Sprintf("Connection-%d{%s%s%s:%s}", c.ID, c.From, arrow, c.To,
+		port)
 }
 
 func (c Connection) less(r row) bool {
@@ -49,6 +68,10 @@ func (c Connection) less(r row) bool {
 		return c.From < o.From
 	case c.To != o.To:
 		return c.To < o.To
+	case c.Protocol != o.Protocol:
+		return c.Protocol < o.Protocol
+	case c.Deny != o.Deny:
+		return !c.Deny
 	case c.MaxPort != o.MaxPort:
 		return c.MaxPort < o.MaxPort
 	case c.MinPort != o.MaxPort:
@@ -77,12 +77,15 @@ const (
 
 	// Azure implements the Azure cloud provider.
 	Azure = "Azure"
+
+	// Local implements machines as containers on the local docker daemon.
+	Local = "Local"
 )
 
 // ParseProvider returns the Provider represented by 'name' or an error.
 func ParseProvider(name string) (Provider, error) {
 	switch name {
-	case "AmazonSpot", "Google", "Vagrant", "Azure":
+	case "AmazonSpot", "Google", "Vagrant", "Azure", "Local":
 		return Provider(name), nil
 	default:
 		return "", errors.New("unknown provider")
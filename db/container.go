@@ -21,13 +21,70 @@ type Container struct {
 	Command []string
 	Labels  []string
 	Env     map[string]string
+	Volumes []string
+
+	// Maps environment variables to the names of the secrets that provide their
+	// values.  The values are filled in when the container is booted.
+	SecretEnv map[string]string
+
+	HealthCheck HealthCheck
+	Health      string // Healthy, Unhealthy, or empty if unknown.
+
+	// Files the worker keeps inside the container.  Changing them doesn't
+	// restart the container.
+	Files []File
 
 	Placement
+	Resources
+}
+
+const (
+	// Healthy containers passed their most recent health check.
+	Healthy = "healthy"
+
+	// Unhealthy containers failed several health checks in a row.
+	Unhealthy = "unhealthy"
+)
+
+// A HealthCheck describes how to probe whether a container is working.
+type HealthCheck struct {
+	Type    string   // "tcp", "http" or "exec".  Empty if there's no check.
+	Port    int      // For "tcp" and "http" checks.
+	Path    string   // For "http" checks.
+	Command []string // For "exec" checks.
+}
+
+// A File is kept at Path inside a container, with the given Content and Mode.
+type File struct {
+	Path    string
+	Content string
+	Mode    int
 }
 
 // Placement represents scheduler placement constraints.
 type Placement struct {
 	Exclusive map[[2]string]struct{}
+
+	// Pairs of labels whose containers must share a host.  The containers of the
+	// second label are placed next to those of the first.
+	Colocate map[[2]string]struct{}
+
+	Machine MachinePlacement
+}
+
+// MachinePlacement pins a container to machines with the given attributes.  Empty
+// fields are unconstrained.
+type MachinePlacement struct {
+	Role     string
+	Region   string
+	Provider string
+	Labels   []string
+}
+
+// Resources limit what a container may consume.  Zero means unlimited.
+type Resources struct {
+	Memory    int // In megabytes.
+	CPUShares int
 }
 
 // InsertContainer creates a new container row and inserts it into the database.
@@ -52,7 +109,7 @@ func (db Database) SelectFromContainer(check func(Container) bool) []Container {
 // SelectFromContainer gets all containers in the database that satisfy the 'check'.
 func (conn Conn) SelectFromContainer(check func(Container) bool) []Container {
 	var containers []Container
-	conn.Transact(func(view Database) error {
+	conn.ReadTransact(func(view Database) error {
 		containers = view.SelectFromContainer(check)
 		return nil
 	})
@@ -88,10 +145,51 @@ func (c Container) String() string {
 		tags = append(tags, fmt.Sprintf("Placement: %s", c.Placement.Exclusive))
 	}
 
+	if len(c.Placement.Colocate) > 0 {
+		tags = append(tags, fmt.Sprintf("Colocate: %s", c.Placement.Colocate))
+	}
+
+	if c.Placement.Machine.Role != "" || c.Placement.Machine.Region != "" ||
+		c.Placement.Machine.Provider != "" || len(c.Placement.Machine.Labels) > 0 {
+		tags = append(tags, fmt.Sprintf("Machine: %v", c.Placement.Machine))
+	}
+
+	if c.Memory != 0 {
+		tags = append(tags, fmt.Sprintf("Memory: %dMB", c.Memory))
+	}
+
+	if c.CPUShares != 0 {
+		tags = append(tags, fmt.Sprintf("CPUShares: %d", c.CPUShares))
+	}
+
 	if len(c.Env) > 0 {
 		tags = append(tags, fmt.Sprintf("Env: %s", c.Env))
 	}
 
+	if len(c.SecretEnv) > 0 {
+		tags = append(tags, fmt.Sprintf("SecretEnv: %s", c.SecretEnv))
+	}
+
+	if len(c.Volumes) > 0 {
+		tags = append(tags, fmt.Sprintf("Volumes: %s", c.Volumes))
+	}
+
+	if len(c.Files) > 0 {
+		var paths []string
+		for _, f := range c.Files {
+			paths = append(paths, f.Path)
+		}
+		tags = append(tags, fmt.Sprintf("Files: %s", paths))
+	}
+
+	if c.HealthCheck.Type != "" {
+		tags = append(tags, fmt.Sprintf("HealthCheck: %s", c.HealthCheck.Type))
+	}
+
+	if c.Health != "" {
+		tags = append(tags, fmt.Sprintf("Health: %s", c.Health))
+	}
+
 	return fmt.Sprintf("Container-%d{%s}", c.ID, strings.Join(tags, ", "))
 }
 
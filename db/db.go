@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -11,8 +12,9 @@ import (
 // engine populates the database with a preferred state of the world, while various
 // modules flesh out that policy with actual implementation details.
 type Database struct {
-	tables  map[TableType]*table
-	idAlloc *int
+	tables   map[TableType]*table
+	idAlloc  *int
+	readOnly bool
 }
 
 // A Trigger sends notifications when anything in their corresponding table changes.
@@ -26,43 +28,66 @@ type row interface {
 	String() string
 }
 
-type transaction struct {
-	do   func(db Database) error
-	done chan error
-}
-
 // A Conn is a database handle on which transactions may be executed.
-type Conn chan transaction
+type Conn struct {
+	db    Database
+	store *store
+	lock  *sync.RWMutex
+}
 
 // New creates a connection to a brand new database.
 func New() Conn {
-	db := Database{make(map[TableType]*table), new(int)}
+	return start(newDatabase(), nil)
+}
+
+func newDatabase() Database {
+	db := Database{tables: make(map[TableType]*table), idAlloc: new(int)}
 	for _, t := range allTables {
 		db.tables[t] = newTable()
 	}
-
-	cn := make(Conn)
-	go cn.run(db)
-	cn.runLogger()
-	return cn
+	return db
 }
 
-func (cn Conn) run(db Database) {
-	for txn := range cn {
-		txn.done <- txn.do(db)
-		for _, table := range db.tables {
-			table.alert()
-		}
+func start(db Database, s *store) Conn {
+	cn := Conn{db: db, store: s, lock: &sync.RWMutex{}}
+	cn.runLogger()
+	if s != nil {
+		go s.run(cn)
 	}
+	return cn
 }
 
 // Transact executes database transactions.  It takes a closure, 'do', which is operates
 // on its 'db' argument.  Transactions are not concurrent, instead each runs sequentially
 // on it's database without conflicting with other transactions.
 func (cn Conn) Transact(do func(db Database) error) error {
-	txn := transaction{do, make(chan error)}
-	cn <- txn
-	return <-txn.done
+	cn.lock.Lock()
+	defer cn.lock.Unlock()
+
+	err := do(cn.db)
+	if cn.store != nil {
+		cn.store.sync(cn.db)
+	}
+	cn.notify(cn.db)
+
+	for _, table := range cn.db.tables {
+		table.alert()
+	}
+
+	return err
+}
+
+// ReadTransact executes a read-only transaction.  Unlike Transact, any number of
+// read-only transactions may run concurrently, each with a consistent view of the
+// database, though never at the same time as a Transact.  'do' must not modify its
+// 'db' argument, attempting to do so panics.
+func (cn Conn) ReadTransact(do func(db Database) error) error {
+	cn.lock.RLock()
+	defer cn.lock.RUnlock()
+
+	view := cn.db
+	view.readOnly = true
+	return do(view)
 }
 
 // Trigger registers a new database trigger that watches changes to 'tableName'.  Any
@@ -113,13 +138,16 @@ func (t Trigger) Stop() {
 }
 
 func (db Database) insert(r row) {
+	db.checkWritable()
 	table := db.tables[getTableType(r)]
 	table.seq++
 	table.rows[getID(r)] = r
+	table.record(getID(r), nil, r)
 }
 
 // Commit update the database with the data contained in row.
 func (db Database) Commit(r row) {
+	db.checkWritable()
 	rid := getID(r)
 	table := db.tables[getTableType(r)]
 	old := table.rows[rid]
@@ -131,21 +159,34 @@ func (db Database) Commit(r row) {
 	if !reflect.DeepEqual(table.rows[rid], r) {
 		table.rows[rid] = r
 		table.seq++
+		table.record(rid, old, r)
 	}
 }
 
 // Remove deletes row from the database.
 func (db Database) Remove(r row) {
+	db.checkWritable()
+	rid := getID(r)
 	table := db.tables[getTableType(r)]
-	delete(table.rows, getID(r))
+	if old, ok := table.rows[rid]; ok {
+		table.record(rid, old, nil)
+	}
+	delete(table.rows, rid)
 	table.seq++
 }
 
 func (db Database) nextID() int {
+	db.checkWritable()
 	*db.idAlloc++
 	return *db.idAlloc
 }
 
+func (db Database) checkWritable() {
+	if db.readOnly {
+		panic("Write in a read-only transaction")
+	}
+}
+
 type rowSlice []row
 
 func (rows rowSlice) Len() int {
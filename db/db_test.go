@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/NetSys/di/util"
+
 	"github.com/davecgh/go-spew/spew"
+	"github.com/spf13/afero"
 )
 
 func TestMachine(t *testing.T) {
@@ -160,6 +164,328 @@ func triggerNoRecv(t *testing.T, trig Trigger) {
 	}
 }
 
+func TestReadTransact(t *testing.T) {
+	conn := New()
+	conn.Transact(func(db Database) error {
+		db.InsertMachine()
+		return nil
+	})
+
+	// Readers may hold the database at the same time.
+	inside := make(chan struct{})
+	release := make(chan struct{})
+	go conn.ReadTransact(func(db Database) error {
+		inside <- struct{}{}
+		<-release
+		return nil
+	})
+	<-inside
+
+	done := make(chan struct{})
+	go func() {
+		if minions := conn.SelectFromMinion(nil); len(minions) != 0 {
+			t.Errorf("unexpected minions: %v", minions)
+		}
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("concurrent read-only transaction blocked")
+	}
+
+	// But writers wait for them to finish.
+	go func() {
+		conn.Transact(func(db Database) error {
+			db.InsertMachine()
+			return nil
+		})
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("write ran concurrently with a read-only transaction")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write never ran")
+	}
+
+	var panicked bool
+	conn.ReadTransact(func(db Database) error {
+		defer func() { panicked = recover() != nil }()
+		db.InsertMachine()
+		return nil
+	})
+	if !panicked {
+		t.Error("expected a write in a read-only transaction to panic")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	conn := New()
+	sub := conn.Subscribe(MachineTable)
+
+	var m Machine
+	conn.Transact(func(db Database) error {
+		m = db.InsertMachine()
+		m.Role = Master
+		db.Commit(m)
+
+		// Changes to other tables aren't delivered.
+		db.InsertCluster()
+		return nil
+	})
+	eventRecv(t, sub, Event{Insert, MachineTable, nil, m})
+
+	old := m
+	conn.Transact(func(db Database) error {
+		m.PublicIP = "1.2.3.4"
+		db.Commit(m)
+		m.PrivateIP = "5.6.7.8"
+		db.Commit(m)
+		return nil
+	})
+	eventRecv(t, sub, Event{Update, MachineTable, old, m})
+
+	conn.Transact(func(db Database) error {
+		db.Remove(m)
+
+		// A row that's inserted and removed within a transaction is invisible.
+		db.Remove(db.InsertMachine())
+		return nil
+	})
+	eventRecv(t, sub, Event{Delete, MachineTable, m, nil})
+	eventNoRecv(t, sub)
+
+	// Events queue up rather than being dropped while the subscriber is busy.
+	for i := 0; i < 3; i++ {
+		conn.Transact(func(db Database) error {
+			db.InsertMachine()
+			return nil
+		})
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-sub.C:
+			if e.Type != Insert {
+				t.Errorf("expected an insert, got %s", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected an event")
+		}
+	}
+
+	// A subscriber that falls too far behind gets an Overflow instead.
+	conn.Transact(func(db Database) error {
+		for i := 0; i <= maxQueuedEvents; i++ {
+			db.InsertMachine()
+		}
+		return nil
+	})
+	conn.Transact(func(db Database) error {
+		db.InsertMachine()
+		return nil
+	})
+	eventRecv(t, sub, Event{Type: Overflow})
+	eventNoRecv(t, sub)
+
+	conn.Transact(func(db Database) error {
+		m = db.InsertMachine()
+		return nil
+	})
+	eventRecv(t, sub, Event{Insert, MachineTable, nil, m})
+
+	sub.Stop()
+	conn.ReadTransact(func(db Database) error {
+		if n := len(db.tables[MachineTable].subscriptions); n != 0 {
+			t.Errorf("%d subscriptions remain after Stop", n)
+		}
+		return nil
+	})
+
+	conn.Transact(func(db Database) error {
+		db.InsertMachine()
+		return nil
+	})
+	eventNoRecv(t, sub)
+}
+
+func eventRecv(t *testing.T, sub Subscription, expected Event) {
+	select {
+	case e := <-sub.C:
+		if !reflect.DeepEqual(e, expected) {
+			t.Errorf("unexpected event %s, expected %s", e, expected)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected event %s", expected)
+	}
+}
+
+func eventNoRecv(t *testing.T, sub Subscription) {
+	select {
+	case e := <-sub.C:
+		t.Errorf("unexpected event %s", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPersist(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+
+	conn, err := Open("db.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Transact(func(db Database) error {
+		cluster := db.InsertCluster()
+		cluster.Namespace = "ns"
+		cluster.Spec = "(docker \"alpine\")"
+		cluster.ACLs = []string{"1.2.3.4/32"}
+		cluster.Secrets = []string{"password"}
+		db.Commit(cluster)
+
+		machine := db.InsertMachine()
+		machine.ClusterID = cluster.ID
+		machine.Role = Master
+		machine.Provider = AmazonSpot
+		machine.Size = "m4.large"
+		machine.SSHKeys = []string{"key"}
+		machine.CloudID = "cloud"
+		machine.PublicIP = "5.6.7.8"
+		db.Commit(machine)
+
+		container := db.InsertContainer()
+		container.Image = "alpine"
+		container.Command = []string{"tail"}
+		container.Labels = []string{"a", "b"}
+		container.Env = map[string]string{"k": "v"}
+		container.SecretEnv = map[string]string{"PASSWORD": "password"}
+		container.Exclusive = map[[2]string]struct{}{{"a", "b"}: {}}
+		container.Colocate = map[[2]string]struct{}{{"b", "c"}: {}}
+		container.Machine.Labels = []string{"ssd"}
+		db.Commit(container)
+
+		minion := db.InsertMinion()
+		minion.MinionID = "minion"
+		minion.Role = Worker
+		db.Commit(minion)
+
+		connection := db.InsertConnection()
+		connection.From = "a"
+		connection.To = "b"
+		connection.MinPort = 80
+		connection.MaxPort = 80
+		db.Commit(connection)
+
+		label := db.InsertLabel()
+		label.Label = "a"
+		label.IP = "10.0.0.1"
+		db.Commit(label)
+
+		admin := db.InsertAdministrator()
+		admin.ClusterID = cluster.ID
+		admin.Name = "ejj"
+		admin.SSHKeys = []string{"key"}
+		admin.ACLs = []string{"local"}
+		db.Commit(admin)
+
+		host := db.InsertHost()
+		host.Hostname = "external.org"
+		host.Labels = []string{"ext"}
+		db.Commit(host)
+
+		secret := db.InsertSecret()
+		secret.Name = "password"
+		secret.Value = "hunter2"
+		db.Commit(secret)
+
+		version := db.InsertSpecVersion()
+		version.Version = 1
+		version.Namespace = "ns"
+		version.Time = time.Date(2016, 8, 1, 12, 0, 0, 0, time.UTC)
+		version.Source = "config.spec"
+		version.Spec = cluster.Spec
+		db.Commit(version)
+
+		etcd := db.InsertEtcd()
+		etcd.EtcdIPs = []string{"10.0.0.2"}
+		db.Commit(etcd)
+		return nil
+	})
+
+	var expected Database
+	conn.Transact(func(db Database) error {
+		expected = db
+		return nil
+	})
+
+	// A failed write must be retried, even though nothing changed since.
+	fs := util.AppFs
+	util.AppFs = afero.NewReadOnlyFs(fs)
+	if err := conn.store.write(conn); err == nil {
+		t.Error("expected an error writing to a read-only filesystem")
+	}
+
+	util.AppFs = fs
+	if err := conn.store.write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := Open("db.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded.Transact(func(db Database) error {
+		for _, tt := range allTables {
+			exp, act := expected.tables[tt].rows, db.tables[tt].rows
+			if tt == SecretTable {
+				if len(act) != 0 {
+					t.Errorf("secrets were persisted: %s", spew.Sdump(act))
+				}
+				continue
+			}
+
+			if len(exp) == 0 {
+				t.Errorf("no rows in %s", tt)
+			}
+
+			if !reflect.DeepEqual(exp, act) {
+				t.Errorf("%s did not round trip: %s\nExpected %s",
+					tt, spew.Sdump(act), spew.Sdump(exp))
+			}
+		}
+
+		if id := db.nextID(); id != 12 {
+			t.Errorf("expected ID 12 after reload, got %d", id)
+		}
+		return nil
+	})
+
+	data, _ := afero.ReadFile(util.AppFs, "db.json")
+	if strings.Contains(string(data), "hunter2") {
+		t.Error("secret value written to disk")
+	}
+
+	util.AppFs.Remove("db.json")
+	if _, err := Open("missing.json"); err != nil {
+		t.Errorf("unexpected error opening a new database: %s", err)
+	}
+
+	util.WriteFile("bad.json", []byte("garbage"), 0644)
+	if _, err := Open("bad.json"); err == nil {
+		t.Error("expected an error opening a malformed database")
+	}
+}
+
 func SelectMachineCheck(db Database, do func(Machine) bool, expected []Machine) error {
 	query := db.SelectFromMachine(do)
 	sort.Sort(mSort(expected))
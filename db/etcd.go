@@ -36,7 +36,7 @@ func (db Database) SelectFromEtcd(check func(Etcd) bool) []Etcd {
 // SelectFromEtcd gets all Etcd rows in the database connection that satisfy the 'check'.
 func (conn Conn) SelectFromEtcd(check func(Etcd) bool) []Etcd {
 	var etcdRows []Etcd
-	conn.Transact(func(view Database) error {
+	conn.ReadTransact(func(view Database) error {
 		etcdRows = view.SelectFromEtcd(check)
 		return nil
 	})
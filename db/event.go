@@ -0,0 +1,198 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// An EventType describes how a row changed.
+type EventType int
+
+const (
+	// Insert events are delivered for new rows.
+	Insert EventType = iota
+
+	// Update events are delivered for modified rows.
+	Update
+
+	// Delete events are delivered for removed rows.
+	Delete
+
+	// Overflow events are delivered in place of the queued events of a subscriber
+	// that fell more than maxQueuedEvents behind.  The subscriber must re-read the
+	// tables it watches, which may already reflect the events that follow.
+	Overflow
+)
+
+// The number of events a Subscription queues before giving up on them.
+const maxQueuedEvents = 4096
+
+// An Event describes a change to a single row of a table.  'Old' is nil for inserts,
+// and 'New' is nil for deletes.  Otherwise they hold the row before and after the
+// change, e.g. as db.Machine values.
+//
+// Changes made to a row within a single transaction are coalesced into one Event.
+// For example, inserting a row and then committing to it produces a single Insert.
+type Event struct {
+	Type  EventType
+	Table TableType
+	Old   interface{}
+	New   interface{}
+}
+
+// A Subscription delivers an Event on 'C' for every change to the rows of the tables
+// it watches.  Unlike Trigger notifications, Events aren't dropped, they're queued
+// until the subscriber receives them, unless it falls so far behind that they're
+// replaced by an Overflow event.
+type Subscription struct {
+	C    chan Event // The channel on which events are delivered.
+	in   chan []Event
+	stop chan struct{}
+	conn *Conn
+}
+
+// Subscribe registers a new Subscription that watches changes to the tables in 'tt'.
+func (cn Conn) Subscribe(tt ...TableType) Subscription {
+	sub := Subscription{
+		C:    make(chan Event),
+		in:   make(chan []Event),
+		stop: make(chan struct{}),
+		conn: &cn,
+	}
+	go sub.run()
+
+	cn.Transact(func(db Database) error {
+		for _, t := range tt {
+			db.tables[t].subscriptions[sub] = struct{}{}
+		}
+		return nil
+	})
+
+	return sub
+}
+
+// Stop a running subscription thus allowing resources to be deallocated.  It must
+// not be called from within a transaction.
+func (sub Subscription) Stop() {
+	close(sub.stop)
+	sub.conn.Transact(func(db Database) error {
+		for _, t := range db.tables {
+			delete(t.subscriptions, sub)
+		}
+		return nil
+	})
+}
+
+func (sub Subscription) run() {
+	var queue []Event
+	for {
+		var out chan Event
+		var next Event
+		if len(queue) > 0 {
+			out = sub.C
+			next = queue[0]
+		}
+
+		select {
+		case events := <-sub.in:
+			if len(queue) > 0 && queue[0].Type == Overflow {
+				// The subscriber hasn't yet seen the last overflow, so it
+				// hasn't re-read the tables either.
+				continue
+			}
+
+			queue = append(queue, events...)
+			if len(queue) > maxQueuedEvents {
+				queue = []Event{{Type: Overflow}}
+			}
+		case out <- next:
+			queue = queue[1:]
+		case <-sub.stop:
+			return
+		}
+	}
+}
+
+func (e Event) String() string {
+	var typ string
+	switch e.Type {
+	case Insert:
+		typ = "Insert"
+	case Update:
+		typ = "Update"
+	case Delete:
+		typ = "Delete"
+	case Overflow:
+		return "Overflow"
+	}
+	return fmt.Sprintf("%s{%v -> %v}", typ, e.Old, e.New)
+}
+
+// record logs a change to the row 'id' of 't' for delivery to subscriptions.
+func (t *table) record(id int, oldRow, newRow row) {
+	if len(t.subscriptions) == 0 {
+		return
+	}
+
+	if i, ok := t.pending[id]; ok {
+		t.changes[i].New = newRow
+		return
+	}
+
+	t.pending[id] = len(t.changes)
+	t.changes = append(t.changes, Event{Old: oldRow, New: newRow})
+}
+
+// events returns the changes made to 't' since the last call to events().
+func (t *table) events(tt TableType) []Event {
+	var result []Event
+	for _, e := range t.changes {
+		e.Table = tt
+		switch {
+		case e.Old == nil && e.New == nil:
+			continue // Inserted and then removed.
+		case e.Old == nil:
+			e.Type = Insert
+		case e.New == nil:
+			e.Type = Delete
+		case reflect.DeepEqual(e.Old, e.New):
+			continue
+		default:
+			e.Type = Update
+		}
+		result = append(result, e)
+	}
+
+	t.changes = nil
+	t.pending = make(map[int]int)
+	return result
+}
+
+func (cn Conn) notify(db Database) {
+	batches := map[Subscription][]Event{}
+	for _, tt := range allTables {
+		t := db.tables[tt]
+		if len(t.changes) == 0 {
+			continue
+		}
+
+		events := t.events(tt)
+		for sub := range t.subscriptions {
+			batches[sub] = append(batches[sub], events...)
+		}
+	}
+
+	for sub, events := range batches {
+		if len(events) == 0 {
+			continue
+		}
+
+		select {
+		case sub.in <- events:
+		case <-sub.stop:
+			for _, t := range db.tables {
+				delete(t.subscriptions, sub)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Host is an external service, outside of the cluster, that containers may
+// connect to through the labels applied to it.
+type Host struct {
+	ID int
+
+	Hostname string // A hostname, IP address, or CIDR block.
+	Labels   []string
+}
+
+// InsertHost creates a new host row and inserts it into the database.
+func (db Database) InsertHost() Host {
+	result := Host{ID: db.nextID()}
+	db.insert(result)
+	return result
+}
+
+// SelectFromHost gets all hosts in the database that satisfy 'check'.
+func (db Database) SelectFromHost(check func(Host) bool) []Host {
+	var result []Host
+	for _, row := range db.tables[HostTable].rows {
+		if check == nil || check(row.(Host)) {
+			result = append(result, row.(Host))
+		}
+	}
+
+	return result
+}
+
+// SelectFromHost gets all hosts in the database connection that satisfy 'check'.
+func (conn Conn) SelectFromHost(check func(Host) bool) []Host {
+	var result []Host
+	conn.ReadTransact(func(view Database) error {
+		result = view.SelectFromHost(check)
+		return nil
+	})
+	return result
+}
+
+func (h Host) String() string {
+	return fmt.Sprintf("Host-%d{%s, Labels: %s}", h.ID, h.Hostname,
+		strings.Join(h.Labels, ", "))
+}
+
+func (h Host) less(r row) bool {
+	o := r.(Host)
+
+	switch {
+	case h.Hostname != o.Hostname:
+		return h.Hostname < o.Hostname
+	default:
+		return h.ID < o.ID
+	}
+}
@@ -32,7 +32,7 @@ func (db Database) SelectFromLabel(check func(Label) bool) []Label {
 // SelectFromLabel gets all containers in the database connection that satisfy 'check'.
 func (conn Conn) SelectFromLabel(check func(Label) bool) []Label {
 	var result []Label
-	conn.Transact(func(view Database) error {
+	conn.ReadTransact(func(view Database) error {
 		result = view.SelectFromLabel(check)
 		return nil
 	})
@@ -17,6 +17,7 @@ type Machine struct {
 	Size      string
 	DiskSize  int
 	SSHKeys   []string `rowStringer:"omit"`
+	Labels    []string // Containers may be pinned to machines by these.
 
 	/* Populated by the cloud provider. */
 	CloudID   string //Cloud Provider ID
@@ -8,6 +8,9 @@ type Minion struct {
 	MinionID  string
 	Role      Role
 	PrivateIP string
+
+	// The most recent spec from the foreman.
+	Spec string `rowStringer:"omit"`
 }
 
 // InsertMinion creates a new Minion and inserts it into 'db'.
@@ -31,7 +34,7 @@ func (db Database) SelectFromMinion(check func(Minion) bool) []Minion {
 // SelectFromMinion gets all minions in the database that satisfy the 'check'.
 func (conn Conn) SelectFromMinion(check func(Minion) bool) []Minion {
 	var minions []Minion
-	conn.Transact(func(view Database) error {
+	conn.ReadTransact(func(view Database) error {
 		minions = view.SelectFromMinion(check)
 		return nil
 	})
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/NetSys/di/util"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The on-disk form of the database.  The entire database is rewritten to disk
+// shortly after transactions change it, so this is only appropriate for databases
+// that stay small such as the controller's.
+type snapshot struct {
+	Tables map[TableType]json.RawMessage
+}
+
+// JSON can't encode maps keyed by arrays, so the placement constraints of a
+// container are flattened into lists of pairs on disk.
+type containerJSON struct {
+	Container
+	Exclusive [][2]string
+	Colocate  [][2]string
+}
+
+// The tables written to disk.  Secrets are deliberately absent, so that their
+// values never touch the disk.
+var rowTypes = map[TableType]reflect.Type{
+	ClusterTable:       reflect.TypeOf(Cluster{}),
+	MachineTable:       reflect.TypeOf(Machine{}),
+	ContainerTable:     reflect.TypeOf(containerJSON{}),
+	MinionTable:        reflect.TypeOf(Minion{}),
+	ConnectionTable:    reflect.TypeOf(Connection{}),
+	LabelTable:         reflect.TypeOf(Label{}),
+	HostTable:          reflect.TypeOf(Host{}),
+	AdministratorTable: reflect.TypeOf(Administrator{}),
+	SpecVersionTable:   reflect.TypeOf(SpecVersion{}),
+	EtcdTable:          reflect.TypeOf(Etcd{}),
+}
+
+// How long the store waits after a change before writing the database, so that
+// a burst of transactions results in a single write.
+const saveDelay = 100 * time.Millisecond
+
+type store struct {
+	path string
+	kick chan struct{}
+
+	// Held while writing 'path'.
+	writeMutex sync.Mutex
+
+	// The sequence numbers of the tables as of the last successful write.
+	seqs      map[TableType]int
+	seqsMutex sync.Mutex
+}
+
+// Open creates a connection to a database backed by the file at 'path'.  Rows
+// saved by a previous run are loaded, and changes are written back to 'path'.
+func Open(path string) (Conn, error) {
+	db := newDatabase()
+	if err := load(db, path); err != nil {
+		return Conn{}, err
+	}
+
+	s := &store{path: path, kick: make(chan struct{}, 1)}
+	s.seqs = s.changed(db)
+	return start(db, s), nil
+}
+
+func load(db Database, path string) error {
+	f, err := util.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("malformed database %s: %s", path, err)
+	}
+
+	for tt, raw := range snap.Tables {
+		rowType, ok := rowTypes[tt]
+		if !ok {
+			return fmt.Errorf("unknown table %s in %s", tt, path)
+		}
+
+		slice := reflect.New(reflect.SliceOf(rowType))
+		if err := json.Unmarshal(raw, slice.Interface()); err != nil {
+			return fmt.Errorf("malformed table %s in %s: %s", tt, path, err)
+		}
+
+		for i := 0; i < slice.Elem().Len(); i++ {
+			r := fromDisk(slice.Elem().Index(i).Interface())
+			db.insert(r)
+			if id := getID(r); id > *db.idAlloc {
+				*db.idAlloc = id
+			}
+		}
+	}
+
+	return nil
+}
+
+// sync schedules a write of 'db' if it has changed since it was last written.  It's
+// called at the end of each transaction, so it doesn't write anything itself.  If
+// a write fails, the next transaction schedules another.
+func (s *store) sync(db Database) {
+	if s.changed(db) == nil {
+		return
+	}
+
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// changed returns the sequence numbers of the persisted tables of 'db', or nil if
+// they're the same as those last written to disk.
+func (s *store) changed(db Database) map[TableType]int {
+	s.seqsMutex.Lock()
+	defer s.seqsMutex.Unlock()
+
+	seqs := map[TableType]int{}
+	changed := false
+	for tt := range rowTypes {
+		seqs[tt] = db.tables[tt].seq
+		if s.seqs[tt] != seqs[tt] {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return seqs
+}
+
+func (s *store) run(cn Conn) {
+	for range s.kick {
+		time.Sleep(saveDelay)
+		if err := s.write(cn); err != nil {
+			log.WithError(err).Error("Failed to persist the database.")
+		}
+	}
+}
+
+// write saves the contents of 'cn' to disk, if they've changed.  The database is
+// only locked while it's encoded, and not while the file is written.
+func (s *store) write(cn Conn) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	var data []byte
+	var err error
+	cn.lock.RLock()
+	seqs := s.changed(cn.db)
+	if seqs != nil {
+		data, err = encode(cn.db)
+	}
+	cn.lock.RUnlock()
+
+	if seqs == nil || err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash mid-write can't
+	// corrupt the existing snapshot.
+	tmp := s.path + ".tmp"
+	if err := util.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	if err := util.AppFs.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.seqsMutex.Lock()
+	s.seqs = seqs
+	s.seqsMutex.Unlock()
+	return nil
+}
+
+func encode(db Database) ([]byte, error) {
+	snap := snapshot{Tables: map[TableType]json.RawMessage{}}
+	for tt, t := range db.tables {
+		if _, ok := rowTypes[tt]; !ok {
+			continue
+		}
+
+		var rows []interface{}
+		for _, r := range t.rows {
+			rows = append(rows, toDisk(r))
+		}
+
+		raw, err := json.Marshal(rows)
+		if err != nil {
+			return nil, err
+		}
+		snap.Tables[tt] = raw
+	}
+
+	return json.MarshalIndent(snap, "", "\t")
+}
+
+func toDisk(r row) interface{} {
+	c, ok := r.(Container)
+	if !ok {
+		return r
+	}
+
+	return containerJSON{
+		Container: c,
+		Exclusive: pairsToDisk(c.Exclusive),
+		Colocate:  pairsToDisk(c.Colocate),
+	}
+}
+
+func fromDisk(r interface{}) row {
+	c, ok := r.(containerJSON)
+	if !ok {
+		return r.(row)
+	}
+
+	result := c.Container
+	result.Exclusive = pairsFromDisk(c.Exclusive)
+	result.Colocate = pairsFromDisk(c.Colocate)
+	return result
+}
+
+func pairsToDisk(pairs map[[2]string]struct{}) [][2]string {
+	var result [][2]string
+	for pair := range pairs {
+		result = append(result, pair)
+	}
+	return result
+}
+
+func pairsFromDisk(pairs [][2]string) map[[2]string]struct{} {
+	if pairs == nil {
+		return nil
+	}
+
+	result := map[[2]string]struct{}{}
+	for _, pair := range pairs {
+		result[pair] = struct{}{}
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package db
+
+// A Secret is a sensitive value, such as a password, that specs refer to by name.
+// Its value never appears in a spec, in the logs, or in the database on disk.
+type Secret struct {
+	ID int
+
+	Name  string
+	Value string `rowStringer:"omit"`
+}
+
+// InsertSecret creates a new secret row and inserts it into the database.
+func (db Database) InsertSecret() Secret {
+	result := Secret{ID: db.nextID()}
+	db.insert(result)
+	return result
+}
+
+// SelectFromSecret gets all secrets in the database that satisfy 'check'.
+func (db Database) SelectFromSecret(check func(Secret) bool) []Secret {
+	var result []Secret
+	for _, row := range db.tables[SecretTable].rows {
+		if check == nil || check(row.(Secret)) {
+			result = append(result, row.(Secret))
+		}
+	}
+
+	return result
+}
+
+// SelectFromSecret gets all secrets in the database connection that satisfy
+// 'check'.
+func (conn Conn) SelectFromSecret(check func(Secret) bool) []Secret {
+	var result []Secret
+	conn.ReadTransact(func(view Database) error {
+		result = view.SelectFromSecret(check)
+		return nil
+	})
+	return result
+}
+
+func (s Secret) String() string {
+	return defaultString(s)
+}
+
+func (s Secret) less(r row) bool {
+	o := r.(Secret)
+
+	switch {
+	case s.Name != o.Name:
+		return s.Name < o.Name
+	default:
+		return s.ID < o.ID
+	}
+}
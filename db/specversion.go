@@ -0,0 +1,78 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// A SpecVersion records a spec deployed to a namespace, so that the namespace can
+// be rolled back to it.
+type SpecVersion struct {
+	ID int
+
+	Version   int // Increases with each spec deployed, across all namespaces.
+	Namespace string
+	Time      time.Time
+	Source    string // The file the spec was loaded from, if any.
+	Rollback  int    // If nonzero, this spec rolled back to that version.
+
+	Spec string `rowStringer:"omit"`
+}
+
+// InsertSpecVersion creates a new spec version row and inserts it into the
+// database.
+func (db Database) InsertSpecVersion() SpecVersion {
+	result := SpecVersion{ID: db.nextID()}
+	db.insert(result)
+	return result
+}
+
+// SelectFromSpecVersion gets all spec versions in the database that satisfy
+// 'check'.
+func (db Database) SelectFromSpecVersion(check func(SpecVersion) bool) []SpecVersion {
+	var result []SpecVersion
+	for _, row := range db.tables[SpecVersionTable].rows {
+		if check == nil || check(row.(SpecVersion)) {
+			result = append(result, row.(SpecVersion))
+		}
+	}
+
+	return result
+}
+
+// SelectFromSpecVersion gets all spec versions in the database connection that
+// satisfy 'check'.
+func (conn Conn) SelectFromSpecVersion(check func(SpecVersion) bool) []SpecVersion {
+	var result []SpecVersion
+	conn.ReadTransact(func(view Database) error {
+		result = view.SelectFromSpecVersion(check)
+		return nil
+	})
+	return result
+}
+
+// SortSpecVersions returns a slice of spec versions sorted according to the
+// default database sort order.
+func SortSpecVersions(versions []SpecVersion) []SpecVersion {
+	rows := make([]row, 0, len(versions))
+	for _, v := range versions {
+		rows = append(rows, v)
+	}
+
+	sort.Sort(rowSlice(rows))
+
+	versions = make([]SpecVersion, 0, len(versions))
+	for _, r := range rows {
+		versions = append(versions, r.(SpecVersion))
+	}
+
+	return versions
+}
+
+func (v SpecVersion) String() string {
+	return defaultString(v)
+}
+
+func (v SpecVersion) less(r row) bool {
+	return v.Version < r.(SpecVersion).Version
+}
@@ -25,11 +25,24 @@ var ConnectionTable = TableType(reflect.TypeOf(Connection{}).String())
 // LabelTable is the type of the label table.
 var LabelTable = TableType(reflect.TypeOf(Label{}).String())
 
+// AdministratorTable is the type of the administrator table.
+var AdministratorTable = TableType(reflect.TypeOf(Administrator{}).String())
+
+// HostTable is the type of the host table.
+var HostTable = TableType(reflect.TypeOf(Host{}).String())
+
+// SecretTable is the type of the secret table.
+var SecretTable = TableType(reflect.TypeOf(Secret{}).String())
+
+// SpecVersionTable is the type of the spec version table.
+var SpecVersionTable = TableType(reflect.TypeOf(SpecVersion{}).String())
+
 // EtcdTable is the type of the etcd table.
 var EtcdTable = TableType(reflect.TypeOf(Etcd{}).String())
 
 var allTables = []TableType{ClusterTable, MachineTable, ContainerTable, MinionTable,
-	ConnectionTable, LabelTable, EtcdTable}
+	ConnectionTable, LabelTable, HostTable, AdministratorTable, SecretTable,
+	SpecVersionTable, EtcdTable}
 
 type table struct {
 	rows map[int]row
@@ -37,12 +50,19 @@ type table struct {
 	triggers map[Trigger]struct{}
 	trigSeq  int
 	seq      int
+
+	subscriptions map[Subscription]struct{}
+	changes       []Event     // Changes in the current transaction.
+	pending       map[int]int // Row ID to its index in 'changes'.
 }
 
 func newTable() *table {
 	return &table{
 		rows:     make(map[int]row),
 		triggers: make(map[Trigger]struct{}),
+
+		subscriptions: make(map[Subscription]struct{}),
+		pending:       make(map[int]int),
 	}
 }
 
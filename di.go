@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	l_mod "log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"text/scanner"
 	"time"
@@ -14,8 +18,10 @@ import (
 	"github.com/NetSys/di/db"
 	"github.com/NetSys/di/dsl"
 	"github.com/NetSys/di/engine"
+	"github.com/NetSys/di/provider"
 	"github.com/NetSys/di/util"
 
+	"github.com/spf13/afero"
 	"google.golang.org/grpc/grpclog"
 
 	log "github.com/Sirupsen/logrus"
@@ -30,20 +36,110 @@ func main() {
 	log.SetFormatter(util.Formatter{})
 
 	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [plan | lint | fmt | history | "+
+			"rollback [version]] [options]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
-	var configPath = flag.String("c", "config.spec", "path to config file")
-	flag.Parse()
+	var configPaths pathList
+	flag.Var(&configPaths, "c", "path to config file, may be repeated to "+
+		"deploy several namespaces (default config.spec)")
+	var dbPath = flag.String("db", "",
+		"path at which to persist the database across restarts")
+	var secretsPath = flag.String("secrets", "",
+		"path to a directory holding one file per secret, or to a file of "+
+			"name=value lines")
 
-	conn := db.New()
+	// The command, if any, precedes the flags.
+	var command, version string
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	if command == "rollback" && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		version, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	if len(configPaths) == 0 {
+		configPaths = pathList{"config.spec"}
+	}
+
+	// These commands work with the controller's database, an empty one would
+	// only give misleading results.
+	switch command {
+	case "plan", "history", "rollback":
+		if err := requireDB(command, *dbPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	switch command {
+	case "":
+		runController(openDB(*dbPath), configPaths, *secretsPath, *dbPath)
+	case "plan":
+		// The plan is the output, not the database logs.
+		log.SetLevel(log.WarnLevel)
+		if err := plan(openDB(*dbPath), configPaths); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "lint":
+		if !lint(configPaths) {
+			os.Exit(1)
+		}
+	case "fmt":
+		if err := format(configPaths); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "history":
+		log.SetLevel(log.WarnLevel)
+		history(openDB(*dbPath))
+	case "rollback":
+		log.SetLevel(log.WarnLevel)
+		if err := rollback(openDB(*dbPath), *dbPath, version); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+// openDB opens the database persisted at 'path', or an in-memory database if
+// 'path' is empty.
+func openDB(path string) db.Conn {
+	if path == "" {
+		return db.New()
+	}
+
+	conn, err := db.Open(path)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to open the database.")
+	}
+	return conn
+}
+
+// requireDB returns an error if 'command' was run without a database.
+func requireDB(command, dbPath string) error {
+	if dbPath == "" {
+		return fmt.Errorf("%s requires the database given to the "+
+			"controller with -db", command)
+	}
+	return nil
+}
+
+func runController(conn db.Conn, configPaths []string, secretsPath,
+	dbPath string) {
 	go func() {
 		tick := time.Tick(5 * time.Second)
 		for {
-			if err := updateConfig(conn, *configPath); err != nil {
-				log.WithError(err).Warn(
-					"Failed to update configuration.")
-			}
+			updateSecrets(conn, secretsPath)
+			applyRollback(conn, dbPath)
+			updateConfigs(conn, configPaths)
 
 			select {
 			case <-tick:
@@ -54,12 +150,343 @@ func main() {
 	cluster.Run(conn)
 }
 
+// updateSecrets loads the secrets at 'path' into the database.  If they can't be
+// read, the previously loaded secrets are kept.
+func updateSecrets(conn db.Conn, path string) {
+	if path == "" {
+		return
+	}
+
+	secrets, err := loadSecrets(path)
+	if err != nil {
+		log.WithError(err).Warn("Failed to load secrets.")
+		return
+	}
+
+	conn.Transact(func(view db.Database) error {
+		engine.UpdateSecrets(view, secrets)
+		return nil
+	})
+}
+
+// loadSecrets reads the secrets at 'path'.  If 'path' is a directory, each file in
+// it holds the secret of the same name.  Otherwise, 'path' is a file with a
+// "name=value" secret on each line, ignoring blank lines and '#' comments.  The
+// whitespace around names and values is ignored, unless the value is surrounded
+// by double quotes, which are removed.
+func loadSecrets(path string) (map[string]string, error) {
+	info, err := util.AppFs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := map[string]string{}
+	if info.IsDir() {
+		files, err := afero.ReadDir(util.AppFs, path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+
+			data, err := afero.ReadFile(util.AppFs,
+				filepath.Join(path, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			secrets[file.Name()] = strings.TrimSuffix(string(data), "\n")
+		}
+		return secrets, nil
+	}
+
+	data, err := afero.ReadFile(util.AppFs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		nameValue := strings.SplitN(line, "=", 2)
+		if len(nameValue) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a name=value secret",
+				path, i+1)
+		}
+		value := strings.TrimSpace(nameValue[1])
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) &&
+			strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		secrets[strings.TrimSpace(nameValue[0])] = value
+	}
+	return secrets, nil
+}
+
+// plan prints the changes that deploying the specs at 'configPaths' would make to
+// the deployment in 'conn', without making them.
+func plan(conn db.Conn, configPaths []string) error {
+	for _, path := range configPaths {
+		spec, err := loadSpec(path)
+		if err != nil {
+			return err
+		}
+
+		p, err := engine.MakePlan(conn, spec)
+		if err != nil {
+			return err
+		}
+
+		if len(configPaths) > 1 {
+			fmt.Printf("%s:\n", path)
+		}
+		fmt.Print(p)
+	}
+	return nil
+}
+
+// lint prints the problems in the specs at 'configPaths', and returns whether
+// there weren't any.
+func lint(configPaths []string) bool {
+	ok := true
+	for _, path := range configPaths {
+		f, err := util.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ok = false
+			continue
+		}
+
+		sc := scanner.Scanner{Position: scanner.Position{Filename: path}}
+		sc.Init(bufio.NewReader(f))
+		for _, err := range dsl.Lint(sc, diPath(), provider.LintInfo()) {
+			fmt.Println(err)
+			ok = false
+		}
+		f.Close()
+	}
+	return ok
+}
+
+// format rewrites the specs at 'configPaths' in the standard style, and prints
+// the paths of those that changed.
+func format(configPaths []string) error {
+	for _, path := range configPaths {
+		f, err := util.Open(path)
+		if err != nil {
+			return err
+		}
+
+		code, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		sc := scanner.Scanner{Position: scanner.Position{Filename: path}}
+		formatted, err := dsl.Format(*sc.Init(bytes.NewReader(code)))
+		if err != nil {
+			return err
+		}
+
+		if formatted == string(code) {
+			continue
+		}
+
+		if err := util.WriteFile(path, []byte(formatted), 0644); err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
+
+// history prints the specs deployed to each namespace, oldest first.
+func history(conn db.Conn) {
+	for _, v := range db.SortSpecVersions(conn.SelectFromSpecVersion(nil)) {
+		source := v.Source
+		if v.Rollback != 0 {
+			source = fmt.Sprintf("rollback to %d", v.Rollback)
+		}
+		fmt.Printf("%-4d %s  %-12s %s\n", v.Version,
+			v.Time.Format("2006-01-02 15:04:05"), v.Namespace, source)
+	}
+}
+
+// rollback asks the controller using the database at 'dbPath' to redeploy the
+// spec recorded as 'version'.  The database belongs to the controller, so rather
+// than changing it, the request is left in a file next to it, which the
+// controller applies within a few seconds, or when it next starts.
+func rollback(conn db.Conn, dbPath, version string) error {
+	v, err := rollbackVersion(conn, version)
+	if err != nil {
+		return err
+	}
+
+	if err := requestRollback(dbPath, v); err != nil {
+		return err
+	}
+	fmt.Printf("Requested a rollback to version %d.\n", v)
+	return nil
+}
+
+// rollbackVersion returns the spec version named by 'version'.  If 'version' is
+// empty, it's the spec the only namespace ran before its current one.
+func rollbackVersion(conn db.Conn, version string) (int, error) {
+	versions := db.SortSpecVersions(conn.SelectFromSpecVersion(nil))
+	if version != "" {
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version: %s", version)
+		}
+
+		for _, sv := range versions {
+			if sv.Version == v {
+				return v, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown spec version: %d", v)
+	}
+
+	if len(versions) == 0 {
+		return 0, fmt.Errorf("no specs have been deployed")
+	}
+
+	namespace := versions[0].Namespace
+	for _, v := range versions {
+		if v.Namespace != namespace {
+			return 0, fmt.Errorf("several namespaces have been deployed, " +
+				"specify a version")
+		}
+	}
+
+	if len(versions) < 2 {
+		return 0, fmt.Errorf("no earlier spec to roll back to")
+	}
+	return versions[len(versions)-2].Version, nil
+}
+
+// The file next to the database at 'dbPath' that holds a requested rollback.
+func rollbackPath(dbPath string) string {
+	return dbPath + ".rollback"
+}
+
+func requestRollback(dbPath string, version int) error {
+	// Renamed into place so the controller never reads a partial request.
+	path := rollbackPath(dbPath)
+	tmp := path + ".tmp"
+	if err := util.WriteFile(tmp, []byte(strconv.Itoa(version)), 0600); err != nil {
+		return err
+	}
+	return util.AppFs.Rename(tmp, path)
+}
+
+// applyRollback carries out the rollback requested for the database at 'dbPath',
+// if any.  The controller keeps the rollback until the namespace's spec file
+// changes.
+func applyRollback(conn db.Conn, dbPath string) {
+	if dbPath == "" {
+		return
+	}
+
+	path := rollbackPath(dbPath)
+	data, err := afero.ReadFile(util.AppFs, path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.WithError(err).Warn("Failed to read the requested rollback.")
+		return
+	}
+
+	// The request is only attempted once, so that a bad one isn't retried
+	// forever.
+	if err := util.AppFs.Remove(path); err != nil {
+		log.WithError(err).Warn("Failed to remove the requested rollback.")
+		return
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil {
+		err = engine.Rollback(conn, version)
+	}
+
+	if err != nil {
+		log.WithError(err).Error("Failed to roll back.")
+		return
+	}
+	log.Infof("Rolled back to spec version %d.", version)
+}
+
+// A pathList is a flag that may be given multiple times.
+type pathList []string
+
+func (pl *pathList) String() string {
+	return strings.Join(*pl, ",")
+}
+
+func (pl *pathList) Set(path string) error {
+	*pl = append(*pl, path)
+	return nil
+}
+
 const diPathKey = "DI_PATH"
 
-func updateConfig(conn db.Conn, configPath string) error {
+// updateConfigs deploys each spec in 'configPaths' to the cluster of its namespace,
+// and tears down the clusters that no longer have a spec.
+func updateConfigs(conn db.Conn, configPaths []string) {
+	var namespaces []string
+	seen := map[string]string{}
+	failed := false
+	for _, path := range configPaths {
+		namespace, err := updateConfig(conn, path, seen)
+		if err != nil {
+			log.WithError(err).WithField("spec", path).Warn(
+				"Failed to update configuration.")
+			failed = true
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+
+	// If a spec failed, we can't know its namespace, and thus can't tell
+	// which clusters are stale.
+	if !failed {
+		engine.PruneClusters(conn, namespaces)
+	}
+}
+
+// updateConfig deploys the spec at 'configPath' and returns its namespace.
+// 'seen' maps the namespaces deployed so far to their spec.
+func updateConfig(conn db.Conn, configPath string,
+	seen map[string]string) (string, error) {
+	spec, err := loadSpec(configPath)
+	if err != nil {
+		return "", err
+	}
+
+	namespace := spec.QueryString("Namespace")
+	if other, ok := seen[namespace]; ok {
+		return "", fmt.Errorf("namespace %s is also used by %s",
+			namespace, other)
+	}
+	seen[namespace] = configPath
+
+	if engine.RolledBack(conn, spec) {
+		return namespace, nil
+	}
+	return namespace, engine.UpdatePolicyFrom(conn, spec, configPath)
+}
+
+func loadSpec(configPath string) (dsl.Dsl, error) {
 	f, err := util.Open(configPath)
 	if err != nil {
-		return err
+		return dsl.Dsl{}, err
 	}
 	defer f.Close()
 
@@ -68,12 +495,11 @@ func updateConfig(conn db.Conn, configPath string) error {
 			Filename: configPath,
 		},
 	}
-	pathStr, _ := os.LookupEnv(diPathKey)
-	pathSlice := strings.Split(pathStr, ":")
-	spec, err := dsl.New(*sc.Init(bufio.NewReader(f)), pathSlice)
-	if err != nil {
-		return err
-	}
+	return dsl.New(*sc.Init(bufio.NewReader(f)), diPath())
+}
 
-	return engine.UpdatePolicy(conn, spec)
+// diPath returns the directories in which to look for imported modules.
+func diPath() []string {
+	pathStr, _ := os.LookupEnv(diPathKey)
+	return strings.Split(pathStr, ":")
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/dsl"
+	"github.com/NetSys/di/engine"
+	"github.com/NetSys/di/util"
+	"github.com/spf13/afero"
+)
+
+func TestLoadSecrets(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	defer func() {
+		util.AppFs = afero.NewOsFs()
+	}()
+
+	util.WriteFile("secrets/db_password", []byte("hunter2\n"), 0600)
+	util.WriteFile("secrets/api_key", []byte("abc=123"), 0600)
+	util.WriteFile("secrets/.hidden", []byte("ignored"), 0600)
+
+	exp := map[string]string{"db_password": "hunter2", "api_key": "abc=123"}
+	secrets, err := loadSecrets("secrets")
+	if err != nil || !reflect.DeepEqual(secrets, exp) {
+		t.Errorf("bad secrets from directory: %v, %v", secrets, err)
+	}
+
+	util.WriteFile("secrets.conf", []byte("# A comment\n"+
+		"db_password=hunter2\n\napi_key = abc=123\npadded = \" x \"\n"), 0600)
+	exp = map[string]string{"db_password": "hunter2", "api_key": "abc=123",
+		"padded": " x "}
+	secrets, err = loadSecrets("secrets.conf")
+	if err != nil || !reflect.DeepEqual(secrets, exp) {
+		t.Errorf("bad secrets from file: %v, %v", secrets, err)
+	}
+
+	util.WriteFile("bad.conf", []byte("db_password\n"), 0600)
+	if _, err := loadSecrets("bad.conf"); err == nil {
+		t.Error("expected an error for a malformed secrets file")
+	}
+
+	if _, err := loadSecrets("missing"); err == nil {
+		t.Error("expected an error for missing secrets")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	util.AppFs = afero.NewMemMapFs()
+	defer func() {
+		util.AppFs = afero.NewOsFs()
+	}()
+
+	conn := db.New()
+	for _, image := range []string{"v1", "v2"} {
+		var sc scanner.Scanner
+		code := fmt.Sprintf(`(define Namespace "ns")
+		(label "web" (docker "%s"))`, image)
+		spec, err := dsl.New(*sc.Init(strings.NewReader(code)), []string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := engine.UpdatePolicyFrom(conn, spec, "config.spec"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := rollbackVersion(conn, "3"); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+
+	// Nothing happens until a rollback is requested.
+	applyRollback(conn, "di.db")
+	if n := len(conn.SelectFromSpecVersion(nil)); n != 2 {
+		t.Fatalf("rolled back without a request: %d versions", n)
+	}
+
+	if err := rollback(conn, "di.db", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	applyRollback(conn, "di.db")
+	versions := db.SortSpecVersions(conn.SelectFromSpecVersion(nil))
+	if len(versions) != 3 || versions[2].Rollback != versions[0].Version {
+		t.Errorf("bad rollback: %v", versions)
+	}
+
+	// Requests are applied once.
+	if _, err := util.AppFs.Stat(rollbackPath("di.db")); err == nil {
+		t.Error("rollback request was not removed")
+	}
+	applyRollback(conn, "di.db")
+	if n := len(conn.SelectFromSpecVersion(nil)); n != 3 {
+		t.Errorf("rollback applied twice: %d versions", n)
+	}
+}
+
+func TestRequireDB(t *testing.T) {
+	err := requireDB("plan", "")
+	if err == nil || err.Error() != "plan requires the database given to the "+
+		"controller with -db" {
+		t.Errorf("bad error without a database: %v", err)
+	}
+
+	if err := requireDB("plan", "di.db"); err != nil {
+		t.Errorf("unexpected error with a database: %s", err)
+	}
+}
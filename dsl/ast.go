@@ -46,6 +46,14 @@ type astRange struct {
 	max astFloat
 }
 
+/* The protocol and ports of a connection. */
+type astProtocol struct {
+	protocol astIdent // "tcp", "udp", or "icmp".
+
+	min int // Unused for icmp.
+	max int
+}
+
 type astList []ast       /* A data list after evaluation. */
 type astHmap map[ast]ast /* A map after evaluation. */
 
@@ -67,6 +75,8 @@ type astRoot astList
 type astModule struct {
 	moduleName astString
 	body       []ast
+
+	pos scanner.Position // Where the module was imported or defined, if known.
 }
 
 type astIdent string /* Identities, i.e. key words, variable names etc. */
@@ -95,17 +105,49 @@ type astMachine struct {
 	cpu      astRange
 	ram      astRange
 	diskSize astDiskSize
-	sshKeys  []key
 
 	atomImpl
 }
 
+/* Administrators */
+type astUser struct {
+	keyType astString   // "github" or "plaintext".
+	key     astString   // A github username or an SSH public key.
+	acls    []astString // Source CIDRs, or "local" for the controller's IP.
+
+	atomImpl
+}
+
+/* External hosts */
+type astHost struct {
+	hostname astString // A hostname, IP address, or CIDR block.
+
+	atomImpl
+}
+
+/* A reference to a secret, whose value is known only at run time. */
+type astSecret struct {
+	name astString
+}
+
+/* Volumes */
+type astVolume struct {
+	source astString // A host directory or the name of a docker volume.
+	path   astString // Where the volume is mounted in the container.
+}
+
 type astContainer struct {
 	image   astString
 	command astList
 	env     astHmap
+	volumes []astVolume
+	files   map[string]File // Keyed by path.
+
+	healthCheck   HealthCheck
+	rollingUpdate RollingUpdate
 
 	Placement
+	Resources
 
 	atomImpl
 }
@@ -183,7 +225,12 @@ func (str astString) String() string {
 }
 
 func (x astFloat) String() string {
-	return fmt.Sprintf("%g", x)
+	// Whole floats keep a decimal point, so that they're parsed back as floats.
+	str := fmt.Sprintf("%g", x)
+	if !strings.ContainsAny(str, ".eInN") {
+		str += ".0"
+	}
+	return str
 }
 
 func (x astInt) String() string {
@@ -194,13 +241,48 @@ func (b astBool) String() string {
 	return fmt.Sprintf("%t", b)
 }
 
+func (u *astUser) String() string {
+	args := []ast{u.keyType, u.key}
+	for _, acl := range u.acls {
+		args = append(args, acl)
+	}
+	return fmt.Sprintf("(user %s)", sliceStr(args, " "))
+}
+
+func (h *astHost) String() string {
+	return fmt.Sprintf("(host %s)", h.hostname)
+}
+
+func (s astSecret) String() string {
+	return fmt.Sprintf("(secret %s)", s.name)
+}
+
+func (v astVolume) String() string {
+	return fmt.Sprintf("(volume %s %s)", v.source, v.path)
+}
+
+// bind returns the volume in the "source:path" form docker expects.
+func (v astVolume) bind() string {
+	return fmt.Sprintf("%s:%s", string(v.source), string(v.path))
+}
+
+func (p astProtocol) String() string {
+	switch {
+	case p.protocol == "icmp":
+		return "(icmp)"
+	case p.min == p.max:
+		return fmt.Sprintf("(%s %d)", p.protocol, p.min)
+	default:
+		return fmt.Sprintf("(%s (list %d %d))", p.protocol, p.min, p.max)
+	}
+}
+
 func (r astRange) String() string {
-	args := []ast{r.min}
+	// The bounds are always floats, so they needn't look like them.
 	if r.max != 0 {
-		args = append(args, r.max)
+		return fmt.Sprintf("(%s %g %g)", r.ident, r.min, r.max)
 	}
-
-	return fmt.Sprintf("(%s)", sliceStr(append([]ast{r.ident}, args...), " "))
+	return fmt.Sprintf("(%s %g)", r.ident, r.min)
 }
 
 func (l astLambda) String() string {
@@ -242,9 +324,6 @@ func (m *astMachine) String() string {
 	if m.role != "" {
 		args = append(args, m.role)
 	}
-	for _, key := range m.sshKeys {
-		args = append(args, key)
-	}
 	if len(args) == 0 {
 		return "(machine)"
 	}
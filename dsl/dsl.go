@@ -1,7 +1,9 @@
 package dsl
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"text/scanner"
 
 	log "github.com/Sirupsen/logrus"
@@ -19,22 +21,94 @@ type Container struct {
 	Command []string
 	Env     map[string]string
 
+	// Maps environment variables to the names of the secrets that provide
+	// their values.
+	SecretEnv map[string]string
+
 	Placement
+	Resources
 	atomImpl
+
+	// Volumes in the "source:path" form of docker binds.  The source is either
+	// a host directory or the name of a docker volume.
+	Volumes []string
+
+	HealthCheck HealthCheck
+
+	Files []File // Sorted by path.
+
+	RollingUpdate RollingUpdate
+}
+
+// A RollingUpdate limits how quickly containers are replaced when their image,
+// command, or other immutable settings change.  The zero value replaces them all
+// at once.
+type RollingUpdate struct {
+	// The number of containers that may be missing or not ready.
+	MaxUnavailable int
+
+	// The number of containers that may exist beyond those specified.
+	MaxSurge int
+}
+
+// A File is kept at Path inside a container, with the given Content and Mode.
+type File struct {
+	Path    string
+	Content string
+	Mode    int
+}
+
+// A HealthCheck describes how to probe whether a container is working.
+type HealthCheck struct {
+	Type    string   // "tcp", "http" or "exec".  Empty if there's no check.
+	Port    int      // For "tcp" and "http" checks.
+	Path    string   // For "http" checks.
+	Command []string // For "exec" checks.
 }
 
 // A Placement constraint restricts where containers may be instantiated.
 type Placement struct {
 	Exclusive map[[2]string]struct{}
+
+	// Pairs of labels whose containers must share a host.  The containers of the
+	// second label are placed next to those of the first.
+	Colocate map[[2]string]struct{}
+
+	Machine MachinePlacement
+}
+
+// A MachinePlacement pins containers to machines with the given attributes.  Empty
+// fields are unconstrained.
+type MachinePlacement struct {
+	Role     string
+	Region   string
+	Provider string
+	Labels   []string // Sorted.
+}
+
+// A Host is an external service, outside of the cluster, that containers may
+// connect to.
+type Host struct {
+	Hostname string // A hostname, IP address, or CIDR block.
+
+	atomImpl
+}
+
+// Resources limit what a container may consume.  Zero means unlimited.
+type Resources struct {
+	Memory    int // In megabytes.
+	CPUShares int // Relative to the default of 1024.
 }
 
 // A Connection allows containers implementing the From label to speak to containers
 // implementing the To label in ports in the range [MinPort, MaxPort]
 type Connection struct {
-	From    string
-	To      string
-	MinPort int
-	MaxPort int
+	From     string
+	To       string
+	Protocol string // "tcp", "udp", "icmp", or empty for all three.
+	MinPort  int
+	MaxPort  int
+	Deny     bool // Forbids the traffic instead, overriding other connections.
 }
 
 // A Machine specifies the type of VM that should be booted.
@@ -46,7 +120,16 @@ type Machine struct {
 	RAM      Range
 	DiskSize int
 	Region   string
-	SSHKeys  []string
+
+	atomImpl
+}
+
+// A User is an administrator of the deployment.  Machines accept the user's SSH
+// keys, and the cloud firewalls admit traffic from the user's ACLs.
+type User struct {
+	Name    string // The github username, or empty for plaintext keys.
+	SSHKeys []string
+	ACLs    []string // Source CIDRs, or "local" for the IP of the controller.
 
 	atomImpl
 }
@@ -67,7 +150,7 @@ func (dslr Range) Accepts(x float64) bool {
 
 // New parses and executes a dsl (in text form), and returns an abstract Dsl handle.
 func New(sc scanner.Scanner, path []string) (Dsl, error) {
-	parsed, err := parse(sc)
+	parsed, err := parseSource(sc)
 	if err != nil {
 		return Dsl{}, err
 	}
@@ -94,20 +177,72 @@ func (dsl Dsl) QueryContainers() []*Container {
 			command = append(command, string(co.(astString)))
 		}
 		env := make(map[string]string)
+		var secretEnv map[string]string
 		for key, val := range c.env {
-			env[string(key.(astString))] = string(val.(astString))
+			switch val := val.(type) {
+			case astString:
+				env[string(key.(astString))] = string(val)
+			case astSecret:
+				if secretEnv == nil {
+					secretEnv = make(map[string]string)
+				}
+				secretEnv[string(key.(astString))] = string(val.name)
+			}
+		}
+		var volumes []string
+		for _, v := range c.volumes {
+			volumes = append(volumes, v.bind())
 		}
+		var files []File
+		for _, f := range c.files {
+			files = append(files, f)
+		}
+		sort.Sort(fileSlice(files))
 		containers = append(containers, &Container{
 			Image:     string(c.image),
 			Command:   command,
 			Placement: c.Placement,
+			Resources: c.Resources,
 			atomImpl:  c.atomImpl,
 			Env:       env,
+			SecretEnv: secretEnv,
+			Volumes:   volumes,
+
+			HealthCheck: c.healthCheck,
+			Files:       files,
+
+			RollingUpdate: c.rollingUpdate,
 		})
 	}
 	return containers
 }
 
+type fileSlice []File
+
+func (files fileSlice) Len() int {
+	return len(files)
+}
+
+func (files fileSlice) Swap(i, j int) {
+	files[i], files[j] = files[j], files[i]
+}
+
+func (files fileSlice) Less(i, j int) bool {
+	return files[i].Path < files[j].Path
+}
+
+// QueryHosts retrieves all external hosts declared in the dsl.
+func (dsl Dsl) QueryHosts() []Host {
+	var hosts []Host
+	for _, h := range *dsl.ctx.hosts {
+		hosts = append(hosts, Host{
+			Hostname: string(h.hostname),
+			atomImpl: h.atomImpl,
+		})
+	}
+	return hosts
+}
+
 func parseKeys(rawKeys []key) []string {
 	var keys []string
 	for _, val := range rawKeys {
@@ -141,7 +276,6 @@ func convertAstMachine(machineAst astMachine) Machine {
 		RAM:      Range{Min: float64(machineAst.ram.min), Max: float64(machineAst.ram.max)},
 		CPU:      Range{Min: float64(machineAst.cpu.min), Max: float64(machineAst.cpu.max)},
 		DiskSize: int(machineAst.diskSize),
-		SSHKeys:  parseKeys(machineAst.sshKeys),
 		atomImpl: machineAst.atomImpl,
 	}
 }
@@ -177,6 +311,30 @@ func (dsl Dsl) QueryMachines() []Machine {
 	return machines
 }
 
+// QueryUsers returns the administrators declared in the dsl.
+func (dsl Dsl) QueryUsers() []User {
+	var users []User
+	for _, u := range *dsl.ctx.users {
+		var name string
+		if u.keyType == "github" {
+			name = string(u.key)
+		}
+
+		var acls []string
+		for _, acl := range u.acls {
+			acls = append(acls, string(acl))
+		}
+
+		users = append(users, User{
+			Name:     name,
+			SSHKeys:  parseKeys([]key{u.sshKey()}),
+			ACLs:     acls,
+			atomImpl: u.atomImpl,
+		})
+	}
+	return users
+}
+
 // QueryConnections returns the connections declared in the dsl.
 func (dsl Dsl) QueryConnections() []Connection {
 	var connections []Connection
@@ -186,6 +344,23 @@ func (dsl Dsl) QueryConnections() []Connection {
 	return connections
 }
 
+// AdminACL used to list the CIDRs allowed to reach the cluster.  It was replaced by
+// the ACLs of users, so rather than silently ignore it, specs that define it are
+// rejected.
+const adminACL = astIdent("AdminACL")
+
+var errAdminACL = errors.New("AdminACL is no longer supported, give the ACLs " +
+	"to (user ...) instead")
+
+// CheckDeprecated returns an error if the dsl defines settings that are no longer
+// supported.
+func (dsl Dsl) CheckDeprecated() error {
+	if _, ok := dsl.ctx.binds[adminACL]; ok {
+		return errAdminACL
+	}
+	return nil
+}
+
 // QueryFloat returns a float value defined in the dsl.
 func (dsl Dsl) QueryFloat(key string) (float64, error) {
 	result, ok := dsl.ctx.binds[astIdent(key)]
@@ -279,18 +454,61 @@ func (dsl Dsl) String() string {
 // By using the innermost defined position, and the innermost error message,
 // our error message is "Line 6: `a` undefined", instead of
 // "Undefined line: `a` undefined.
+//
+// Errors that occur within a lambda or module are followed by the call sites
+// that led to them, innermost first, so that an error deep within an imported
+// module can be traced back to the spec that triggered it:
+//
+//	strings.spec:12: bad arithmetic argument: "a"
+//		in strings.Join, called from main.spec:5
 type dslError struct {
 	pos scanner.Position
 	err error
+
+	// The lambda or module that 'err' occurred within, if it was called at 'pos'.
+	call string
 }
 
 func (dslErr dslError) Error() string {
-	pos := dslErr.innermostPos()
-	err := dslErr.innermostError()
+	msg := fmt.Sprintf("%s: %s", formatPos(dslErr.innermostPos()),
+		dslErr.innermostError())
+
+	stack := dslErr.callStack()
+	for i := len(stack) - 1; i >= 0; i-- {
+		msg += fmt.Sprintf("\n\tin %s, called from %s", stack[i].call,
+			formatPos(stack[i].pos))
+	}
+	return msg
+}
+
+func formatPos(pos scanner.Position) string {
 	if pos.Filename == "" {
-		return fmt.Sprintf("%d: %s", pos.Line, err)
+		return fmt.Sprintf("%d", pos.Line)
+	}
+	return fmt.Sprintf("%s:%d", pos.Filename, pos.Line)
+}
+
+// callStack returns the calls that 'err' occurred within, outermost first.  Calls
+// made by generated S-expressions are attributed to the innermost position that
+// encloses them.
+func (err dslError) callStack() []dslError {
+	var stack []dslError
+	var pos scanner.Position
+	for {
+		if err.pos.Line != 0 {
+			pos = err.pos
+		}
+
+		if err.call != "" {
+			stack = append(stack, dslError{pos: pos, call: err.call})
+		}
+
+		childErr, ok := err.err.(dslError)
+		if !ok {
+			return stack
+		}
+		err = childErr
 	}
-	return fmt.Sprintf("%s:%d: %s", pos.Filename, pos.Line, err)
 }
 
 // innermostPos returns the most nested position that is non-zero.
@@ -48,6 +48,41 @@ func TestArith(t *testing.T) {
 	parseTest(t, "(% 10 100 3)", "1")
 
 	parseTest(t, "(+ (* 3 (- 10 2)) (/ 100 (* 25 2)))", "26")
+
+	// Ints are promoted to floats.
+	parseTest(t, "(* 0.5 3)", "1.5")
+	parseTest(t, "(+ 1 2.5 3)", "6.5")
+	parseTest(t, "(- 1 0.25)", "0.75")
+	parseTest(t, "(/ 7 2)", "3")
+	parseTest(t, "(/ 7 2.0)", "3.5")
+	parseTest(t, "(% 7.5 2)", "1.5")
+	parseTest(t, "(* 2.5 2)", "5.0")
+
+	parseTest(t, "(int 3.9)", "3")
+	parseTest(t, "(int (- 0 3.9))", "-3")
+	parseTest(t, `(int "42")`, "42")
+	parseTest(t, "(int 7)", "7")
+	parseTest(t, "(float 3)", "3.0")
+	parseTest(t, `(float "2.5")`, "2.5")
+	parseTest(t, "(+ (float 1) 0.5)", "1.5")
+	runtimeErr(t, `(int "a")`, `1: cannot convert to an int: "a"`)
+	runtimeErr(t, `(float (list))`, `1: cannot convert to a float: (list)`)
+	runtimeErr(t, "(int 1e300)", "1: overflow: (int 1e+300)")
+
+	runtimeErr(t, "(/ 1 0)", "1: division by zero: (/ 1 0)")
+	runtimeErr(t, "(% 1 0)", "1: division by zero: (% 1 0)")
+	runtimeErr(t, "(/ 1.5 0)", "1: division by zero: (/ 1.5 0)")
+	runtimeErr(t, "(/ 10 5 0)", "1: division by zero: (/ 10 5 0)")
+
+	max := "9223372036854775807"
+	parseTest(t, "(- 0 "+max+" 1)", "-9223372036854775808")
+	runtimeErr(t, "(+ "+max+" 1)", "1: overflow: (+ "+max+" 1)")
+	runtimeErr(t, "(- (- 0 "+max+") 2)", "1: overflow: (- -"+max+" 2)")
+	runtimeErr(t, "(* "+max+" 2)", "1: overflow: (* "+max+" 2)")
+	runtimeErr(t, "(/ (- 0 "+max+" 1) (- 0 1))",
+		"1: overflow: (/ -9223372036854775808 -1)")
+	runtimeErr(t, "(* 1e300 1e300)", "1: overflow: (* 1e+300 1e+300)")
+	runtimeErr(t, `(* 2 "a")`, `1: bad arithmetic argument: "a"`)
 }
 
 func TestStrings(t *testing.T) {
@@ -147,7 +182,7 @@ func TestLambda(t *testing.T) {
 
 	// Test that recursion DOESN'T work
 	fib := "(define fib (lambda (n) (if (= n 0) 1 (* n (fib (- n 1)))))) (fib 5)"
-	runtimeErr(t, fib, "1: unknown function: fib")
+	runtimeErr(t, fib, "1: unknown function: fib\n\tin fib, called from 1")
 
 	// Test body-less lambda
 	runtimeErr(t, "(lambda (x))", "1: not enough arguments: lambda")
@@ -244,6 +279,23 @@ func TestIf(t *testing.T) {
 	compTest = "(> 2 1)"
 	parseTest(t, compTest, "true")
 
+	// Test <=, >= and !=
+	parseTest(t, "(<= 1 1)", "true")
+	parseTest(t, "(<= 2 1)", "false")
+	parseTest(t, "(>= 1 1)", "true")
+	parseTest(t, "(>= 1 2)", "false")
+	parseTest(t, "(!= 1 2)", "true")
+	parseTest(t, `(!= "a" "a")`, "false")
+
+	// Test comparisons between ints and floats
+	parseTest(t, "(< 1 1.5)", "true")
+	parseTest(t, "(>= 2.0 2)", "true")
+	parseTest(t, "(= 1 1.0)", "true")
+	parseTest(t, "(!= 1 1.5)", "true")
+	parseTest(t, `(= 1 "1")`, "false")
+	runtimeErr(t, `(< 1 "2")`, `1: bad arithmetic argument: "2"`)
+	runtimeErr(t, "(< 1 2 3)", "1: comparisons take exactly 2 arguments: (list 1 2 3)")
+
 	// Test !
 	notTest := "(! false)"
 	parseTest(t, notTest, "true")
@@ -400,53 +452,53 @@ func TestDocker(t *testing.T) {
 
 	code := `(docker "a")`
 	checkContainers(code, code, &Container{Image: "a",
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)})
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)})
 
 	code = "(docker \"a\")\n(docker \"a\")"
 	checkContainers(code, code, &Container{Image: "a",
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)},
-		&Container{Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)},
+		&Container{Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 			Env: make(map[string]string)})
 
 	code = `(makeList 2 (list (docker "a") (docker "b")))`
 	exp := `(list (list (docker "a") (docker "b"))` +
 		` (list (docker "a") (docker "b")))`
 	checkContainers(code, exp,
-		&Container{Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		&Container{Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 			Env: make(map[string]string)},
-		&Container{Image: "b", Placement: Placement{make(map[[2]string]struct{})},
+		&Container{Image: "b", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 			Env: make(map[string]string)},
-		&Container{Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		&Container{Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 			Env: make(map[string]string)},
-		&Container{Image: "b", Placement: Placement{make(map[[2]string]struct{})},
+		&Container{Image: "b", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 			Env: make(map[string]string)})
 	code = `(list (docker "a" "c") (docker "b" (list "d" "e" "f")))`
 	exp = `(list (docker "a" "c") (docker "b" "d" "e" "f"))`
 	checkContainers(code, exp,
 		&Container{Image: "a", Command: []string{"c"},
-			Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)},
+			Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)},
 		&Container{Image: "b", Command: []string{"d", "e", "f"},
-			Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)})
+			Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)})
 
 	code = `(let ((a "foo") (b "bar")) (list (docker a) (docker b)))`
 	exp = `(list (docker "foo") (docker "bar"))`
 	checkContainers(code, exp, &Container{Image: "foo",
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)},
-		&Container{Image: "bar", Placement: Placement{make(map[[2]string]struct{})},
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)},
+		&Container{Image: "bar", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 			Env: make(map[string]string)})
 
 	// Test creating containers from within a lambda function
 	code = `((lambda () (docker "foo")))`
 	exp = `(docker "foo")`
 	checkContainers(code, exp, &Container{Image: "foo",
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)})
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)})
 
 	code = `(define (make) (docker "a") (docker "b") (list)) (make)`
 	exp = `(list) (list)`
 	checkContainers(code, exp,
-		&Container{Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		&Container{Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 			Env: make(map[string]string)},
-		&Container{Image: "b", Placement: Placement{make(map[[2]string]struct{})},
+		&Container{Image: "b", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 			Env: make(map[string]string)})
 
 	// Test creating containers from within a module
@@ -458,7 +510,7 @@ func TestDocker(t *testing.T) {
 			 (list))
 		   (docker "baz")`
 	checkContainers(code, exp, &Container{Image: "baz",
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)})
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)})
 
 	runtimeErr(t, `(docker bar)`, `1: unassigned variable: bar`)
 	runtimeErr(t, `(docker 1)`, `1: expected string, found: 1`)
@@ -483,31 +535,18 @@ func TestStdlib(t *testing.T) {
 	           1
 	           2
 			   (list (provider "AmazonSpot")
-					 (size "m4.large")
-					 (plaintextKey "key")))`
+					 (size "m4.large")))`
 	expCode := `(module "machines" (list)
                 (list)
                 (list)
                 (list))
-                (list (machine (provider "AmazonSpot") (size "m4.large") (role "Master") (plaintextKey "key"))
-                      (machine (provider "AmazonSpot") (size "m4.large") (role "Worker") (plaintextKey "key"))
-                      (machine (provider "AmazonSpot") (size "m4.large") (role "Worker") (plaintextKey "key")))`
+                (list (machine (provider "AmazonSpot") (size "m4.large") (role "Master"))
+                      (machine (provider "AmazonSpot") (size "m4.large") (role "Worker"))
+                      (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))`
 	expMachines := []Machine{
-		{Provider: "AmazonSpot",
-			Size:    "m4.large",
-			Role:    "Master",
-			SSHKeys: []string{"key"},
-		},
-		{Provider: "AmazonSpot",
-			Size:    "m4.large",
-			Role:    "Worker",
-			SSHKeys: []string{"key"},
-		},
-		{Provider: "AmazonSpot",
-			Size:    "m4.large",
-			Role:    "Worker",
-			SSHKeys: []string{"key"},
-		},
+		{Provider: "AmazonSpot", Size: "m4.large", Role: "Master"},
+		{Provider: "AmazonSpot", Size: "m4.large", Role: "Worker"},
+		{Provider: "AmazonSpot", Size: "m4.large", Role: "Worker"},
 	}
 	checkMachines(code, expCode, expMachines...)
 }
@@ -678,32 +717,37 @@ func TestMachineAttribute(t *testing.T) {
 	checkMachines(code, expCode, expMachine)
 }
 
-func TestKeys(t *testing.T) {
+func TestUsers(t *testing.T) {
 	getGithubKeys = func(username string) ([]string, error) {
-		return []string{username}, nil
+		return []string{username + "Key"}, nil
 	}
 
-	checkKeys := func(code, expectedCode string, expected ...string) {
-		ctx := parseTest(t, code, expectedCode)
-		machineResult := Dsl{"", ctx}.QueryMachineSlice("sshkeys")
-		if len(machineResult) == 0 {
-			t.Error("no machine found")
-			return
-		}
-		if !reflect.DeepEqual(machineResult[0].SSHKeys, expected) {
-			t.Error(spew.Sprintf("test: %s, result: %s, expected: %s",
-				code, machineResult[0].SSHKeys, expected))
-		}
+	code := `(label "admins"
+	  (user "github" "ejj" "local")
+	  (user "plaintext" "key" (list "1.2.3.4/32" "10.0.0.0/8")))`
+	ctx := parseTest(t, code, `(label "admins" `+
+		`(user "github" "ejj" "local") `+
+		`(user "plaintext" "key" "1.2.3.4/32" "10.0.0.0/8"))`)
+
+	ejj := User{Name: "ejj", SSHKeys: []string{"ejjKey"}, ACLs: []string{"local"}}
+	ejj.SetLabels([]string{"admins"})
+	plain := User{SSHKeys: []string{"key"},
+		ACLs: []string{"1.2.3.4/32", "10.0.0.0/8"}}
+	plain.SetLabels([]string{"admins"})
+
+	expected := []User{ejj, plain}
+	if users := (Dsl{"", ctx}).QueryUsers(); !reflect.DeepEqual(users, expected) {
+		t.Error(spew.Sprintf("\ntest: %s\nresult  : %v\nexpected: %v",
+			code, users, expected))
 	}
 
-	code := `(label "sshkeys" (machine (plaintextKey "key")))`
-	checkKeys(code, code, "key")
-
-	code = `(label "sshkeys" (machine (githubKey "user")))`
-	checkKeys(code, code, "user")
-
-	code = `(label "sshkeys" (machine (githubKey "user") (plaintextKey "key")))`
-	checkKeys(code, code, "user", "key")
+	runtimeErr(t, `(user "gitlab" "ejj")`,
+		`1: user key type must be "github" or "plaintext": "gitlab"`)
+	runtimeErr(t, `(user "github" 1)`, "1: user key must be a string: 1")
+	runtimeErr(t, `(user "github" "ejj" "1.2.3.4")`,
+		`1: user ACL must be a CIDR or "local": "1.2.3.4"`)
+	runtimeErr(t, `(user "github" "ejj" 80)`,
+		`1: user ACL must be a CIDR or "local": 80`)
 }
 
 func TestLabel(t *testing.T) {
@@ -720,13 +764,13 @@ func TestLabel(t *testing.T) {
 	ctx := parseTest(t, code, expCode)
 
 	containerA := &Container{Image: "a", Command: nil,
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)}
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)}
 	containerA.SetLabels([]string{"foo", "bar", "baz", "baz2"})
 	containerB := &Container{Image: "b", Command: nil,
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)}
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)}
 	containerB.SetLabels([]string{"bar", "baz", "baz2"})
 	containerC := &Container{Image: "c", Command: nil,
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)}
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)}
 	containerC.SetLabels([]string{"qux"})
 	expected := []*Container{containerA, containerB, containerC}
 	containerResult := Dsl{"", ctx}.QueryContainers()
@@ -741,7 +785,7 @@ func TestLabel(t *testing.T) {
 	(label "bar" (docker "a") (docker "a"))`
 	ctx = parseTest(t, code, exp)
 	expectedA := &Container{Image: "a", Command: nil,
-		Placement: Placement{make(map[[2]string]struct{})}, Env: make(map[string]string)}
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})}, Env: make(map[string]string)}
 	expectedA.SetLabels([]string{"foo", "bar"})
 	expected = []*Container{expectedA, expectedA}
 	containerResult = Dsl{"", ctx}.QueryContainers()
@@ -797,21 +841,21 @@ func TestPlacement(t *testing.T) {
 	(placement "exclusive" "red" "blue" "yellow")`
 	ctx := parseTest(t, code, expCode)
 	containerA := Container{
-		Image: "a", Placement: Placement{map[[2]string]struct{}{
+		Image: "a", Placement: Placement{Exclusive: map[[2]string]struct{}{
 			[2]string{"blue", "red"}:    {},
 			[2]string{"blue", "yellow"}: {},
 			[2]string{"red", "yellow"}:  {},
 		}}, Env: make(map[string]string)}
 	containerA.SetLabels([]string{"red"})
 	containerB := Container{
-		Image: "b", Placement: Placement{map[[2]string]struct{}{
+		Image: "b", Placement: Placement{Exclusive: map[[2]string]struct{}{
 			[2]string{"blue", "red"}:    {},
 			[2]string{"blue", "yellow"}: {},
 			[2]string{"red", "yellow"}:  {},
 		}}, Env: make(map[string]string)}
 	containerB.SetLabels([]string{"blue"})
 	containerC := Container{
-		Image: "c", Placement: Placement{map[[2]string]struct{}{
+		Image: "c", Placement: Placement{Exclusive: map[[2]string]struct{}{
 			[2]string{"blue", "red"}:    {},
 			[2]string{"blue", "yellow"}: {},
 			[2]string{"red", "yellow"}:  {},
@@ -835,7 +879,7 @@ func TestPlacement(t *testing.T) {
 	(placement "exclusive" "red" (list "blue" "yellow"))`
 	ctx = parseTest(t, code, expCode)
 	containerA = Container{
-		Image: "a", Placement: Placement{map[[2]string]struct{}{
+		Image: "a", Placement: Placement{Exclusive: map[[2]string]struct{}{
 			[2]string{"blue", "red"}:    {},
 			[2]string{"blue", "yellow"}: {},
 			[2]string{"red", "yellow"}:  {},
@@ -853,7 +897,7 @@ func TestPlacement(t *testing.T) {
 	(placement "exclusive" "red" "red" "red")`
 	ctx = parseTest(t, code, code)
 	containerA = Container{
-		Image: "a", Placement: Placement{map[[2]string]struct{}{
+		Image: "a", Placement: Placement{Exclusive: map[[2]string]struct{}{
 			[2]string{"red", "red"}: {},
 		}}, Env: make(map[string]string)}
 	containerA.SetLabels([]string{"red"})
@@ -871,12 +915,12 @@ func TestPlacement(t *testing.T) {
 	(placement "exclusive" "blue" "blue")`
 	ctx = parseTest(t, code, code)
 	containerA = Container{
-		Image: "a", Placement: Placement{map[[2]string]struct{}{
+		Image: "a", Placement: Placement{Exclusive: map[[2]string]struct{}{
 			[2]string{"red", "red"}: {},
 		}}, Env: make(map[string]string)}
 	containerA.SetLabels([]string{"red"})
 	containerB = Container{
-		Image: "b", Placement: Placement{map[[2]string]struct{}{
+		Image: "b", Placement: Placement{Exclusive: map[[2]string]struct{}{
 			[2]string{"blue", "blue"}: {},
 		}}, Env: make(map[string]string)}
 	containerB.SetLabels([]string{"blue"})
@@ -888,6 +932,77 @@ func TestPlacement(t *testing.T) {
 	}
 }
 
+func TestPlacementMachine(t *testing.T) {
+	code := `(label "app" (docker "a"))
+	(label "sidecar" (docker "b"))
+	(placement "colocate" "app" "sidecar")
+	(placement "provider" "AmazonSpot" "app")
+	(placement "region" "us-west-1" "app" "sidecar")
+	(placement "machineLabel" "ssd" "app")
+	(placement "machineLabel" "big" "app")`
+	ctx := parseTest(t, code, code)
+
+	colocate := map[[2]string]struct{}{{"app", "sidecar"}: {}}
+	containerA := Container{
+		Image: "a", Env: make(map[string]string),
+		Placement: Placement{
+			Exclusive: make(map[[2]string]struct{}),
+			Colocate:  colocate,
+			Machine: MachinePlacement{Provider: "AmazonSpot",
+				Region: "us-west-1", Labels: []string{"big", "ssd"}},
+		}}
+	containerA.SetLabels([]string{"app"})
+	containerB := Container{
+		Image: "b", Env: make(map[string]string),
+		Placement: Placement{
+			Exclusive: make(map[[2]string]struct{}),
+			Colocate:  colocate,
+			Machine:   MachinePlacement{Region: "us-west-1"},
+		}}
+	containerB.SetLabels([]string{"sidecar"})
+	expected := []*Container{&containerA, &containerB}
+	containerResult := Dsl{"", ctx}.QueryContainers()
+	if !reflect.DeepEqual(containerResult, expected) {
+		t.Error(spew.Sprintf("\ntest: %s\nresult  : %s\nexpected: %s",
+			code, containerResult, expected))
+	}
+
+	labels := `(label "app" (docker "a")) (label "sidecar" (docker "b"))`
+	runtimeErr(t, labels+`(placement "colocate" "app" "app")`,
+		"1: cannot colocate app with itself")
+	runtimeErr(t, labels+`(placement "colocate" "app")`,
+		"1: colocate placement requires at least 2 labels")
+	runtimeErr(t, labels+`(placement "exclusive" "app" "sidecar")
+	(placement "colocate" "app" "sidecar")`,
+		"2: app and sidecar cannot be both colocated and exclusive")
+	runtimeErr(t, labels+`(placement "colocate" "app" "sidecar")
+	(placement "exclusive" "sidecar" "app")`,
+		"2: app and sidecar cannot be both colocated and exclusive")
+	runtimeErr(t, labels+`(placement "provider" "Google" "app")
+	(placement "provider" "AmazonSpot" "app")`,
+		"2: conflicting provider placement: Google and AmazonSpot")
+	runtimeErr(t, labels+`(placement "region" "us-west-1" "app")
+	(placement "region" "us-east-1" "sidecar")
+	(placement "colocate" "app" "sidecar")`,
+		"3: cannot colocate app and sidecar: "+
+			"conflicting region placement: us-east-1 and us-west-1")
+	runtimeErr(t, labels+`(placement "colocate" "app" "sidecar")
+	(placement "provider" "Google" "app")
+	(placement "provider" "AmazonSpot" "sidecar")`,
+		"3: sidecar is colocated with a container that has a "+
+			"conflicting provider placement: Google and AmazonSpot")
+	runtimeErr(t, labels+`(placement "role" "Boss" "app")`,
+		"1: role placement must be Worker, found: Boss")
+
+	// Containers never run on masters.
+	runtimeErr(t, labels+`(placement "role" "Master" "app")`,
+		"1: role placement must be Worker, found: Master")
+	runtimeErr(t, labels+`(placement "region" "us-west-1")`,
+		"1: region placement requires at least 1 label")
+	runtimeErr(t, labels+`(placement "nearby" "app" "sidecar")`,
+		"1: not a valid placement type: nearby")
+}
+
 func TestEnv(t *testing.T) {
 	code := `(label "red" (docker "a"))
 	(setEnv "red" "key" "value")`
@@ -895,7 +1010,7 @@ func TestEnv(t *testing.T) {
 	(list)`
 	ctx := parseTest(t, code, expCode)
 	containerA := Container{
-		Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 		Env: map[string]string{"key": "value"}}
 	containerA.SetLabels([]string{"red"})
 	expected := []*Container{&containerA}
@@ -911,7 +1026,7 @@ func TestEnv(t *testing.T) {
 	(list)`
 	ctx = parseTest(t, code, expCode)
 	containerA = Container{
-		Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 		Env: map[string]string{"key": "value"}}
 	containerA.SetLabels([]string{"red"})
 	expected = []*Container{&containerA, &containerA, &containerA, &containerA,
@@ -934,11 +1049,11 @@ func TestEnv(t *testing.T) {
 	(list)`
 	ctx = parseTest(t, code, expCode)
 	containerA = Container{
-		Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 		Env: map[string]string{"key1": "value1", "key2": "value2"}}
 	containerA.SetLabels([]string{"foo", "bar", "baz"})
 	containerB := Container{
-		Image: "b", Placement: Placement{make(map[[2]string]struct{})},
+		Image: "b", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 		Env: map[string]string{"key1": "value1", "key2": "value2"}}
 	containerB.SetLabels([]string{"bar", "baz"})
 	expected = []*Container{&containerA, &containerB}
@@ -952,7 +1067,7 @@ func TestEnv(t *testing.T) {
 	expCode = `(list)`
 	ctx = parseTest(t, code, expCode)
 	containerA = Container{
-		Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 		Env: map[string]string{"key": "value"}}
 	expected = []*Container{&containerA}
 	containerResult = Dsl{"", ctx}.QueryContainers()
@@ -965,7 +1080,7 @@ func TestEnv(t *testing.T) {
 	(setEnv foo "key" "value"))`
 	ctx = parseTest(t, code, "(list)")
 	containerA = Container{
-		Image: "a", Placement: Placement{make(map[[2]string]struct{})},
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 		Env:      map[string]string{"key": "value"},
 		atomImpl: atomImpl{labels: []string{"bar"}},
 	}
@@ -976,8 +1091,211 @@ func TestEnv(t *testing.T) {
 			code, containerResult, expected))
 	}
 
+	code = `(setEnv (docker "a" "cmd") "password" (secret "db_password"))`
+	ctx = parseTest(t, code, "(list)")
+	containerA = Container{
+		Image: "a", Command: []string{"cmd"},
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})},
+		Env:       map[string]string{},
+		SecretEnv: map[string]string{"password": "db_password"},
+	}
+	expected = []*Container{&containerA}
+	containerResult = Dsl{"", ctx}.QueryContainers()
+	if !reflect.DeepEqual(containerResult, expected) {
+		t.Error(spew.Sprintf("\ntest: %s\nresult  : %s\nexpected: %s",
+			code, containerResult, expected))
+	}
+
+	// Only the name of the secret may appear in the evaluated spec.
+	parseTest(t, `(secret "db_password")`, `(secret "db_password")`)
+	cStr := (*ctx.containers)[0].String()
+	if exp := `(docker "a" "cmd" (hmap ("password" (secret "db_password"))))`; cStr != exp {
+		t.Errorf("bad container string: %s, expected %s", cStr, exp)
+	}
+
+	runtimeErr(t, `(secret "../etc/passwd")`,
+		`1: invalid secret name: "../etc/passwd"`)
+	runtimeErr(t, `(secret 1)`, "1: invalid secret name: 1")
 	runtimeErr(t, `(setEnv (docker "foo") 1 "value")`, "1: setEnv key must be a string: 1")
-	runtimeErr(t, `(setEnv (docker "foo") "key" 1)`, "1: setEnv value must be a string: 1")
+	runtimeErr(t, `(setEnv (docker "foo") "key" 1)`,
+		"1: setEnv value must be a string or secret: 1")
+}
+
+func TestResources(t *testing.T) {
+	code := `(label "red" (makeList 2 (docker "a")))
+	(setMemoryLimit "red" 512)
+	(setCPUShares "red" 256)`
+	expCode := `(label "red" (docker "a") (docker "a"))
+	(list)
+	(list)`
+	ctx := parseTest(t, code, expCode)
+	containerA := Container{
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
+		Env:       map[string]string{},
+		Resources: Resources{Memory: 512, CPUShares: 256}}
+	containerA.SetLabels([]string{"red"})
+	expected := []*Container{&containerA, &containerA}
+	containerResult := Dsl{"", ctx}.QueryContainers()
+	if !reflect.DeepEqual(containerResult, expected) {
+		t.Error(spew.Sprintf("\ntest: %s\nresult  : %s\nexpected: %s",
+			code, containerResult, expected))
+	}
+
+	code = `(setMemoryLimit (list (docker "a")) 128)`
+	ctx = parseTest(t, code, "(list)")
+	containerA = Container{
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
+		Env:       map[string]string{},
+		Resources: Resources{Memory: 128}}
+	expected = []*Container{&containerA}
+	containerResult = Dsl{"", ctx}.QueryContainers()
+	if !reflect.DeepEqual(containerResult, expected) {
+		t.Error(spew.Sprintf("\ntest: %s\nresult  : %s\nexpected: %s",
+			code, containerResult, expected))
+	}
+
+	runtimeErr(t, `(setMemoryLimit (docker "foo") "1G")`,
+		`1: setMemoryLimit limit must be a non-negative integer: "1G"`)
+	runtimeErr(t, `(setCPUShares (docker "foo") (- 0 1))`,
+		"1: setCPUShares limit must be a non-negative integer: -1")
+	runtimeErr(t, `(setCPUShares "missing" 1)`,
+		`1: cannot setCPUShares on invalid label: "missing"`)
+	runtimeErr(t, `(setCPUShares (machine) 1)`,
+		"1: setCPUShares target must be either a label or container: (machine)")
+}
+
+func TestVolumes(t *testing.T) {
+	code := `(label "db" (makeList 2 (docker "a")))
+	(define data (volume "data" "/var/lib/mysql"))
+	(mount "db" data (volume "/etc/mysql" "/etc/mysql"))
+	(mount "db" data)
+	data`
+	expCode := `(label "db" (docker "a") (docker "a"))
+	(list)
+	(list)
+	(list)
+	(volume "data" "/var/lib/mysql")`
+	ctx := parseTest(t, code, expCode)
+	containerA := Container{
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
+		Env:     map[string]string{},
+		Volumes: []string{"data:/var/lib/mysql", "/etc/mysql:/etc/mysql"}}
+	containerA.SetLabels([]string{"db"})
+	expected := []*Container{&containerA, &containerA}
+	containerResult := Dsl{"", ctx}.QueryContainers()
+	if !reflect.DeepEqual(containerResult, expected) {
+		t.Error(spew.Sprintf("\ntest: %s\nresult  : %s\nexpected: %s",
+			code, containerResult, expected))
+	}
+
+	runtimeErr(t, `(volume "my data" "/data")`,
+		`1: volume source must be an absolute path or a volume name: "my data"`)
+	runtimeErr(t, `(volume "data" "data")`,
+		`1: volume path must be absolute: "data"`)
+	runtimeErr(t, `(mount (docker "a") "data")`,
+		`1: mount requires volumes: "data"`)
+	runtimeErr(t, `(mount (docker "a") (volume "a" "/data") (volume "b" "/data"))`,
+		`1: conflicting volumes mounted at "/data": `+
+			`(volume "a" "/data") (volume "b" "/data")`)
+}
+
+func TestFiles(t *testing.T) {
+	code := `(label "zk" (makeList 2 (docker "a")))
+	(setFile "zk" "/conf/zoo.cfg" "tickTime=2000")
+	(setFile "zk" "/conf/start.sh" "#!/bin/sh" "0755")
+	(setFile "zk" "/conf/zoo.cfg" (list "tickTime=1000" "dataDir=/data"))`
+	expCode := `(label "zk" (docker "a") (docker "a"))
+	(list)
+	(list)
+	(list)`
+	ctx := parseTest(t, code, expCode)
+	containerA := Container{
+		Image: "a", Placement: Placement{Exclusive: make(map[[2]string]struct{})},
+		Env: map[string]string{},
+		Files: []File{
+			{Path: "/conf/start.sh", Content: "#!/bin/sh", Mode: 0755},
+			{Path: "/conf/zoo.cfg", Content: "tickTime=1000\ndataDir=/data\n",
+				Mode: 0644}}}
+	containerA.SetLabels([]string{"zk"})
+	expected := []*Container{&containerA, &containerA}
+	containerResult := Dsl{"", ctx}.QueryContainers()
+	if !reflect.DeepEqual(containerResult, expected) {
+		t.Error(spew.Sprintf("\ntest: %s\nresult  : %s\nexpected: %s",
+			code, containerResult, expected))
+	}
+
+	runtimeErr(t, `(setFile (docker "a") "zoo.cfg" "")`,
+		`1: setFile path must be an absolute file path: "zoo.cfg"`)
+	runtimeErr(t, `(setFile (docker "a") "/" "")`,
+		`1: setFile path must be an absolute file path: "/"`)
+	runtimeErr(t, `(setFile (docker "a") "/zoo.cfg" 1)`,
+		"1: setFile content must be a string or a list of lines: 1")
+	runtimeErr(t, `(setFile (docker "a") "/zoo.cfg" (list "a" 1))`,
+		`1: setFile content must be a string or a list of lines: (list "a" 1)`)
+	runtimeErr(t, `(setFile (docker "a") "/zoo.cfg" "" "0855")`,
+		`1: setFile mode must be an octal string: "0855"`)
+	runtimeErr(t, `(setFile (docker "a") "/zoo.cfg" "" 644)`,
+		"1: setFile mode must be an octal string: 644")
+	runtimeErr(t, `(setFile (machine) "/zoo.cfg" "")`,
+		"1: setFile target must be either a label or container: (machine)")
+}
+
+func TestHealthCheck(t *testing.T) {
+	checkHealth := func(code string, exp HealthCheck) {
+		ctx := parseTest(t, code, "(list)")
+		containers := Dsl{"", ctx}.QueryContainers()
+		if len(containers) != 1 ||
+			!reflect.DeepEqual(containers[0].HealthCheck, exp) {
+			t.Error(spew.Sprintf("\ntest: %s\nresult  : %v\nexpected: %v",
+				code, containers, exp))
+		}
+	}
+
+	checkHealth(`(healthCheck (docker "a") "tcp" 80)`,
+		HealthCheck{Type: "tcp", Port: 80})
+	checkHealth(`(healthCheck (docker "a") "http" 8080)`,
+		HealthCheck{Type: "http", Port: 8080, Path: "/"})
+	checkHealth(`(healthCheck (docker "a") "http" 8080 "/health")`,
+		HealthCheck{Type: "http", Port: 8080, Path: "/health"})
+	checkHealth(`(healthCheck (list (docker "a")) "exec" "pg_isready" "-q")`,
+		HealthCheck{Type: "exec", Command: []string{"pg_isready", "-q"}})
+
+	runtimeErr(t, `(healthCheck (docker "a") "udp" 53)`,
+		`1: unknown healthCheck type: "udp"`)
+	runtimeErr(t, `(healthCheck (docker "a") "tcp" 0)`,
+		"1: healthCheck port must be an integer between 1 and 65535: 0")
+	runtimeErr(t, `(healthCheck (docker "a") "tcp" 80 "/")`,
+		"1: tcp healthCheck takes only a port")
+	runtimeErr(t, `(healthCheck (docker "a") "http" 80 "health")`,
+		`1: healthCheck path must be absolute: "health"`)
+	runtimeErr(t, `(healthCheck (docker "a") "exec" 1)`,
+		"1: expected string, found: 1")
+	runtimeErr(t, `(healthCheck (machine) "tcp" 80)`,
+		"1: healthCheck target must be either a label or container: (machine)")
+}
+
+func TestRollingUpdate(t *testing.T) {
+	code := `(label "web" (makeList 2 (docker "a")))
+	(rollingUpdate "web" 0 1)`
+	ctx := parseTest(t, code, `(label "web" (docker "a") (docker "a"))
+	(list)`)
+	for _, c := range (Dsl{"", ctx}).QueryContainers() {
+		exp := RollingUpdate{MaxUnavailable: 0, MaxSurge: 1}
+		if c.RollingUpdate != exp {
+			t.Errorf("bad rolling update: %v, expected %v", c.RollingUpdate, exp)
+		}
+	}
+
+	runtimeErr(t, `(rollingUpdate (docker "a") (- 0 1) 1)`,
+		"1: rollingUpdate maxUnavailable must be a non-negative integer: -1")
+	runtimeErr(t, `(rollingUpdate (docker "a") 1 "1")`,
+		`1: rollingUpdate maxSurge must be a non-negative integer: "1"`)
+	runtimeErr(t, `(rollingUpdate (docker "a") 0 0)`,
+		"1: rollingUpdate requires a positive maxUnavailable or maxSurge")
+	runtimeErr(t, `(rollingUpdate (docker "a") 1 1 1)`,
+		`1: rollingUpdate requires exactly 3 arguments: (list (docker "a") 1 1 1)`)
+	runtimeErr(t, `(rollingUpdate (machine) 1 1)`,
+		"1: rollingUpdate target must be either a label or container: (machine)")
 }
 
 func TestConnect(t *testing.T) {
@@ -998,20 +1316,30 @@ func TestConnect(t *testing.T) {
 	((lambda () (connect 80 "h" "h")))
 	(let ((i (label "i" (docker "alpine"))))
 	  (connect 80  i i))
-	(connect (list 100 65535) "g" "g"))`
+	(connect (list 100 65535) "g" "g")
+	(connect (list 10000 20000) "public" "a")
+	(connect (tcp 5432) "a" "d")
+	(connect (udp (list 53 54)) "b" "d")
+	(connect (icmp) "a" "e"))`
 	ctx := parseTest(t, code, `(list)`)
 
 	expected := map[Connection]struct{}{
-		{"a", "b", 80, 80}:     {},
-		{"a", "c", 80, 80}:     {},
-		{"b", "c", 1, 65534}:   {},
-		{"a", "c", 0, 65535}:   {},
-		{"c", "d", 443, 443}:   {},
-		{"c", "e", 443, 443}:   {},
-		{"c", "f", 443, 443}:   {},
-		{"h", "h", 80, 80}:     {},
-		{"g", "g", 100, 65535}: {},
-		{"i", "i", 80, 80}:     {},
+		{"a", "b", "", 80, 80, false}:     {},
+		{"a", "c", "", 80, 80, false}:     {},
+		{"b", "c", "", 1, 65534, false}:   {},
+		{"a", "c", "", 0, 65535, false}:   {},
+		{"c", "d", "", 443, 443, false}:   {},
+		{"c", "e", "", 443, 443, false}:   {},
+		{"c", "f", "", 443, 443, false}:   {},
+		{"h", "h", "", 80, 80, false}:     {},
+		{"g", "g", "", 100, 65535, false}: {},
+		{"i", "i", "", 80, 80, false}:     {},
+
+		{"public", "a", "", 10000, 20000, false}: {},
+
+		{"a", "d", "tcp", 5432, 5432, false}: {},
+		{"b", "d", "udp", 53, 54, false}:     {},
+		{"a", "e", "icmp", 0, 0, false}:      {},
 	}
 
 	for exp := range expected {
@@ -1040,10 +1368,90 @@ func TestConnect(t *testing.T) {
 		"1: port range must be an int or a list of ints: \"80\"")
 	runtimeErr(t, `(connect (list "a" "b") "foo" "bar")`,
 		"1: port range must have two ints: (list \"a\" \"b\")")
+	parseTest(t, `(tcp 80)`, `(tcp 80)`)
+	parseTest(t, `(udp (list 1 2))`, `(udp (list 1 2))`)
+	parseTest(t, `(icmp)`, `(icmp)`)
+
+	runtimeErr(t, `(connect (tcp 70000) "foo" "bar")`,
+		"1: invalid port range: [70000, 70000]")
+	runtimeErr(t, `(tcp 80 81)`, "1: tcp requires exactly 1 argument: (list 80 81)")
+	runtimeErr(t, `(icmp 80)`, "1: icmp takes no arguments: (list 80)")
 	runtimeErr(t, `(connect 80 4 5)`, "1: expected label, found: 4")
 	runtimeErr(t, `(connect 80 "foo" "foo")`, "1: expected label, found: \"foo\"")
 }
 
+func TestDeny(t *testing.T) {
+	code := `(label "batch" (docker "alpine"))
+	(label "web" (docker "alpine"))
+	(label "deployment" "batch" "web")
+	(label "database" (docker "alpine"))
+	(connect 5432 "deployment" "database")
+	(deny (tcp 5432) "batch" "database")`
+	expCode := `(label "batch" (docker "alpine"))
+	(label "web" (docker "alpine"))
+	(label "deployment" (docker "alpine") (docker "alpine"))
+	(label "database" (docker "alpine"))
+	(list)
+	(list)`
+	ctx := parseTest(t, code, expCode)
+
+	expected := map[Connection]struct{}{
+		{"deployment", "database", "", 5432, 5432, false}: {},
+		{"batch", "database", "tcp", 5432, 5432, true}:    {},
+	}
+	if !reflect.DeepEqual(ctx.connections, expected) {
+		t.Error(spew.Sprintf("bad connections: %v, expected %v",
+			ctx.connections, expected))
+	}
+
+	hosts := `(label "ext" (host "8.8.8.8")) (label "a" (docker "alpine"))`
+	runtimeErr(t, hosts+`(deny 80 "a" "ext")`,
+		"1: cannot deny connections to hosts: ext")
+	runtimeErr(t, hosts+`(deny 80 "public" "a")`,
+		"1: cannot deny connections with the Public Internet")
+	runtimeErr(t, hosts+`(deny 80 "a" "public")`,
+		"1: cannot deny connections with the Public Internet")
+}
+
+func TestHost(t *testing.T) {
+	code := `(label "a" (docker "alpine"))
+	(label "ext" (host "external.org") (host "8.8.8.0/24"))
+	(connect 443 "a" "ext")`
+	expCode := `(label "a" (docker "alpine"))
+	(label "ext" (host "external.org") (host "8.8.8.0/24"))
+	(list)`
+	ctx := parseTest(t, code, expCode)
+
+	hostA := Host{Hostname: "external.org"}
+	hostA.SetLabels([]string{"ext"})
+	hostB := Host{Hostname: "8.8.8.0/24"}
+	hostB.SetLabels([]string{"ext"})
+	expected := []Host{hostA, hostB}
+	if hosts := (Dsl{"", ctx}).QueryHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Error(spew.Sprintf("\ntest: %s\nresult  : %s\nexpected: %s",
+			code, hosts, expected))
+	}
+
+	exp := Connection{From: "a", To: "ext", MinPort: 443, MaxPort: 443}
+	if _, ok := ctx.connections[exp]; !ok || len(ctx.connections) != 1 {
+		t.Error(spew.Sprintf("Bad connections: %v", ctx.connections))
+	}
+
+	runtimeErr(t, `(host 5)`, "1: host must be a string: 5")
+	runtimeErr(t, `(host "a" "b")`,
+		`1: host requires exactly 1 argument: (list "a" "b")`)
+	runtimeErr(t, `(host "bad host")`,
+		"1: host must be a hostname, IP, or CIDR: bad host")
+
+	hosts := `(label "a" (docker "alpine"))
+	(label "ext" (host "1.2.3.4"))
+	`
+	runtimeErr(t, hosts+`(connect 80 "ext" "a")`,
+		"3: hosts cannot initiate connections: ext")
+	runtimeErr(t, hosts+`(connect 80 "public" "ext")`,
+		"3: cannot connect Public Internet to hosts: ext")
+}
+
 func TestImport(t *testing.T) {
 	// Test module keyword
 	code := `(module "math" (define Square (lambda (x) (* x x)))) (math.Square 2)`
@@ -1096,18 +1504,21 @@ func TestImport(t *testing.T) {
 	util.AppFs = testFs
 	util.WriteFile("bad.spec", []byte(`(define BadFunc (lambda () (+ 1 "1")))`), 0644)
 	runtimeErrImport(t, `(import "bad") (bad.BadFunc)`,
-		`./bad.spec:1: bad arithmetic argument: "1"`, []string{"."})
+		"./bad.spec:1: bad arithmetic argument: \"1\"\n"+
+			"\tin bad.BadFunc, called from 1", []string{"."})
 
 	testFs = afero.NewMemMapFs()
 	util.AppFs = testFs
 	util.WriteFile("A.spec", []byte(`(import "A")`), 0644)
-	importErr(t, `(import "A")`, `import cycle: [A A]`, []string{"."})
+	importErr(t, `(import "A")`, `./A.spec:1: import cycle: [A A]`,
+		[]string{"."})
 
 	testFs = afero.NewMemMapFs()
 	util.AppFs = testFs
 	util.WriteFile("A.spec", []byte(`(import "B")`), 0644)
 	util.WriteFile("B.spec", []byte(`(import "A")`), 0644)
-	importErr(t, `(import "A")`, `import cycle: [A B A]`, []string{"."})
+	importErr(t, `(import "A")`, `./B.spec:1: import cycle: [A B A]`,
+		[]string{"."})
 
 	testFs = afero.NewMemMapFs()
 	util.AppFs = testFs
@@ -1238,6 +1649,294 @@ func TestErrorMetadata(t *testing.T) {
 	// error messages.
 	util.WriteFile("generated_sexp.spec", []byte(`(apply + (list 1 "1"))`), 0644)
 	checkEvalErr("generated_sexp.spec", `generated_sexp.spec:1: bad arithmetic argument: "1"`)
+
+	// Test that errors within lambdas and modules trace back to their call sites.
+	util.WriteFile("strs.spec", []byte(`
+(define (add a b) (+ a b))
+(define (Join a b) (add a b))`), 0644)
+	util.WriteFile("call.spec", []byte(`(import "strs")
+
+(map (lambda (x) (strs.Join 1 x)) (list 1 "a"))`), 0644)
+	checkImportErr := func(path string, expErr string) {
+		sc := scanner.Scanner{Position: scanner.Position{Filename: path}}
+		f, err := util.Open(path)
+		if err != nil {
+			t.Errorf("Couldn't open %s", path)
+		}
+
+		_, err = New(*sc.Init(f), []string{"."})
+		if err == nil || err.Error() != expErr {
+			t.Errorf("Expected \"%s\"\ngot \"%s\"", expErr, err)
+		}
+	}
+	checkImportErr("call.spec", `./strs.spec:2: bad arithmetic argument: "a"
+	in add, called from ./strs.spec:3
+	in strs.Join, called from call.spec:3
+	in lambda, called from call.spec:3`)
+
+	util.WriteFile("badmod.spec", []byte(`(define X 1)
+(+ X "a")`), 0644)
+	util.WriteFile("import.spec", []byte(`
+(import "badmod")`), 0644)
+	checkImportErr("import.spec", `./badmod.spec:2: bad arithmetic argument: "a"
+	in module badmod, called from import.spec:2`)
+}
+
+func TestLint(t *testing.T) {
+	lintTest(t, `(define x (* 0.5 (int "4")))
+(label "a" (docker "x"))
+(connect 80 "a" "a")
+(machine (role "Master") (provider "Amazon") (size "m4.large"))`)
+
+	lintTest(t, `(foo 1)
+(bar)
+(+ x y)
+(define x 1)`,
+		"1: unknown function: foo",
+		"2: unknown function: bar",
+		"3: unassigned variable: y")
+
+	lintTest(t, `(docker 1.5)
+(label "Foo" (docker "a"))
+(role "Boss")
+(provider "Azure")
+(len 1 2)`,
+		"1: bad argument to docker: 1.5",
+		`2: labels must be lowercase: "Foo"`,
+		`3: unknown role: "Boss"`,
+		`4: unknown provider: "Azure"`,
+		"5: too many arguments: len",
+		"5: bad argument to len: 1",
+		"5: bad argument to len: 2")
+
+	lintTest(t, `(define (f a) a)
+(f 1 2)
+(let ((a 1) (b a)) (+ a b))
+(lambda (x) (+ x 1))
+(define g (lambda (a b) a))
+(g 1)`,
+		"2: wrong number of arguments to f: expected 1, found 2",
+		"6: wrong number of arguments to g: expected 2, found 1")
+
+	lintTest(t, `(machine (provider "Amazon") (region "eu") (size "big"))
+(1 2)`,
+		`1: unknown region for Amazon: "eu"`,
+		`1: unknown size for Amazon: "big"`,
+		"2: S-expressions must start with a function call: (1 2)")
+
+	// Problems that are only found by evaluating the spec.
+	lintTest(t, `(define big "big")
+(machine (provider "Amazon"))
+(machine (role "Master") (provider "Vagrant"))
+(machine (role "Master") (provider "Amazon") (size big))
+(label "a" (docker "x"))
+(label "b" (docker "y"))
+(connect 80 "b" "public")`,
+		`2: machine has no role: (machine (provider "Amazon"))`,
+		`4: unknown size for Amazon: "big"`,
+		"5: label has no connections, so its containers can't "+
+			"communicate: a")
+
+	lintTest(t, `(+ 1 "a")`, `1: bad argument to +: "a"`)
+	lintTest(t, `(define a "a")
+(+ 1 a)`, `2: bad arithmetic argument: "a"`)
+	lintTest(t, `(import "missing")`, "1: unable to open import missing")
+
+	// Evaluation goes on past the forms that fail, but skips the forms that use
+	// what they define.
+	lintTest(t, `(define x (+ 1 "a"))
+(label "a" (docker x))
+(machine (provider "Amazon"))
+(define y (nth 5 (list)))
+(machine (provider y))
+(machine (role "Worker"))`,
+		`1: bad argument to +: "a"`,
+		`3: machine has no role: (machine (provider "Amazon"))`,
+		"4: array index out of bounds: 5",
+		`6: machine has no provider: (machine (role "Worker"))`)
+	lintTest(t, `(define AdminACL (list "local"))`, "1: AdminACL is no longer "+
+		"supported, give the ACLs to (user ...) instead")
+	lintTest(t, `(define p 1)
+(machine (provider p))`, "2: provider must be a string: 1")
+}
+
+func TestFormat(t *testing.T) {
+	formatTest(t, "(define x 1)", "(define x 1)\n")
+	formatTest(t, "\n\n  (define   x 1)(define y 2)\n\n\n(+ x y)\n\n",
+		"(define x 1)\n(define y 2)\n\n(+ x y)\n")
+
+	// Bodies are indented, arguments are aligned with the first.
+	formatTest(t, `(define (f a b)
+(if (= a b)
+a
+(list a
+b)))`, `(define (f a b)
+  (if (= a b)
+    a
+    (list a
+          b)))
+`)
+	formatTest(t, `(reduce
+(lambda (x y) (+ x y))
+        lst)`, `(reduce
+  (lambda (x y) (+ x y))
+  lst)
+`)
+	formatTest(t, `(let ((a 1)
+(b 2))
+(hmap ("a" a)
+("b" b)))`, `(let ((a 1)
+      (b 2))
+  (hmap ("a" a)
+        ("b" b)))
+`)
+
+	// Comments are kept.
+	formatTest(t, `// The first comment.
+(define x 1)   // Trailing.
+
+   // Indented.
+(list 1 // One.
+2 /* Two. */ 3
+// Last.
+)`, `// The first comment.
+(define x 1) // Trailing.
+
+// Indented.
+(list 1 // One.
+      2 /* Two. */ 3
+      // Last.
+)
+`)
+
+	formatTest(t, "(list -1 (- 0 1) 1.50 true a.b)",
+		"(list -1 (- 0 1) 1.50 true a.b)\n")
+	formatTest(t, "(and (<= a b) (>= a b) (!= a b) (! a))",
+		"(and (<= a b) (>= a b) (!= a b) (! a))\n")
+
+	var sc scanner.Scanner
+	_, err := Format(*sc.Init(strings.NewReader("(list 1))")))
+	if err == nil || err.Error() != "1: unbalanced Parenthesis" {
+		t.Errorf("expected an unbalanced parenthesis error, found: %v", err)
+	}
+
+	sc.Filename = "test.di"
+	_, err = Format(*sc.Init(strings.NewReader("define x = 1")))
+	if err == nil {
+		t.Error("expected an error formatting the infix syntax")
+	}
+}
+
+func TestInfix(t *testing.T) {
+	infixTest(t, `define x = 1 + 2 * 3 - -4`, `(define x (- (+ 1 (* 2 3)) -4))`)
+	infixTest(t, `define y = (1 + 2) * x % 3 / -x`,
+		`(define y (/ (% (* (+ 1 2) x) 3) (- 0 x)))`)
+	infixTest(t, `a == b || a != b && !(a <= b) && a >= b && a < b && a > b`,
+		`(or (= a b) (and (and (and (and (!= a b) (! (<= a b))) (>= a b)) `+
+			`(< a b)) (> a b)))`)
+
+	// Calls, lists, hmaps and lambdas.
+	infixTest(t, `strings.Join(map(fn(x) { x + 1 }, [1, 2.5, "a", true]), ",")
+{"a": 1, "b": [ ]}
+fn() {}`, `(strings.Join (map (lambda (x) (+ x 1)) (list 1 2.5 "a" true)) ",")
+(hmap ("a" 1) ("b" (list)))
+(lambda ())`)
+
+	// A newline ends an expression, unless it follows an operator.
+	infixTest(t, `f
+(1)
+x -
+1
+x
+-1`, `f 1 (- x 1) x -1`)
+
+	infixTest(t, `import "strings"
+define Namespace = "test"
+define double(a) {
+	let b = a
+	let c = b + b
+	log("doubling")
+	c
+}
+define choose(a) {
+	if a > 1 { "big" } else if a == 1 { "one" } else {
+		log("small")
+		"small"
+	}
+}`, `(import "strings")
+(define Namespace "test")
+(define (double a) (let ((b a) (c (+ b b))) (log "doubling") c))
+(define (choose a) (if (> a 1) "big" (if (= a 1) "one" (progn (log "small") "small"))))`)
+
+	// The block forms, and the builtins that share their names.
+	infixTest(t, `label "web" {
+	docker("nginx")
+	docker("nginx")
+}
+label("db", docker("postgres"))
+connect 80 {
+	"public" -> "web"
+	"web" -> "db"
+}
+deny [1, 100] { "db" -> "web" }
+connect(22, "web", "db")
+module "m" { define X = 1 }`, `(label "web" (list (docker "nginx") (docker "nginx")))
+(label "db" (docker "postgres"))
+(progn (connect 80 "public" "web") (connect 80 "web" "db"))
+(progn (deny (list 1 100) "db" "web"))
+(connect 22 "web" "db")
+(module "m" (define X 1))`)
+
+	infixErr(t, "define x 1", "1: expected (, found 1")
+	infixErr(t, "let x = 1", "1: let is only allowed within a block")
+	infixErr(t, "define (f) {}", "1: expected a name, found (")
+	infixErr(t, "\nf(1, 2", "2: expected ,, found end of file")
+	infixErr(t, "connect 80 { a b }", "1: expected ->, found b")
+	infixErr(t, "x & y", "1: bad element: &")
+	infixErr(t, "x $ y", "1: bad element: $")
+
+	// Specs in either syntax may import each other.
+	util.AppFs = afero.NewMemMapFs()
+	util.WriteFile("lisp.spec", []byte(`(import "infix")
+(define (Twice x) (* 2 (infix.Inc x)))`), 0644)
+	util.WriteFile("infix.di", []byte(`define Inc(x) { x + 1 }`), 0644)
+	util.WriteFile("main.di", []byte(`import "lisp"
+define Namespace = "ns"
+label "web" { makeList(lisp.Twice(1), docker("nginx")) }
+connect 80 { "public" -> "web" }`), 0644)
+
+	f, _ := util.Open("main.di")
+	sc := scanner.Scanner{Position: scanner.Position{Filename: "main.di"}}
+	spec, err := New(*sc.Init(f), []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(spec.QueryContainers()); n != 4 {
+		t.Errorf("expected 4 containers, found %d", n)
+	}
+
+	if ns := spec.QueryString("Namespace"); ns != "ns" {
+		t.Errorf("bad namespace: %s", ns)
+	}
+
+	expConns := []Connection{{From: "public", To: "web", MinPort: 80, MaxPort: 80}}
+	if conns := spec.QueryConnections(); !reflect.DeepEqual(conns, expConns) {
+		t.Errorf("bad connections: %s", spew.Sdump(conns))
+	}
+
+	// Errors point into the infix spec.
+	util.WriteFile("bad.di", []byte(`define f(x) {
+	x + "a"
+}
+f(1)`), 0644)
+	f, _ = util.Open("bad.di")
+	sc = scanner.Scanner{Position: scanner.Position{Filename: "bad.di"}}
+	_, err = New(*sc.Init(f), nil)
+	expErr := "bad.di:2: bad arithmetic argument: \"a\"\n\tin f, called from bad.di:4"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("expected %q, found %q", expErr, err)
+	}
 }
 
 func TestQuery(t *testing.T) {
@@ -1407,6 +2106,70 @@ func runtimeErrImport(t *testing.T, code, expectedErr string, path []string) {
 	}
 }
 
+func lintTest(t *testing.T, code string, expected ...string) {
+	providers := map[string]ProviderInfo{
+		"Amazon":  {Regions: []string{"us-west-1"}, Sizes: []string{"m4.large"}},
+		"Vagrant": {},
+	}
+
+	var sc scanner.Scanner
+	var errs []string
+	for _, err := range Lint(*sc.Init(strings.NewReader(code)), nil, providers) {
+		errs = append(errs, err.Error())
+	}
+
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("%s: expected %s, found %s", code, spew.Sdump(expected),
+			spew.Sdump(errs))
+	}
+}
+
+func formatTest(t *testing.T, code, expected string) {
+	var sc scanner.Scanner
+	formatted, err := Format(*sc.Init(strings.NewReader(code)))
+	if err != nil {
+		t.Errorf("%s: %s", code, err)
+		return
+	}
+
+	if formatted != expected {
+		t.Errorf("%s: expected:\n%s\nfound:\n%s", code, expected, formatted)
+		return
+	}
+
+	again, err := Format(*sc.Init(strings.NewReader(formatted)))
+	if err != nil || again != formatted {
+		t.Errorf("%s: formatting isn't idempotent: %s", code, again)
+	}
+
+	orig, _ := parse(*sc.Init(strings.NewReader(code)))
+	parsed, _ := parse(*sc.Init(strings.NewReader(formatted)))
+	if fmt.Sprint(orig) != fmt.Sprint(parsed) {
+		t.Errorf("%s: formatting changed the code: %s", code, parsed)
+	}
+}
+
+func infixTest(t *testing.T, code, expCode string) {
+	sc := scanner.Scanner{Position: scanner.Position{Filename: "test.di"}}
+	parsed, err := parseSource(*sc.Init(strings.NewReader(code)))
+	if err != nil {
+		t.Errorf("%s: %s", code, err)
+		return
+	}
+
+	if !codeEq(astRoot(parsed).String(), expCode) {
+		t.Errorf("%s: expected %s, found %s", code, expCode, astRoot(parsed))
+	}
+}
+
+func infixErr(t *testing.T, code, expErr string) {
+	var sc scanner.Scanner
+	_, err := parseInfix(*sc.Init(strings.NewReader(code)))
+	if fmt.Sprint(err) != expErr {
+		t.Errorf("%s: expected %q, found %q", code, expErr, err)
+	}
+}
+
 func importErr(t *testing.T, code, expectedErr string, path []string) {
 	var sc scanner.Scanner
 	prog, err := parse(*sc.Init(strings.NewReader(code)))
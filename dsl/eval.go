@@ -8,6 +8,8 @@ type evalCtx struct {
 	connections map[Connection]struct{}
 	machines    *[]*astMachine
 	containers  *[]*astContainer
+	hosts       *[]*astHost
+	users       *[]*astUser
 
 	parent *evalCtx
 }
@@ -36,6 +38,8 @@ func (ctx *evalCtx) deepCopy() *evalCtx {
 		connections: ctx.connections,
 		machines:    ctx.machines,
 		containers:  ctx.containers,
+		hosts:       ctx.hosts,
+		users:       ctx.users,
 		parent:      parentCopy,
 	}
 }
@@ -100,13 +104,13 @@ func evalLambda(fn astLambda, funcArgs []ast) (ast, error) {
 func (metaSexp astSexp) eval(ctx *evalCtx) (ast, error) {
 	sexp := metaSexp.sexp
 	if len(sexp) == 0 {
-		return nil, dslError{metaSexp.pos, fmt.Errorf("S-expressions must start with a function call: %s", metaSexp)}
+		return nil, dslError{pos: metaSexp.pos, err: fmt.Errorf("S-expressions must start with a function call: %s", metaSexp)}
 	}
 
 	first, err := sexp[0].eval(ctx)
 	if err != nil {
 		if _, ok := sexp[0].(astIdent); ok {
-			return nil, dslError{metaSexp.pos, fmt.Errorf("unknown function: %s", sexp[0])}
+			return nil, dslError{pos: metaSexp.pos, err: fmt.Errorf("unknown function: %s", sexp[0])}
 		}
 		return nil, err
 	}
@@ -116,8 +120,8 @@ func (metaSexp astSexp) eval(ctx *evalCtx) (ast, error) {
 	case astIdent:
 		fnImpl := funcImplMap[fn]
 		if len(sexp)-1 < fnImpl.minArgs {
-			return nil, dslError{metaSexp.pos,
-				fmt.Errorf("not enough arguments: %s", fn)}
+			return nil, dslError{pos: metaSexp.pos,
+				err: fmt.Errorf("not enough arguments: %s", fn)}
 		}
 
 		args := sexp[1:]
@@ -136,15 +140,33 @@ func (metaSexp astSexp) eval(ctx *evalCtx) (ast, error) {
 		}
 		res, err = evalLambda(fn, args)
 	default:
-		return nil, dslError{metaSexp.pos, fmt.Errorf("S-expressions must start with a function call: %s", first)}
+		return nil, dslError{pos: metaSexp.pos, err: fmt.Errorf("S-expressions must start with a function call: %s", first)}
 	}
 
 	if err != nil {
-		err = dslError{pos: metaSexp.pos, err: err}
+		err = dslError{pos: metaSexp.pos, err: err, call: callName(sexp, first, err)}
 	}
 	return res, err
 }
 
+// callName returns the name of the lambda called by 'sexp', if 'err' occurred
+// within it.  Errors in the lambda's body are positioned, unlike those in calling
+// it.
+func callName(sexp []ast, fn ast, err error) string {
+	if _, ok := err.(dslError); !ok {
+		return ""
+	}
+
+	if _, ok := fn.(astLambda); !ok {
+		return ""
+	}
+
+	if name, ok := sexp[0].(astIdent); ok {
+		return string(name)
+	}
+	return "lambda"
+}
+
 func (ident astIdent) eval(ctx *evalCtx) (ast, error) {
 	// If the ident represents a built-in function, just return the identifier.
 	// S-exp eval will know what to do with it.
@@ -200,10 +222,18 @@ func (r astRegion) eval(ctx *evalCtx) (ast, error) {
 	return r, nil
 }
 
+func (v astVolume) eval(ctx *evalCtx) (ast, error) {
+	return v, nil
+}
+
 func (r astRange) eval(ctx *evalCtx) (ast, error) {
 	return r, nil
 }
 
+func (p astProtocol) eval(ctx *evalCtx) (ast, error) {
+	return p, nil
+}
+
 func (l astLambda) eval(ctx *evalCtx) (ast, error) {
 	return l, nil
 }
@@ -219,7 +249,8 @@ func (m astModule) eval(ctx *evalCtx) (ast, error) {
 
 	res, err := astList(m.body).eval(&importCtx)
 	if err != nil {
-		return nil, err
+		return nil, dslError{pos: m.pos, err: err,
+			call: "module " + moduleName}
 	}
 
 	// Export the binds.
@@ -229,7 +260,7 @@ func (m astModule) eval(ctx *evalCtx) (ast, error) {
 		}
 	}
 
-	return astModule{moduleName: m.moduleName, body: res.(astList)}, nil
+	return astModule{moduleName: m.moduleName, body: res.(astList), pos: m.pos}, nil
 }
 
 func (l astLabel) eval(ctx *evalCtx) (ast, error) {
@@ -244,6 +275,18 @@ func (c *astContainer) eval(ctx *evalCtx) (ast, error) {
 	return c, nil
 }
 
+func (h *astHost) eval(ctx *evalCtx) (ast, error) {
+	return h, nil
+}
+
+func (s astSecret) eval(ctx *evalCtx) (ast, error) {
+	return s, nil
+}
+
+func (u *astUser) eval(ctx *evalCtx) (ast, error) {
+	return u, nil
+}
+
 func (r astRole) eval(ctx *evalCtx) (ast, error) {
 	return r, nil
 }
@@ -270,5 +313,6 @@ func newEvalCtx(parent *evalCtx) evalCtx {
 		make(map[astIdent]ast),
 		make(map[string]astLabel),
 		make(map[Connection]struct{}),
-		&[]*astMachine{}, &[]*astContainer{}, parent}
+		&[]*astMachine{}, &[]*astContainer{}, &[]*astHost{},
+		&[]*astUser{}, parent}
 }
@@ -0,0 +1,265 @@
+package dsl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/scanner"
+)
+
+// A cstNode is a node of the concrete syntax tree of a spec.  Unlike the ast, it
+// keeps the comments and line breaks of the source, so that the spec can be
+// rewritten without losing them.
+type cstNode struct {
+	text     string    // The token as written, or "" for a list.
+	children []cstNode // The elements of a list.
+	list     bool
+
+	// The number of line breaks between this node and the one before it.
+	newlines int
+}
+
+func (node cstNode) lineComment() bool {
+	return strings.HasPrefix(node.text, "//")
+}
+
+// The forms whose arguments after the first are a body, and so are indented by
+// a fixed amount rather than aligned with the first argument.
+var bodyForms = map[string]struct{}{
+	"define": {},
+	"if":     {},
+	"lambda": {},
+	"let":    {},
+	"module": {},
+	"progn":  {},
+}
+
+// The number of spaces the body of a form is indented by.
+const formatIndent = 2
+
+// Format returns the spec in 'sc' consistently indented.  Comments, and the line
+// breaks between the elements of a list, are kept, although runs of blank lines
+// are collapsed into one.  Formatting a formatted spec doesn't change it.
+func Format(sc scanner.Scanner) (string, error) {
+	if strings.HasSuffix(sc.Filename, infixExt) {
+		return "", fmt.Errorf("%s: only specs in the Lisp syntax can be "+
+			"formatted", sc.Filename)
+	}
+
+	nodes, err := parseCST(sc)
+	if err != nil {
+		return "", err
+	}
+
+	var p printer
+	for i, node := range nodes {
+		switch {
+		case i == 0:
+		case node.newlines > 1:
+			p.newline(true, 0)
+		case node.newlines == 1 || !node.lineComment():
+			// Each top level form gets its own line, but a comment may trail
+			// the form before it.
+			p.newline(false, 0)
+		default:
+			p.write(" ")
+		}
+		p.print(node)
+	}
+
+	if len(nodes) > 0 {
+		p.write("\n")
+	}
+	return p.buf.String(), nil
+}
+
+func parseCST(s scanner.Scanner) ([]cstNode, error) {
+	var scanErrors []string
+	s.Error = func(s *scanner.Scanner, msg string) {
+		scanErrors = append(scanErrors, msg)
+	}
+	s.Mode = scanner.GoTokens &^ scanner.SkipComments
+
+	c := cstParser{scanner: &s}
+	nodes, err := c.parse(0)
+	if s.ErrorCount != 0 {
+		return nil, errors.New(strings.Join(scanErrors, "\n"))
+	} else if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+type cstParser struct {
+	scanner *scanner.Scanner
+
+	// The line the previous token ended on.
+	line int
+}
+
+// newlines returns the number of line breaks before the token just scanned, and
+// records where the token ends.
+func (c *cstParser) newlines() int {
+	start := c.scanner.Position.Line
+	n := start - c.line
+	if c.line == 0 {
+		n = 0
+	}
+
+	c.line = start + strings.Count(c.scanner.TokenText(), "\n")
+	return n
+}
+
+func (c *cstParser) parse(depth int) ([]cstNode, error) {
+	s := c.scanner
+
+	var nodes []cstNode
+	for {
+		tok := s.Scan()
+		newlines := c.newlines()
+
+		switch tok {
+		case '-':
+			// The parser reads "-1" as "- 1", but there's no reason to change
+			// how it was written.
+			text := s.TokenText()
+			if next := s.Peek(); next >= '0' && next <= '9' {
+				s.Scan()
+				text += s.TokenText()
+			}
+			nodes = append(nodes, cstNode{text: text, newlines: newlines})
+		case '<', '>', '!':
+			text := s.TokenText()
+			if s.Peek() == '=' {
+				text += string(s.Next())
+			}
+			nodes = append(nodes, cstNode{text: text, newlines: newlines})
+		case '+', '/', '%', '*', '=',
+			scanner.Float, scanner.Int, scanner.String, scanner.Comment:
+			nodes = append(nodes, cstNode{text: s.TokenText(),
+				newlines: newlines})
+		case scanner.Ident:
+			ident := s.TokenText()
+			for s.Peek() == '.' {
+				s.Next()
+				ident += "."
+				if s.Scan() != scanner.Ident {
+					return nil, dslError{pos: s.Pos(),
+						err: fmt.Errorf("bad ident name: %s", ident)}
+				}
+				ident += s.TokenText()
+			}
+			nodes = append(nodes, cstNode{text: ident, newlines: newlines})
+		case '(':
+			children, err := c.parse(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			nodes = append(nodes, cstNode{children: children, list: true,
+				newlines: newlines})
+		case ')':
+			if depth == 0 {
+				return nil, dslError{pos: s.Pos(), err: errUnbalancedParens}
+			}
+			return nodes, nil
+		case scanner.EOF:
+			if depth != 0 {
+				return nil, dslError{pos: s.Pos(), err: errUnbalancedParens}
+			}
+			return nodes, nil
+		default:
+			return nil, dslError{pos: s.Pos(),
+				err: fmt.Errorf("bad element: %s", s.TokenText())}
+		}
+	}
+}
+
+type printer struct {
+	buf bytes.Buffer
+	col int
+}
+
+func (p *printer) write(str string) {
+	p.buf.WriteString(str)
+	if i := strings.LastIndex(str, "\n"); i >= 0 {
+		p.col = len(str) - i - 1
+	} else {
+		p.col += len(str)
+	}
+}
+
+func (p *printer) newline(blank bool, indent int) {
+	if blank {
+		p.write("\n")
+	}
+	p.write("\n" + strings.Repeat(" ", indent))
+}
+
+func (p *printer) print(node cstNode) {
+	if !node.list {
+		p.write(node.text)
+		return
+	}
+
+	open := p.col
+	p.write("(")
+
+	// Elements on their own line are aligned with the first element, unless the
+	// list is a call, in which case they're aligned with the first argument or,
+	// if that's on its own line, indented past the function.
+	indent := open + 1
+	head := ""
+	if len(node.children) > 0 && !node.children[0].list {
+		head = node.children[0].text
+	}
+
+	call := isIdent(head)
+	if call {
+		indent = open + formatIndent
+	}
+
+	for i, child := range node.children {
+		prev := cstNode{}
+		if i > 0 {
+			prev = node.children[i-1]
+		}
+
+		switch {
+		case i == 0:
+		case child.newlines > 1:
+			p.newline(true, indent)
+		case child.newlines == 1 || prev.lineComment():
+			p.newline(false, indent)
+		default:
+			if _, ok := bodyForms[head]; call && i == 1 && !ok {
+				indent = p.col + 1
+			}
+			p.write(" ")
+		}
+		p.print(child)
+	}
+
+	if n := len(node.children); n > 0 && node.children[n-1].lineComment() {
+		p.newline(false, open)
+	}
+	p.write(")")
+}
+
+// isIdent returns whether 'text' is a function or variable name, rather than a
+// literal or comment.
+func isIdent(text string) bool {
+	if text == "" || text == "true" || text == "false" {
+		return false
+	}
+
+	switch text[0] {
+	case '"', '.':
+		return false
+	case '/':
+		// A comment, or the division operator.
+		return text == "/"
+	}
+	return text[0] < '0' || text[0] > '9'
+}
@@ -3,7 +3,13 @@ package dsl
 import (
 	"errors"
 	"fmt"
+	"math"
+	"net"
+	"path"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -14,109 +20,257 @@ import (
 type funcImpl struct {
 	do      func(*evalCtx, []ast) (ast, error)
 	minArgs int
+	maxArgs int  // Or variadic.  Only checked by Lint.
 	lazy    bool // True if arguments shoudl not be evaluated automatically.
+
+	// The literal types accepted by the arguments, where the last type applies to
+	// all remaining arguments, or nil if any literal is.  Zero means the argument
+	// can't be a literal.  Checked by Lint.
+	lits []int
 }
 
+// The maxArgs of builtins that take any number of arguments.
+const variadic = -1
+
 var funcImplMap map[astIdent]funcImpl
 
 // We have to initialize `funcImplMap` in an init function or else the compiler
 // will complain about an initialization loop (funcImplMap -> letImpl ->
 // astSexp.eval -> funcImplMap).
 func init() {
-	mod := arithFun(func(a, b int) int { return a % b })
-	mul := arithFun(func(a, b int) int { return a * b })
-	sub := arithFun(func(a, b int) int { return a - b })
-	div := arithFun(func(a, b int) int { return a / b })
-
-	less := compareFun(func(a, b int) bool { return a < b })
-	more := compareFun(func(a, b int) bool { return a > b })
+	mod := arithFun("%", modInts, modFloats)
+	mul := arithFun("*", mulInts, func(a, b float64) (float64, error) {
+		return a * b, nil
+	})
+	sub := arithFun("-", subInts, func(a, b float64) (float64, error) {
+		return a - b, nil
+	})
+	div := arithFun("/", divInts, divFloats)
+
+	less := compareFun(func(cmp int) bool { return cmp < 0 })
+	lessEq := compareFun(func(cmp int) bool { return cmp <= 0 })
+	more := compareFun(func(cmp int) bool { return cmp > 0 })
+	moreEq := compareFun(func(cmp int) bool { return cmp >= 0 })
+
+	cpuShares := setLimitImpl("setCPUShares", setCPUShares)
+	memoryLimit := setLimitImpl("setMemoryLimit", setMemory)
+
+	// Literal types shared by several builtins.
+	integer := []int{litInt}
+	number := []int{litNumber}
+	str := []int{litString}
+	strNumber := []int{litString | litNumber}
+	strInt := []int{litString, litInt}
+	strAny := []int{litString, litAny}
+	target := []int{litInt, litString}
+	check := []int{litString, litString, litString | litInt}
 
 	funcImplMap = map[astIdent]funcImpl{
-		"!":                {notImpl, 1, false},
-		"%":                {mod, 2, false},
-		"*":                {mul, 2, false},
-		"+":                {plusImpl, 2, false},
-		"-":                {sub, 2, false},
-		"/":                {div, 2, false},
-		"<":                {less, 2, false},
-		"=":                {eqImpl, 2, false},
-		">":                {more, 2, false},
-		"and":              {andImpl, 1, true},
-		"apply":            {applyImpl, 2, false},
-		"bool":             {boolImpl, 1, false},
-		"car":              {carImpl, 1, false},
-		"cdr":              {cdrImpl, 1, false},
-		"connect":          {connectImpl, 3, false},
-		"cons":             {consImpl, 2, false},
-		"cpu":              {rangeTypeImpl("cpu"), 1, false},
-		"define":           {defineImpl, 2, true},
-		"diskSize":         {diskSizeImpl, 1, false},
-		"docker":           {dockerImpl, 1, false},
-		"githubKey":        {githubKeyImpl, 1, false},
-		"hmap":             {hmapImpl, 0, true},
-		"hmapGet":          {hmapGetImpl, 2, false},
-		"hmapContains":     {hmapContainsImpl, 2, false},
-		"hmapSet":          {hmapSetImpl, 3, false},
-		"hmapKeys":         {hmapKeysImpl, 1, false},
-		"hmapValues":       {hmapValuesImpl, 1, false},
-		"if":               {ifImpl, 2, true},
-		"import":           {importImpl, 1, true},
-		"label":            {labelImpl, 2, false},
-		"labelName":        {labelNameImpl, 1, false},
-		"labelHost":        {labelHostImpl, 1, false},
-		"lambda":           {lambdaImpl, 2, true},
-		"let":              {letImpl, 1, true},
-		"len":              {lenImpl, 1, false},
-		"log":              {logImpl, 2, false},
-		"list":             {listImpl, 0, false},
-		"machine":          {machineImpl, 0, false},
-		"machineAttribute": {machineAttributeImpl, 2, false},
-		"makeList":         {makeListImpl, 2, true},
-		"map":              {mapImpl, 2, false},
-		"module":           {moduleImpl, 2, true},
-		"nth":              {nthImpl, 2, false},
-		"or":               {orImpl, 1, true},
-		"placement":        {placementImpl, 2, false},
-		"plaintextKey":     {plaintextKeyImpl, 1, false},
-		"panic":            {panicImpl, 1, false},
-		"progn":            {prognImpl, 1, false},
-		"provider":         {providerImpl, 1, false},
-		"reduce":           {reduceImpl, 2, false},
-		"region":           {regionImpl, 1, false},
-		"ram":              {rangeTypeImpl("ram"), 1, false},
-		"range":            {rangeImpl, 1, false},
-		"role":             {roleImpl, 1, false},
-		"setEnv":           {setEnvImpl, 3, false},
-		"size":             {sizeImpl, 1, false},
-		"sprintf":          {sprintfImpl, 1, false},
-	}
-}
-
-// XXX: support float operators?
-func arithFun(do func(a, b int) int) func(*evalCtx, []ast) (ast, error) {
+		"!":                {notImpl, 1, 1, false, nil},
+		"!=":               {notEqImpl, 2, 2, false, []int{litAny}},
+		"%":                {mod, 2, variadic, false, number},
+		"*":                {mul, 2, variadic, false, number},
+		"+":                {plusImpl, 2, variadic, false, strNumber},
+		"-":                {sub, 2, variadic, false, number},
+		"/":                {div, 2, variadic, false, number},
+		"<":                {less, 2, 2, false, number},
+		"<=":               {lessEq, 2, 2, false, number},
+		"=":                {eqImpl, 2, 2, false, nil},
+		">":                {more, 2, 2, false, number},
+		">=":               {moreEq, 2, 2, false, number},
+		"and":              {andImpl, 1, variadic, true, nil},
+		"apply":            {applyImpl, 2, 2, false, nil},
+		"bool":             {boolImpl, 1, 1, false, nil},
+		"car":              {carImpl, 1, 1, false, []int{0}},
+		"cdr":              {cdrImpl, 1, 1, false, []int{0}},
+		"connect":          {connectImpl(false), 3, 3, false, target},
+		"cons":             {consImpl, 2, 2, false, nil},
+		"cpu":              {rangeTypeImpl("cpu"), 1, 2, false, number},
+		"define":           {defineImpl, 2, variadic, true, nil},
+		"deny":             {connectImpl(true), 3, 3, false, target},
+		"diskSize":         {diskSizeImpl, 1, 1, false, integer},
+		"docker":           {dockerImpl, 1, variadic, false, str},
+		"float":            {floatImpl, 1, 1, false, strNumber},
+		"healthCheck":      {healthCheckImpl, 3, variadic, false, check},
+		"hmap":             {hmapImpl, 0, variadic, true, nil},
+		"host":             {hostImpl, 1, 1, false, str},
+		"hmapGet":          {hmapGetImpl, 2, 2, false, nil},
+		"hmapContains":     {hmapContainsImpl, 2, 2, false, nil},
+		"hmapSet":          {hmapSetImpl, 3, 3, false, nil},
+		"hmapKeys":         {hmapKeysImpl, 1, 1, false, []int{0}},
+		"hmapValues":       {hmapValuesImpl, 1, 1, false, []int{0}},
+		"icmp":             {icmpImpl, 0, 0, false, nil},
+		"if":               {ifImpl, 2, 3, true, nil},
+		"import":           {importImpl, 1, 1, true, str},
+		"int":              {intImpl, 1, 1, false, strNumber},
+		"label":            {labelImpl, 2, variadic, false, str},
+		"labelName":        {labelNameImpl, 1, 1, false, nil},
+		"labelHost":        {labelHostImpl, 1, 1, false, nil},
+		"lambda":           {lambdaImpl, 2, variadic, true, nil},
+		"let":              {letImpl, 1, variadic, true, nil},
+		"len":              {lenImpl, 1, 1, false, []int{0}},
+		"log":              {logImpl, 2, 2, false, str},
+		"list":             {listImpl, 0, variadic, false, nil},
+		"machine":          {machineImpl, 0, variadic, false, nil},
+		"machineAttribute": {machineAttributeImpl, 2, variadic, false, nil},
+		"makeList":         {makeListImpl, 2, 2, true, []int{litInt, litAny}},
+		"map":              {mapImpl, 2, variadic, false, nil},
+		"module":           {moduleImpl, 2, variadic, true, nil},
+		"mount":            {mountImpl, 2, variadic, false, []int{litString, 0}},
+		"nth":              {nthImpl, 2, 2, false, []int{litInt, 0}},
+		"or":               {orImpl, 1, variadic, true, nil},
+		"placement":        {placementImpl, 2, variadic, false, nil},
+		"panic":            {panicImpl, 1, 1, false, str},
+		"progn":            {prognImpl, 1, variadic, false, nil},
+		"provider":         {providerImpl, 1, 1, false, str},
+		"reduce":           {reduceImpl, 2, 2, false, nil},
+		"region":           {regionImpl, 1, 1, false, str},
+		"ram":              {rangeTypeImpl("ram"), 1, 2, false, number},
+		"range":            {rangeImpl, 1, 3, false, integer},
+		"role":             {roleImpl, 1, 1, false, str},
+		"rollingUpdate":    {rollingUpdateImpl, 3, 3, false, strInt},
+		"setCPUShares":     {cpuShares, 2, 2, false, strInt},
+		"secret":           {secretImpl, 1, 1, false, str},
+		"setEnv":           {setEnvImpl, 3, 3, false, str},
+		"setFile":          {setFileImpl, 3, 4, false, str},
+		"setMemoryLimit":   {memoryLimit, 2, 2, false, strInt},
+		"size":             {sizeImpl, 1, 1, false, str},
+		"sprintf":          {sprintfImpl, 1, variadic, false, strAny},
+		"tcp":              {protocolImpl("tcp"), 1, 1, false, integer},
+		"udp":              {protocolImpl("udp"), 1, 1, false, integer},
+		"user":             {userImpl, 2, variadic, false, str},
+		"volume":           {volumeImpl, 2, 2, false, str},
+	}
+}
+
+var errDivideByZero = errors.New("division by zero")
+var errOverflow = errors.New("overflow")
+
+const maxInt = int(^uint(0) >> 1)
+const minInt = -maxInt - 1
+
+// arithFun returns the implementation of the arithmetic operator 'op', which
+// folds 'ints' over its arguments if they're all ints, and otherwise folds
+// 'floats' over them converted to floats.
+func arithFun(op string, ints func(a, b int) (int, error),
+	floats func(a, b float64) (float64, error)) func(*evalCtx, []ast) (ast, error) {
+
 	return func(ctx *evalCtx, args []ast) (ast, error) {
-		var ints []int
+		isFloat := false
 		for _, arg := range args {
-			ival, ok := arg.(astInt)
-			if !ok {
-				err := fmt.Errorf("bad arithmetic argument: %s", arg)
-				return nil, err
+			switch arg.(type) {
+			case astInt:
+			case astFloat:
+				isFloat = true
+			default:
+				return nil, fmt.Errorf("bad arithmetic argument: %s", arg)
 			}
-			ints = append(ints, int(ival))
 		}
 
-		total := ints[0]
-		for _, x := range ints[1:] {
-			total = do(total, x)
+		var result ast
+		var err error
+		if isFloat {
+			result, err = foldFloats(args, floats)
+		} else {
+			result, err = foldInts(args, ints)
+		}
+
+		if err != nil {
+			expr := astSexp{sexp: append([]ast{astIdent(op)}, args...)}
+			return nil, fmt.Errorf("%s: %s", err, expr)
 		}
+		return result, nil
+	}
+}
 
-		return astInt(total), nil
+func foldInts(args []ast, do func(a, b int) (int, error)) (ast, error) {
+	total := int(args[0].(astInt))
+	for _, arg := range args[1:] {
+		var err error
+		if total, err = do(total, int(arg.(astInt))); err != nil {
+			return nil, err
+		}
 	}
+	return astInt(total), nil
+}
+
+func foldFloats(args []ast, do func(a, b float64) (float64, error)) (ast, error) {
+	total, _ := toFloat(args[0])
+	for _, arg := range args[1:] {
+		x, _ := toFloat(arg)
+		result, err := do(float64(total), float64(x))
+		if err != nil {
+			return nil, err
+		}
+
+		if math.IsInf(result, 0) || math.IsNaN(result) {
+			return nil, errOverflow
+		}
+		total = astFloat(result)
+	}
+	return total, nil
+}
+
+func addInts(a, b int) (int, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, errOverflow
+	}
+	return sum, nil
+}
+
+func subInts(a, b int) (int, error) {
+	diff := a - b
+	if (b > 0 && diff > a) || (b < 0 && diff < a) {
+		return 0, errOverflow
+	}
+	return diff, nil
+}
+
+func mulInts(a, b int) (int, error) {
+	product := a * b
+	if a != 0 && (product/a != b || (a == -1 && b == minInt)) {
+		return 0, errOverflow
+	}
+	return product, nil
+}
+
+func divInts(a, b int) (int, error) {
+	switch {
+	case b == 0:
+		return 0, errDivideByZero
+	case a == minInt && b == -1:
+		return 0, errOverflow
+	}
+	return a / b, nil
+}
+
+func modInts(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errDivideByZero
+	}
+	return a % b, nil
+}
+
+func divFloats(a, b float64) (float64, error) {
+	if b == 0 {
+		return 0, errDivideByZero
+	}
+	return a / b, nil
+}
+
+func modFloats(a, b float64) (float64, error) {
+	if b == 0 {
+		return 0, errDivideByZero
+	}
+	return math.Mod(a, b), nil
 }
 
 func plusImpl(ctx *evalCtx, args []ast) (ast, error) {
 	if _, ok := args[0].(astString); !ok {
-		return arithFun(func(a, b int) int { return a + b })(ctx, args)
+		return arithFun("+", addInts, func(a, b float64) (float64, error) {
+			return a + b, nil
+		})(ctx, args)
 	}
 
 	var strSlice []string
@@ -132,24 +286,71 @@ func plusImpl(ctx *evalCtx, args []ast) (ast, error) {
 	return astString(strings.Join(strSlice, "")), nil
 }
 
+// Numbers are equal if they have the same value, whether they're ints or floats.
 func eqImpl(ctx *evalCtx, args []ast) (ast, error) {
+	if cmp, err := compareNumbers(args[0], args[1]); err == nil {
+		return astBool(cmp == 0), nil
+	}
 	return astBool(reflect.DeepEqual(args[0], args[1])), nil
 }
 
-func compareFun(do func(a, b int) bool) func(*evalCtx, []ast) (ast, error) {
+func notEqImpl(ctx *evalCtx, args []ast) (ast, error) {
+	eq, err := eqImpl(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return !eq.(astBool), nil
+}
+
+// compareFun returns the implementation of a comparison, which applies 'do' to
+// the result of comparing its two arguments.
+func compareFun(do func(cmp int) bool) func(*evalCtx, []ast) (ast, error) {
 	return func(ctx *evalCtx, args []ast) (ast, error) {
-		var ints []int
-		for _, arg := range args {
-			ival, ok := arg.(astInt)
-			if !ok {
-				err := fmt.Errorf("bad arithmetic argument: %s", arg)
-				return nil, err
-			}
-			ints = append(ints, int(ival))
+		if len(args) != 2 {
+			return nil, fmt.Errorf("comparisons take exactly 2 arguments: %s",
+				astList(args))
+		}
+
+		cmp, err := compareNumbers(args[0], args[1])
+		if err != nil {
+			return nil, err
 		}
+		return astBool(do(cmp)), nil
+	}
+}
+
+// compareNumbers returns -1, 0 or 1 if 'a' is less than, equal to, or greater
+// than 'b'.  Ints are compared exactly, and as floats if either is a float.
+func compareNumbers(a, b ast) (int, error) {
+	for _, arg := range []ast{a, b} {
+		switch arg.(type) {
+		case astInt, astFloat:
+		default:
+			return 0, fmt.Errorf("bad arithmetic argument: %s", arg)
+		}
+	}
 
-		return astBool(do(ints[0], ints[1])), nil
+	aInt, aIsInt := a.(astInt)
+	bInt, bIsInt := b.(astInt)
+	if aIsInt && bIsInt {
+		switch {
+		case aInt < bInt:
+			return -1, nil
+		case aInt > bInt:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	aFloat, _ := toFloat(a)
+	bFloat, _ := toFloat(b)
+	switch {
+	case aFloat < bFloat:
+		return -1, nil
+	case aFloat > bFloat:
+		return 1, nil
 	}
+	return 0, nil
 }
 
 func dockerImpl(ctx *evalCtx, evalArgs []ast) (ast, error) {
@@ -166,7 +367,7 @@ func dockerImpl(ctx *evalCtx, evalArgs []ast) (ast, error) {
 	newContainer := &astContainer{
 		image:     astArgs[0].(astString),
 		command:   astList(astArgs[1:]),
-		Placement: Placement{make(map[[2]string]struct{})},
+		Placement: Placement{Exclusive: make(map[[2]string]struct{})},
 		env:       astHmap(make(map[ast]ast)),
 	}
 
@@ -176,6 +377,33 @@ func dockerImpl(ctx *evalCtx, evalArgs []ast) (ast, error) {
 	return newContainer, nil
 }
 
+var hostnameRegex = regexp.MustCompile(
+	`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+func hostImpl(ctx *evalCtx, args []ast) (ast, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("host requires exactly 1 argument: %s",
+			astList(args))
+	}
+
+	hostname, ok := args[0].(astString)
+	if !ok {
+		return nil, fmt.Errorf("host must be a string: %s", args[0])
+	}
+
+	str := string(hostname)
+	_, _, cidrErr := net.ParseCIDR(str)
+	if cidrErr != nil && net.ParseIP(str) == nil && !hostnameRegex.MatchString(str) {
+		return nil, fmt.Errorf("host must be a hostname, IP, or CIDR: %s", str)
+	}
+
+	host := &astHost{hostname: hostname}
+	globalCtx := ctx.globalCtx()
+	*globalCtx.hosts = append(*globalCtx.hosts, host)
+
+	return host, nil
+}
+
 func setEnvHelper(container ast, key, value ast) error {
 	c, ok := container.(*astContainer)
 	if !ok {
@@ -187,53 +415,361 @@ func setEnvHelper(container ast, key, value ast) error {
 		return fmt.Errorf("setEnv key must be a string: %s", key)
 	}
 
-	_, ok = value.(astString)
-	if !ok {
-		return fmt.Errorf("setEnv value must be a string: %s", value)
+	switch value.(type) {
+	case astString, astSecret:
+	default:
+		return fmt.Errorf("setEnv value must be a string or secret: %s", value)
 	}
 
 	c.env[key] = value
 	return nil
 }
 
+// Secret names double as file names in the controller's secrets directory.
+var secretNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`)
+
+func secretImpl(ctx *evalCtx, args []ast) (ast, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("secret requires exactly 1 argument: %s",
+			astList(args))
+	}
+
+	name, ok := args[0].(astString)
+	if !ok || !secretNameRegex.MatchString(string(name)) {
+		return nil, fmt.Errorf("invalid secret name: %s", args[0])
+	}
+
+	return astSecret{name}, nil
+}
+
 func setEnvImpl(ctx *evalCtx, args []ast) (ast, error) {
-	for _, arg := range flatten([]ast{args[0]}) {
+	err := forEachContainer(ctx, "setEnv", args[0], func(c ast) error {
+		return setEnvHelper(c, args[1], args[2])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return astList{}, nil
+}
+
+// forEachContainer calls 'do' on each container in 'target', which may be a
+// container, a label, or a list of them.  'fn' names the calling builtin in errors.
+func forEachContainer(ctx *evalCtx, fn string, target ast,
+	do func(ast) error) error {
+	for _, arg := range flatten([]ast{target}) {
 		switch val := arg.(type) {
 		case astString, astLabel:
 			label, ok := ctx.resolveLabel(val)
 			if !ok {
-				return nil, fmt.Errorf("cannot setEnv on invalid label: %s", val)
+				return fmt.Errorf("cannot %s on invalid label: %s", fn, val)
 			}
 			for _, c := range label.elems {
-				if err := setEnvHelper(c, args[1], args[2]); err != nil {
-					return nil, err
+				if err := do(c); err != nil {
+					return err
 				}
 			}
 		case *astContainer:
-			if err := setEnvHelper(val, args[1], args[2]); err != nil {
-				return nil, err
+			if err := do(val); err != nil {
+				return err
 			}
 		default:
-			return nil, fmt.Errorf("setEnv target must be either a label or container: %s", val)
+			return fmt.Errorf("%s target must be either a label or container: %s",
+				fn, val)
+		}
+	}
+	return nil
+}
+
+// Docker's rule for the names of volumes.
+var volumeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func volumeImpl(ctx *evalCtx, args []ast) (ast, error) {
+	source, ok := args[0].(astString)
+	if !ok || (!strings.HasPrefix(string(source), "/") &&
+		!volumeNameRegex.MatchString(string(source))) {
+		return nil, fmt.Errorf("volume source must be an absolute path or a "+
+			"volume name: %s", args[0])
+	}
+
+	path, ok := args[1].(astString)
+	if !ok || !strings.HasPrefix(string(path), "/") {
+		return nil, fmt.Errorf("volume path must be absolute: %s", args[1])
+	}
+
+	return astVolume{source: source, path: path}, nil
+}
+
+func mountImpl(ctx *evalCtx, args []ast) (ast, error) {
+	var volumes []astVolume
+	for _, arg := range flatten(args[1:]) {
+		v, ok := arg.(astVolume)
+		if !ok {
+			return nil, fmt.Errorf("mount requires volumes: %s", arg)
+		}
+		volumes = append(volumes, v)
+	}
+
+	err := forEachContainer(ctx, "mount", args[0], func(c ast) error {
+		container, ok := c.(*astContainer)
+		if !ok {
+			return fmt.Errorf("cannot mount on non-container: %s", c)
 		}
+		return mountVolumes(container, volumes)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return astList{}, nil
 }
 
-func githubKeyImpl(ctx *evalCtx, args []ast) (ast, error) {
-	username, ok := args[0].(astString)
+// mountVolumes adds 'volumes' to 'c'.  Mounting the same volume twice is a no-op,
+// but mounting two different volumes at the same path is an error.
+func mountVolumes(c *astContainer, volumes []astVolume) error {
+	for _, v := range volumes {
+		mounted := false
+		for _, existing := range c.volumes {
+			if existing.path != v.path {
+				continue
+			}
+
+			if existing != v {
+				return fmt.Errorf("conflicting volumes mounted at %s: %s %s",
+					v.path, existing, v)
+			}
+			mounted = true
+		}
+
+		if !mounted {
+			c.volumes = append(c.volumes, v)
+		}
+	}
+	return nil
+}
+
+func setFileImpl(ctx *evalCtx, args []ast) (ast, error) {
+	filePath, ok := args[1].(astString)
+	if !ok || !strings.HasPrefix(string(filePath), "/") ||
+		path.Clean(string(filePath)) == "/" {
+		return nil, fmt.Errorf("setFile path must be an absolute file path: %s",
+			args[1])
+	}
+
+	// Strings can't span lines, so multi-line content is given as a list of lines.
+	var content string
+	switch val := args[2].(type) {
+	case astString:
+		content = string(val)
+	case astList:
+		lines, err := flattenString([]ast{val})
+		if err != nil {
+			return nil, fmt.Errorf("setFile content must be a string or a "+
+				"list of lines: %s", args[2])
+		}
+		content = strings.Join(lines, "\n") + "\n"
+	default:
+		return nil, fmt.Errorf("setFile content must be a string or a list of "+
+			"lines: %s", args[2])
+	}
+
+	file := File{
+		Path:    path.Clean(string(filePath)),
+		Content: content,
+		Mode:    0644,
+	}
+
+	if len(args) == 4 {
+		mode, ok := args[3].(astString)
+		if !ok {
+			return nil, fmt.Errorf("setFile mode must be an octal string: %s",
+				args[3])
+		}
+
+		perm, err := strconv.ParseUint(string(mode), 8, 32)
+		if err != nil || perm > 0777 {
+			return nil, fmt.Errorf("setFile mode must be an octal string: %s",
+				args[3])
+		}
+		file.Mode = int(perm)
+	} else if len(args) > 4 {
+		return nil, fmt.Errorf("setFile takes only a target, path, content, " +
+			"and mode")
+	}
+
+	err := forEachContainer(ctx, "setFile", args[0], func(c ast) error {
+		container, ok := c.(*astContainer)
+		if !ok {
+			return fmt.Errorf("cannot setFile on non-container: %s", c)
+		}
+
+		if container.files == nil {
+			container.files = map[string]File{}
+		}
+		container.files[file.Path] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return astList{}, nil
+}
+
+func healthCheckImpl(ctx *evalCtx, args []ast) (ast, error) {
+	typ, ok := args[1].(astString)
 	if !ok {
-		return nil, fmt.Errorf("github username must be a string: %s", args[0])
+		return nil, fmt.Errorf("healthCheck type must be a string: %s", args[1])
+	}
+
+	check := HealthCheck{Type: string(typ)}
+	switch check.Type {
+	case "tcp", "http":
+		port, ok := args[2].(astInt)
+		if !ok || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("healthCheck port must be an integer "+
+				"between 1 and 65535: %s", args[2])
+		}
+		check.Port = int(port)
+
+		if check.Type == "tcp" {
+			if len(args) > 3 {
+				return nil, fmt.Errorf("tcp healthCheck takes only a port")
+			}
+			break
+		}
+
+		check.Path = "/"
+		if len(args) == 4 {
+			path, ok := args[3].(astString)
+			if !ok || !strings.HasPrefix(string(path), "/") {
+				return nil, fmt.Errorf("healthCheck path must be "+
+					"absolute: %s", args[3])
+			}
+			check.Path = string(path)
+		} else if len(args) > 4 {
+			return nil, fmt.Errorf("http healthCheck takes only a port and " +
+				"a path")
+		}
+	case "exec":
+		cmd, err := flattenString(args[2:])
+		if err != nil {
+			return nil, err
+		}
+		check.Command = cmd
+	default:
+		return nil, fmt.Errorf("unknown healthCheck type: %s", typ)
+	}
+
+	err := forEachContainer(ctx, "healthCheck", args[0], func(c ast) error {
+		container, ok := c.(*astContainer)
+		if !ok {
+			return fmt.Errorf("cannot healthCheck on non-container: %s", c)
+		}
+		container.healthCheck = check
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return astList{}, nil
+}
+
+func rollingUpdateImpl(ctx *evalCtx, args []ast) (ast, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("rollingUpdate requires exactly 3 arguments: %s",
+			astList(args))
+	}
+
+	unavailable, ok := args[1].(astInt)
+	if !ok || unavailable < 0 {
+		return nil, fmt.Errorf("rollingUpdate maxUnavailable must be a "+
+			"non-negative integer: %s", args[1])
+	}
+
+	surge, ok := args[2].(astInt)
+	if !ok || surge < 0 {
+		return nil, fmt.Errorf("rollingUpdate maxSurge must be a non-negative "+
+			"integer: %s", args[2])
+	}
+
+	// Otherwise no container could ever be replaced.
+	if unavailable == 0 && surge == 0 {
+		return nil, fmt.Errorf("rollingUpdate requires a positive " +
+			"maxUnavailable or maxSurge")
+	}
+
+	update := RollingUpdate{MaxUnavailable: int(unavailable), MaxSurge: int(surge)}
+	err := forEachContainer(ctx, "rollingUpdate", args[0], func(c ast) error {
+		container, ok := c.(*astContainer)
+		if !ok {
+			return fmt.Errorf("cannot rollingUpdate on non-container: %s", c)
+		}
+		container.rollingUpdate = update
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return astList{}, nil
+}
+
+func setMemory(r *Resources, megabytes int) {
+	r.Memory = megabytes
+}
+
+func setCPUShares(r *Resources, shares int) {
+	r.CPUShares = shares
+}
+
+func setLimitImpl(fn string,
+	set func(*Resources, int)) func(*evalCtx, []ast) (ast, error) {
+	return func(ctx *evalCtx, args []ast) (ast, error) {
+		limit, ok := args[1].(astInt)
+		if !ok || limit < 0 {
+			return nil, fmt.Errorf("%s limit must be a non-negative integer: %s",
+				fn, args[1])
+		}
+
+		err := forEachContainer(ctx, fn, args[0], func(c ast) error {
+			container, ok := c.(*astContainer)
+			if !ok {
+				return fmt.Errorf("cannot %s on non-container: %s", fn, c)
+			}
+			set(&container.Resources, int(limit))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return astList{}, nil
 	}
-	return astGithubKey(username), nil
 }
 
-func plaintextKeyImpl(ctx *evalCtx, args []ast) (ast, error) {
-	key, ok := args[0].(astString)
+func userImpl(ctx *evalCtx, args []ast) (ast, error) {
+	keyType, ok := args[0].(astString)
+	if !ok || (keyType != "github" && keyType != "plaintext") {
+		return nil, fmt.Errorf("user key type must be \"github\" or "+
+			"\"plaintext\": %s", args[0])
+	}
+
+	key, ok := args[1].(astString)
 	if !ok {
-		return nil, fmt.Errorf("key must be a string: %s", args[0])
+		return nil, fmt.Errorf("user key must be a string: %s", args[1])
 	}
-	return astPlaintextKey(key), nil
+
+	user := &astUser{keyType: keyType, key: key}
+	for _, arg := range flatten(args[2:]) {
+		acl, ok := arg.(astString)
+		_, _, err := net.ParseCIDR(string(acl))
+		if !ok || (acl != "local" && err != nil) {
+			return nil, fmt.Errorf("user ACL must be a CIDR or \"local\": %s",
+				arg)
+		}
+		user.acls = append(user.acls, acl)
+	}
+
+	globalCtx := ctx.globalCtx()
+	*globalCtx.users = append(*globalCtx.users, user)
+
+	return user, nil
 }
 
 func placementImpl(ctx *evalCtx, args []ast) (ast, error) {
@@ -243,13 +779,32 @@ func placementImpl(ctx *evalCtx, args []ast) (ast, error) {
 	}
 	ptype := string(str)
 
-	labels, err := ctx.flattenLabel(args[1:])
+	var err error
+	switch ptype {
+	case "exclusive":
+		err = placeExclusive(ctx, args[1:])
+	case "colocate":
+		err = placeColocate(ctx, args[1:])
+	case "role", "region", "provider", "machineLabel":
+		err = placeMachine(ctx, ptype, args[1:])
+	default:
+		return nil, fmt.Errorf("not a valid placement type: %s", ptype)
+	}
+
 	if err != nil {
 		return nil, err
 	}
+	return astFunc(astIdent("placement"), args), nil
+}
+
+func placeExclusive(ctx *evalCtx, args []ast) error {
+	labels, err := ctx.flattenLabel(args)
+	if err != nil {
+		return err
+	}
 
 	if len(labels) < 2 {
-		return nil, fmt.Errorf("placment requires at least 2 labels.")
+		return fmt.Errorf("exclusive placement requires at least 2 labels")
 	}
 
 	parsedLabels := make(map[[2]string]struct{})
@@ -265,24 +820,207 @@ func placementImpl(ctx *evalCtx, args []ast) (ast, error) {
 		}
 	}
 
-	switch ptype {
-	case "exclusive":
-		for _, label := range labels {
-			for _, c := range label.elems {
-				c, ok := c.(*astContainer)
-				if !ok {
-					return nil, fmt.Errorf("placement labels must contain containers: %s", label)
+	for _, label := range labels {
+		containers, err := placementContainers(label)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range containers {
+			for k, v := range parsedLabels {
+				_, colocated := c.Colocate[k]
+				_, colocatedRev := c.Colocate[[2]string{k[1], k[0]}]
+				if colocated || colocatedRev {
+					return fmt.Errorf("%s and %s cannot be both "+
+						"colocated and exclusive", k[0], k[1])
 				}
-				for k, v := range parsedLabels {
-					c.Placement.Exclusive[k] = v
+				c.Placement.Exclusive[k] = v
+			}
+		}
+	}
+	return nil
+}
+
+func placeColocate(ctx *evalCtx, args []ast) error {
+	labels, err := ctx.flattenLabel(args)
+	if err != nil {
+		return err
+	}
+
+	if len(labels) < 2 {
+		return fmt.Errorf("colocate placement requires at least 2 labels")
+	}
+
+	anchor := labels[0]
+	anchorContainers, err := placementContainers(anchor)
+	if err != nil {
+		return err
+	}
+
+	for _, follower := range labels[1:] {
+		pair := [2]string{string(anchor.ident), string(follower.ident)}
+		if pair[0] == pair[1] {
+			return fmt.Errorf("cannot colocate %s with itself", pair[0])
+		}
+
+		followerContainers, err := placementContainers(follower)
+		if err != nil {
+			return err
+		}
+
+		exclusive := pair
+		if exclusive[0] > exclusive[1] {
+			exclusive = [2]string{pair[1], pair[0]}
+		}
+
+		for _, c := range followerContainers {
+			if _, ok := c.Exclusive[exclusive]; ok {
+				return fmt.Errorf("%s and %s cannot be both "+
+					"colocated and exclusive", pair[0], pair[1])
+			}
+
+			for _, ac := range anchorContainers {
+				if err := machineConflict(c.Machine, ac.Machine); err != nil {
+					return fmt.Errorf("cannot colocate %s and %s: %s",
+						pair[0], pair[1], err)
 				}
 			}
 		}
+
+		for _, c := range append(anchorContainers, followerContainers...) {
+			if c.Colocate == nil {
+				c.Colocate = make(map[[2]string]struct{})
+			}
+			c.Colocate[pair] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func placeMachine(ctx *evalCtx, ptype string, args []ast) error {
+	value, ok := args[0].(astString)
+	if !ok {
+		return fmt.Errorf("%s placement must be a string, found: %s",
+			ptype, args[0])
+	}
+
+	// Containers are only ever scheduled on workers, so pinning them to a master
+	// would leave them unscheduled.
+	if ptype == "role" && value != "Worker" {
+		return fmt.Errorf("role placement must be Worker, found: %s",
+			string(value))
+	}
+
+	labels, err := ctx.flattenLabel(args[1:])
+	if err != nil {
+		return err
+	}
+
+	if len(labels) < 1 {
+		return fmt.Errorf("%s placement requires at least 1 label", ptype)
+	}
+
+	for _, label := range labels {
+		containers, err := placementContainers(label)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range containers {
+			if ptype == "machineLabel" {
+				c.Machine.Labels = addSorted(c.Machine.Labels, string(value))
+				continue
+			}
+
+			pin := MachinePlacement{}
+			*pin.attr(ptype) = string(value)
+			if err := machineConflict(c.Machine, pin); err != nil {
+				return err
+			}
+
+			// The containers this one is colocated with must be pinned alike.
+			for _, other := range colocatedWith(ctx, c) {
+				if err := machineConflict(other.Machine, pin); err != nil {
+					return fmt.Errorf("%s is colocated with a container "+
+						"that has a %s", string(label.ident), err)
+				}
+			}
+
+			*c.Machine.attr(ptype) = string(value)
+		}
+	}
+	return nil
+}
+
+// placementContainers returns the containers of 'label', all of its members must
+// be containers.
+func placementContainers(label astLabel) ([]*astContainer, error) {
+	var containers []*astContainer
+	for _, elem := range label.elems {
+		c, ok := elem.(*astContainer)
+		if !ok {
+			return nil, fmt.Errorf("placement labels must contain containers: %s",
+				label)
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+// colocatedWith returns the containers that must share a host with 'c'.
+func colocatedWith(ctx *evalCtx, c *astContainer) []*astContainer {
+	labels := map[string]struct{}{}
+	for pair := range c.Colocate {
+		labels[pair[0]] = struct{}{}
+		labels[pair[1]] = struct{}{}
+	}
+
+	var result []*astContainer
+	for _, other := range *ctx.globalCtx().containers {
+		for _, l := range other.Labels() {
+			if _, ok := labels[l]; ok {
+				result = append(result, other)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// machineConflict returns an error if no machine could satisfy both 'a' and 'b'.
+func machineConflict(a, b MachinePlacement) error {
+	for _, ptype := range []string{"role", "region", "provider"} {
+		x, y := *a.attr(ptype), *b.attr(ptype)
+		if x != "" && y != "" && x != y {
+			return fmt.Errorf("conflicting %s placement: %s and %s", ptype, x, y)
+		}
+	}
+	return nil
+}
+
+func (m *MachinePlacement) attr(ptype string) *string {
+	switch ptype {
+	case "role":
+		return &m.Role
+	case "region":
+		return &m.Region
+	case "provider":
+		return &m.Provider
 	default:
-		return nil, fmt.Errorf("not a valid placement type: %s", ptype)
+		panic("Not Reached")
 	}
+}
 
-	return astFunc(astIdent("placement"), args), nil
+func addSorted(slice []string, str string) []string {
+	for _, s := range slice {
+		if s == str {
+			return slice
+		}
+	}
+
+	slice = append(slice, str)
+	sort.Strings(slice)
+	return slice
 }
 
 func setMachineAttributes(machine *astMachine, args []ast) error {
@@ -307,8 +1045,6 @@ func setMachineAttributes(machine *astMachine, args []ast) error {
 			default:
 				return fmt.Errorf("unrecognized argument to machine definition: %s", arg)
 			}
-		case key:
-			machine.sshKeys = append(machine.sshKeys, val)
 		default:
 			return fmt.Errorf("unrecognized argument to machine definition: %s", arg)
 		}
@@ -349,7 +1085,11 @@ func machineAttributeImpl(ctx *evalCtx, args []ast) (ast, error) {
 }
 
 func providerImpl(ctx *evalCtx, args []ast) (ast, error) {
-	return astProvider((args[0].(astString))), nil
+	provider, ok := args[0].(astString)
+	if !ok {
+		return nil, fmt.Errorf("provider must be a string: %s", args[0])
+	}
+	return astProvider(provider), nil
 }
 
 func roleImpl(ctx *evalCtx, args []ast) (ast, error) {
@@ -369,7 +1109,11 @@ func regionImpl(ctx *evalCtx, args []ast) (ast, error) {
 }
 
 func sizeImpl(ctx *evalCtx, args []ast) (ast, error) {
-	return astSize((args[0].(astString))), nil
+	size, ok := args[0].(astString)
+	if !ok {
+		return nil, fmt.Errorf("size must be a string: %s", args[0])
+	}
+	return astSize(size), nil
 }
 
 func diskSizeImpl(ctx *evalCtx, args []ast) (ast, error) {
@@ -380,6 +1124,44 @@ func diskSizeImpl(ctx *evalCtx, args []ast) (ast, error) {
 	return astDiskSize(size), nil
 }
 
+func intImpl(ctx *evalCtx, args []ast) (ast, error) {
+	switch x := args[0].(type) {
+	case astInt:
+		return x, nil
+	case astFloat:
+		// Floats are truncated towards zero.
+		if math.IsNaN(float64(x)) || float64(x) >= -float64(minInt) ||
+			float64(x) < float64(minInt) {
+			return nil, fmt.Errorf("%s: %s", errOverflow,
+				astSexp{sexp: []ast{astIdent("int"), x}})
+		}
+		return astInt(x), nil
+	case astString:
+		i, err := strconv.Atoi(string(x))
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert to an int: %s", x)
+		}
+		return astInt(i), nil
+	}
+	return nil, fmt.Errorf("cannot convert to an int: %s", args[0])
+}
+
+func floatImpl(ctx *evalCtx, args []ast) (ast, error) {
+	if str, ok := args[0].(astString); ok {
+		x, err := strconv.ParseFloat(string(str), 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert to a float: %s", str)
+		}
+		return astFloat(x), nil
+	}
+
+	x, err := toFloat(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert to a float: %s", args[0])
+	}
+	return x, nil
+}
+
 func toFloat(x ast) (astFloat, error) {
 	switch x.(type) {
 	case astInt:
@@ -408,34 +1190,83 @@ func rangeTypeImpl(rangeType string) func(*evalCtx, []ast) (ast, error) {
 	}
 }
 
-func connectImpl(ctx *evalCtx, args []ast) (ast, error) {
+func protocolImpl(protocol string) func(*evalCtx, []ast) (ast, error) {
+	return func(ctx *evalCtx, args []ast) (ast, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s requires exactly 1 argument: %s",
+				protocol, astList(args))
+		}
+
+		min, max, err := parsePortRange(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return astProtocol{protocol: astIdent(protocol), min: min, max: max},
+			nil
+	}
+}
+
+func icmpImpl(ctx *evalCtx, args []ast) (ast, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("icmp takes no arguments: %s", astList(args))
+	}
+	return astProtocol{protocol: "icmp"}, nil
+}
+
+// parsePortRange parses either a single port, or a list of the first and last
+// ports of a range.
+func parsePortRange(arg ast) (int, int, error) {
 	var min, max int
-	switch t := args[0].(type) {
+	switch t := arg.(type) {
 	case astInt:
 		min, max = int(t), int(t)
 	case astList:
 		if len(t) != 2 {
-			return nil, fmt.Errorf("port range must have two ints: %s", t)
+			return 0, 0, fmt.Errorf("port range must have two ints: %s", t)
 		}
 
 		minAst, minOK := t[0].(astInt)
 		maxAst, maxOK := t[1].(astInt)
 		if !minOK || !maxOK {
-			return nil, fmt.Errorf("port range must have two ints: %s", t)
+			return 0, 0, fmt.Errorf("port range must have two ints: %s", t)
 		}
 
 		min, max = int(minAst), int(maxAst)
 	default:
-		return nil, fmt.Errorf("port range must be an int or a list of ints:"+
-			" %s", args[0])
+		return 0, 0, fmt.Errorf("port range must be an int or a list of ints:"+
+			" %s", arg)
 	}
 
 	if min < 0 || max > 65535 {
-		return nil, fmt.Errorf("invalid port range: [%d, %d]", min, max)
+		return 0, 0, fmt.Errorf("invalid port range: [%d, %d]", min, max)
 	}
 
 	if min > max {
-		return nil, fmt.Errorf("invalid port range: [%d, %d]", min, max)
+		return 0, 0, fmt.Errorf("invalid port range: [%d, %d]", min, max)
+	}
+
+	return min, max, nil
+}
+
+// connectImpl implements both `connect`, and `deny` which forbids traffic that
+// would otherwise be allowed by a connection between broader labels.
+func connectImpl(deny bool) func(*evalCtx, []ast) (ast, error) {
+	return func(ctx *evalCtx, args []ast) (ast, error) {
+		return addConnections(ctx, args, deny)
+	}
+}
+
+func addConnections(ctx *evalCtx, args []ast, deny bool) (ast, error) {
+	var protocol string
+	var min, max int
+	if p, ok := args[0].(astProtocol); ok {
+		protocol, min, max = string(p.protocol), p.min, p.max
+	} else {
+		var err error
+		if min, max, err = parsePortRange(args[0]); err != nil {
+			return nil, err
+		}
 	}
 
 	fromLabels, err := ctx.flattenLabel([]ast{args[1]})
@@ -450,24 +1281,42 @@ func connectImpl(ctx *evalCtx, args []ast) (ast, error) {
 
 	for _, from := range fromLabels {
 		for _, to := range toLabels {
+			if from.ident == PublicInternetLabel &&
+				to.ident == PublicInternetLabel {
+				return nil, fmt.Errorf("cannot connect Public Internet to itself")
+			}
 
-			if (from.ident == PublicInternetLabel ||
-				to.ident == PublicInternetLabel) &&
-				(min != max) {
+			if hasHost(from) {
+				return nil, fmt.Errorf("hosts cannot initiate connections: %s",
+					string(from.ident))
+			}
+
+			if hasHost(to) && from.ident == PublicInternetLabel {
 				return nil, fmt.Errorf(
-					"Public Internet cannot connect on port ranges")
+					"cannot connect Public Internet to hosts: %s",
+					string(to.ident))
 			}
 
-			if from.ident == PublicInternetLabel &&
-				to.ident == PublicInternetLabel {
-				return nil, fmt.Errorf("cannot connect Public Internet to itself")
+			// Traffic to the public internet and hosts is only ever allowed
+			// explicitly, so there is nothing to deny.
+			if deny && (from.ident == PublicInternetLabel ||
+				to.ident == PublicInternetLabel) {
+				return nil, fmt.Errorf(
+					"cannot deny connections with the Public Internet")
+			}
+
+			if deny && hasHost(to) {
+				return nil, fmt.Errorf("cannot deny connections to hosts: %s",
+					string(to.ident))
 			}
 
 			cn := Connection{
-				From:    string(from.ident),
-				To:      string(to.ident),
-				MinPort: min,
-				MaxPort: max,
+				From:     string(from.ident),
+				To:       string(to.ident),
+				Protocol: protocol,
+				MinPort:  min,
+				MaxPort:  max,
+				Deny:     deny,
 			}
 			ctx.globalCtx().connections[cn] = struct{}{}
 		}
@@ -476,6 +1325,16 @@ func connectImpl(ctx *evalCtx, args []ast) (ast, error) {
 	return astList{}, nil
 }
 
+// hasHost returns whether 'label' applies to any external hosts.
+func hasHost(label astLabel) bool {
+	for _, elem := range label.elems {
+		if _, ok := elem.(*astHost); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func labelImpl(ctx *evalCtx, args []ast) (ast, error) {
 	str, ok := args[0].(astString)
 	if !ok {
@@ -838,9 +1697,7 @@ func letImpl(ctx *evalCtx, args []ast) (ast, error) {
 	if len(args) > 1 {
 		body = args[1:]
 	}
-	lambda := astLambda{argNames: names, do: body, ctx: ctx}
-	let := astSexp{sexp: append([]ast{lambda}, vals...)}
-	return let.eval(ctx)
+	return evalLambda(astLambda{argNames: names, do: body, ctx: ctx}, vals)
 }
 
 func boolImpl(ctx *evalCtx, args []ast) (ast, error) {
@@ -25,35 +25,42 @@ func resolveImportsRec(asts []ast, paths, imported []string) ([]ast, error) {
 			continue
 		}
 
+		pos := ast.(astSexp).pos
 		if !top {
-			return nil, errors.New("import must be begin the module")
+			return nil, dslError{pos: pos,
+				err: errors.New("import must be begin the module")}
 		}
 
 		// Check for any import cycles.
 		for _, importedModule := range imported {
 			if name == importedModule {
-				return nil, fmt.Errorf("import cycle: %s",
-					append(imported, name))
+				return nil, dslError{pos: pos, err: fmt.Errorf(
+					"import cycle: %s", append(imported, name))}
 			}
 		}
 
+		// Modules may be written in either syntax.
 		var sc scanner.Scanner
+	search:
 		for _, path := range paths {
-			modulePath := path + "/" + name + ".spec"
-			f, err := util.Open(modulePath)
-			if err == nil {
-				defer f.Close()
-				sc.Filename = modulePath
-				sc.Init(bufio.NewReader(f))
-				break
+			for _, ext := range []string{".spec", infixExt} {
+				modulePath := path + "/" + name + ext
+				f, err := util.Open(modulePath)
+				if err == nil {
+					defer f.Close()
+					sc.Filename = modulePath
+					sc.Init(bufio.NewReader(f))
+					break search
+				}
 			}
 		}
 
 		if sc.Filename == "" {
-			return nil, fmt.Errorf("unable to open import %s", name)
+			return nil, dslError{pos: pos,
+				err: fmt.Errorf("unable to open import %s", name)}
 		}
 
-		parsed, err := parse(sc)
+		parsed, err := parseSource(sc)
 		if err != nil {
 			return nil, err
 		}
@@ -63,7 +70,8 @@ func resolveImportsRec(asts []ast, paths, imported []string) ([]ast, error) {
 			return nil, err
 		}
 
-		module := astModule{body: parsed, moduleName: astString(name)}
+		module := astModule{body: parsed, moduleName: astString(name),
+			pos: pos}
 		newAsts = append(newAsts, module)
 	}
 
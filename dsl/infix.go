@@ -0,0 +1,687 @@
+package dsl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// infixExt is the file extension of specs written in the infix syntax.  They're
+// lowered to the same ast as the Lisp syntax, so either may import the other.
+const infixExt = ".di"
+
+// parseSource parses the spec in 'sc', in the infix syntax if it's read from a
+// ".di" file, or in the Lisp syntax otherwise.
+func parseSource(sc scanner.Scanner) ([]ast, error) {
+	if strings.HasSuffix(sc.Filename, infixExt) {
+		return parseInfix(sc)
+	}
+	return parse(sc)
+}
+
+type infixToken struct {
+	tok  rune
+	text string
+	pos  scanner.Position
+}
+
+func (t infixToken) is(text string) bool {
+	return t.tok != scanner.String && t.text == text
+}
+
+// The binary operators, by precedence from lowest to highest.
+var infixPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func parseInfix(s scanner.Scanner) ([]ast, error) {
+	var scanErrors []string
+	s.Error = func(s *scanner.Scanner, msg string) {
+		scanErrors = append(scanErrors, msg)
+	}
+
+	toks, err := scanInfix(&s)
+	if s.ErrorCount != 0 {
+		return nil, errors.New(strings.Join(scanErrors, "\n"))
+	} else if err != nil {
+		return nil, err
+	}
+
+	p := infixParser{toks: toks}
+	var stmts []infixStmt
+	for p.peek().tok != scanner.EOF {
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return lowerBody(stmts, false)
+}
+
+func scanInfix(s *scanner.Scanner) ([]infixToken, error) {
+	var toks []infixToken
+	for {
+		tok := s.Scan()
+		t := infixToken{tok: tok, text: s.TokenText(), pos: s.Position}
+
+		switch tok {
+		case scanner.EOF:
+			t.pos = s.Pos()
+			return append(toks, t), nil
+		case scanner.Ident:
+			// Periods are allowed in module names.
+			for s.Peek() == '.' {
+				s.Next()
+				t.text += "."
+				if s.Scan() != scanner.Ident {
+					return nil, dslError{pos: s.Pos(),
+						err: fmt.Errorf("bad ident name: %s", t.text)}
+				}
+				t.text += s.TokenText()
+			}
+		case scanner.Int, scanner.Float, scanner.String:
+		case '=', '!', '<', '>':
+			if s.Peek() == '=' {
+				t.text += string(s.Next())
+			}
+		case '&', '|':
+			if s.Peek() != tok {
+				return nil, dslError{pos: t.pos,
+					err: fmt.Errorf("bad element: %s", t.text)}
+			}
+			t.text += string(s.Next())
+		case '-':
+			if s.Peek() == '>' {
+				t.text += string(s.Next())
+			}
+		case '+', '*', '/', '%', '(', ')', '[', ']', '{', '}', ',', ':':
+		default:
+			return nil, dslError{pos: t.pos,
+				err: fmt.Errorf("bad element: %s", t.text)}
+		}
+		toks = append(toks, t)
+	}
+}
+
+// An infixStmt is a statement of a block.  Statements are lowered to expressions,
+// except for let, which binds its name in the statements that follow it.
+type infixStmt struct {
+	expr ast
+
+	let    astIdent
+	letPos scanner.Position
+}
+
+type infixParser struct {
+	toks []infixToken
+	i    int
+}
+
+func (p *infixParser) peek() infixToken {
+	return p.toks[p.i]
+}
+
+func (p *infixParser) next() infixToken {
+	tok := p.toks[p.i]
+	if tok.tok != scanner.EOF {
+		p.i++
+	}
+	return tok
+}
+
+// sameLine returns whether the next token is on the same line as the last one.
+func (p *infixParser) sameLine() bool {
+	return p.i > 0 && p.peek().pos.Line == p.toks[p.i-1].pos.Line
+}
+
+func (p *infixParser) expect(text string) (infixToken, error) {
+	tok := p.next()
+	if !tok.is(text) {
+		return tok, p.unexpected(tok, text)
+	}
+	return tok, nil
+}
+
+func (p *infixParser) unexpected(tok infixToken, expected string) error {
+	found := tok.text
+	if tok.tok == scanner.EOF {
+		found = "end of file"
+	}
+	return dslError{pos: tok.pos,
+		err: fmt.Errorf("expected %s, found %s", expected, found)}
+}
+
+func (p *infixParser) ident() (astIdent, error) {
+	tok := p.next()
+	if tok.tok != scanner.Ident {
+		return "", p.unexpected(tok, "a name")
+	}
+	return astIdent(tok.text), nil
+}
+
+func (p *infixParser) statement() (infixStmt, error) {
+	tok := p.peek()
+	if tok.tok != scanner.Ident {
+		expr, err := p.expression()
+		return infixStmt{expr: expr}, err
+	}
+
+	// The block forms share their names with builtins, which may still be
+	// called like any other function.
+	p.next()
+	call := p.peek().is("(") && p.sameLine()
+	p.i--
+
+	var expr ast
+	var err error
+	switch {
+	case tok.text == "import":
+		expr, err = p.importStmt()
+	case tok.text == "define":
+		expr, err = p.define()
+	case tok.text == "let":
+		return p.let()
+	case tok.text == "module" && !call:
+		expr, err = p.module()
+	case tok.text == "label" && !call:
+		expr, err = p.label()
+	case (tok.text == "connect" || tok.text == "deny") && !call:
+		expr, err = p.connect()
+	default:
+		expr, err = p.expression()
+	}
+	return infixStmt{expr: expr}, err
+}
+
+// import "name"
+func (p *infixParser) importStmt() (ast, error) {
+	tok := p.next()
+	name := p.next()
+	if name.tok != scanner.String {
+		return nil, p.unexpected(name, "a module name")
+	}
+	return infixSexp(tok, astIdent("import"), parseString(name)), nil
+}
+
+// define name = value
+// define name(params) { body }
+func (p *infixParser) define() (ast, error) {
+	tok := p.next()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().is("=") {
+		p.next()
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return infixSexp(tok, astIdent("define"), name, value), nil
+	}
+
+	params, err := p.params()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+
+	target := infixSexp(tok, append([]ast{name}, params...)...)
+	return infixSexp(tok, append([]ast{astIdent("define"), target}, body...)...),
+		nil
+}
+
+// let name = value
+func (p *infixParser) let() (infixStmt, error) {
+	tok := p.next()
+	name, err := p.ident()
+	if err != nil {
+		return infixStmt{}, err
+	}
+
+	if _, err := p.expect("="); err != nil {
+		return infixStmt{}, err
+	}
+
+	value, err := p.expression()
+	return infixStmt{expr: value, let: name, letPos: tok.pos}, err
+}
+
+// module "name" { body }
+func (p *infixParser) module() (ast, error) {
+	tok := p.next()
+	name, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return infixSexp(tok, append([]ast{astIdent("module"), name}, body...)...), nil
+}
+
+// label "name" { members }
+func (p *infixParser) label() (ast, error) {
+	tok := p.next()
+	name, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	members := []ast{astIdent("list")}
+	for !p.peek().is("}") {
+		member, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	p.next()
+
+	return infixSexp(tok, astIdent("label"), name, infixSexp(tok, members...)),
+		nil
+}
+
+// connect ports { from -> to ... }
+func (p *infixParser) connect() (ast, error) {
+	tok := p.next()
+	ports, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	conns := []ast{astIdent("progn")}
+	for !p.peek().is("}") {
+		from, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+
+		arrow, err := p.expect("->")
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+
+		conns = append(conns,
+			infixSexp(arrow, astIdent(tok.text), ports, from, to))
+	}
+	p.next()
+
+	if len(conns) == 1 {
+		return infixSexp(tok, astIdent("list")), nil
+	}
+	return infixSexp(tok, conns...), nil
+}
+
+// params parses a parenthesized list of parameter names.
+func (p *infixParser) params() ([]ast, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var params []ast
+	for !p.peek().is(")") {
+		if len(params) > 0 {
+			if _, err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, name)
+	}
+	p.next()
+	return params, nil
+}
+
+// block parses the statements between braces, and returns their lowered forms.
+func (p *infixParser) block() ([]ast, error) {
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var stmts []infixStmt
+	for !p.peek().is("}") {
+		if p.peek().tok == scanner.EOF {
+			return nil, p.unexpected(p.peek(), "}")
+		}
+
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	p.next()
+
+	return lowerBody(stmts, true)
+}
+
+// lowerBody returns the expressions that evaluate 'stmts' in order.  A let
+// becomes a Lisp let whose body is the statements that follow it.  Consecutive
+// lets share one Lisp let, as its bindings may refer to the earlier ones.
+func lowerBody(stmts []infixStmt, inBlock bool) ([]ast, error) {
+	var body []ast
+	for i := 0; i < len(stmts); i++ {
+		if stmts[i].let == "" {
+			body = append(body, stmts[i].expr)
+			continue
+		}
+
+		pos := stmts[i].letPos
+		if !inBlock {
+			return nil, dslError{pos: pos,
+				err: errors.New("let is only allowed within a block")}
+		}
+
+		var bindings []ast
+		for ; i < len(stmts) && stmts[i].let != ""; i++ {
+			bindings = append(bindings, astSexp{
+				sexp: []ast{stmts[i].let, stmts[i].expr},
+				pos:  stmts[i].letPos})
+		}
+
+		rest, err := lowerBody(stmts[i:], inBlock)
+		if err != nil {
+			return nil, err
+		}
+
+		let := []ast{astIdent("let"), astSexp{sexp: bindings, pos: pos}}
+		body = append(body, astSexp{sexp: append(let, rest...), pos: pos})
+		break
+	}
+	return body, nil
+}
+
+func (p *infixParser) expression() (ast, error) {
+	return p.binary(0)
+}
+
+func (p *infixParser) binary(level int) (ast, error) {
+	if level == len(infixPrecedence) {
+		return p.unary()
+	}
+
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		// An operator must follow its left operand on the same line, otherwise
+		// it's the start of the next statement.
+		op := p.peek()
+		if op.tok == scanner.String || !p.sameLine() ||
+			!contains(infixPrecedence[level], op.text) {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = lowerOperator(op, left, right)
+	}
+}
+
+// lowerOperator returns the Lisp expression that applies 'op' to 'a' and 'b'.
+func lowerOperator(op infixToken, a, b ast) ast {
+	switch op.text {
+	case "||":
+		return infixSexp(op, astIdent("or"), a, b)
+	case "&&":
+		return infixSexp(op, astIdent("and"), a, b)
+	case "==":
+		return infixSexp(op, astIdent("="), a, b)
+	default:
+		return infixSexp(op, astIdent(op.text), a, b)
+	}
+}
+
+func (p *infixParser) unary() (ast, error) {
+	tok := p.peek()
+	if !tok.is("-") && !tok.is("!") {
+		return p.postfix()
+	}
+	p.next()
+
+	operand, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.is("!") {
+		return infixSexp(tok, astIdent("!"), operand), nil
+	}
+
+	switch x := operand.(type) {
+	case astInt:
+		return -x, nil
+	case astFloat:
+		return -x, nil
+	}
+	return infixSexp(tok, astIdent("-"), astInt(0), operand), nil
+}
+
+// postfix parses a primary expression, and any calls made with it.
+func (p *infixParser) postfix() (ast, error) {
+	expr, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("(") && p.sameLine() {
+		tok := p.next()
+		args, err := p.list(")")
+		if err != nil {
+			return nil, err
+		}
+		expr = infixSexp(tok, append([]ast{expr}, args...)...)
+	}
+	return expr, nil
+}
+
+func (p *infixParser) primary() (ast, error) {
+	tok := p.next()
+	switch tok.tok {
+	case scanner.Int:
+		x, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, dslError{pos: tok.pos,
+				err: fmt.Errorf("bad int: %s", tok.text)}
+		}
+		return astInt(x), nil
+	case scanner.Float:
+		x, _ := strconv.ParseFloat(tok.text, 64)
+		return astFloat(x), nil
+	case scanner.String:
+		return parseString(tok), nil
+	case scanner.Ident:
+		switch tok.text {
+		case "fn":
+			return p.lambda(tok)
+		case "if":
+			return p.ifExpr(tok)
+		}
+		return parseIdent(tok.text), nil
+	}
+
+	switch tok.text {
+	case "(":
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return expr, err
+	case "[":
+		elems, err := p.list("]")
+		if err != nil {
+			return nil, err
+		}
+		return infixSexp(tok, append([]ast{astIdent("list")}, elems...)...), nil
+	case "{":
+		return p.hmap(tok)
+	}
+	return nil, p.unexpected(tok, "an expression")
+}
+
+// list parses comma separated expressions up to 'end'.
+func (p *infixParser) list(end string) ([]ast, error) {
+	var elems []ast
+	for !p.peek().is(end) {
+		if len(elems) > 0 {
+			if _, err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		elem, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	p.next()
+	return elems, nil
+}
+
+// { key: value, ... }
+func (p *infixParser) hmap(tok infixToken) (ast, error) {
+	pairs := []ast{astIdent("hmap")}
+	for !p.peek().is("}") {
+		if len(pairs) > 1 {
+			if _, err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		key, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+
+		colon, err := p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, infixSexp(colon, key, value))
+	}
+	p.next()
+	return infixSexp(tok, pairs...), nil
+}
+
+// fn(params) { body }
+func (p *infixParser) lambda(tok infixToken) (ast, error) {
+	params, err := p.params()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+
+	lambda := []ast{astIdent("lambda"), infixSexp(tok, params...)}
+	return infixSexp(tok, append(lambda, body...)...), nil
+}
+
+// if cond { body } else if cond { body } else { body }
+func (p *infixParser) ifExpr(tok infixToken) (ast, error) {
+	cond, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	sexp := []ast{astIdent("if"), cond, progn(tok, body)}
+
+	if p.peek().is("else") {
+		p.next()
+
+		var elseBody ast
+		if next := p.next(); next.is("if") {
+			elseBody, err = p.ifExpr(next)
+		} else {
+			p.i--
+			var body []ast
+			body, err = p.block()
+			elseBody = progn(tok, body)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		sexp = append(sexp, elseBody)
+	}
+	return infixSexp(tok, sexp...), nil
+}
+
+// progn returns an expression that evaluates 'body', and returns its last value.
+func progn(tok infixToken, body []ast) ast {
+	switch len(body) {
+	case 0:
+		return infixSexp(tok, astIdent("list"))
+	case 1:
+		return body[0]
+	default:
+		return infixSexp(tok, append([]ast{astIdent("progn")}, body...)...)
+	}
+}
+
+func infixSexp(tok infixToken, sexp ...ast) astSexp {
+	return astSexp{sexp: sexp, pos: tok.pos}
+}
+
+func parseString(tok infixToken) astString {
+	return astString(strings.Trim(tok.text, "\""))
+}
+
+func contains(list []string, str string) bool {
+	for _, elem := range list {
+		if elem == str {
+			return true
+		}
+	}
+	return false
+}
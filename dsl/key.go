@@ -8,6 +8,14 @@ type key interface {
 	ast
 }
 
+// sshKey returns the key that grants 'u' access to the machines.
+func (u *astUser) sshKey() key {
+	if u.keyType == "github" {
+		return astGithubKey(u.key)
+	}
+	return astPlaintextKey(u.key)
+}
+
 var githubCache = make(map[string][]string)
 
 func (githubKey astGithubKey) keys() ([]string, error) {
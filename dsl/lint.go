@@ -0,0 +1,635 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/scanner"
+)
+
+// ProviderInfo lists the regions and sizes of a cloud provider for Lint to check
+// machines against.  Empty lists aren't checked.
+type ProviderInfo struct {
+	Regions []string
+	Sizes   []string
+}
+
+// Lint checks the spec in 'sc' for problems, and returns every problem it finds
+// ordered by position.  The spec is first checked without evaluating it, for
+// unknown functions and variables, the wrong number of arguments, and literal
+// arguments of the wrong type or with invalid values.  The top level forms that
+// pass are then evaluated to find machines without a role or a valid provider,
+// region or size, and, if every form evaluates, labels that can't reach or be
+// reached by anything.  'providers' maps the names of the valid providers to their
+// details.  If it's nil, providers aren't checked.
+func Lint(sc scanner.Scanner, path []string,
+	providers map[string]ProviderInfo) []error {
+
+	parsed, err := parseSource(sc)
+	if err != nil {
+		return []error{err}
+	}
+
+	parsed, err = resolveImports(parsed, path)
+	if err != nil {
+		return []error{err}
+	}
+
+	l := linter{globals: map[astIdent]int{}, providers: providers}
+	l.collectGlobals(parsed)
+
+	// The forms with static errors would only fail again when evaluated.
+	broken := map[int]bool{}
+	for i, node := range parsed {
+		nErrs := len(l.errs)
+		if sexp, ok := node.(astSexp); ok {
+			if name, _, ok := parseDefine(sexp); ok && name == adminACL {
+				l.errorf(sexp.pos, "%s", errAdminACL)
+			}
+			l.walkSexp(sexp, nil)
+		}
+
+		broken[i] = len(l.errs) > nErrs
+	}
+
+	l.lintEvaluated(parsed, broken)
+	return l.sortedErrors()
+}
+
+// The types of literal arguments.  The lits of a funcImpl use their disjunctions.
+const (
+	litString = 1 << iota
+	litInt
+	litFloat
+	litBool
+
+	litNumber = litInt | litFloat
+	litAny    = litString | litNumber | litBool
+)
+
+type linter struct {
+	// The functions and variables defined anywhere in the spec, mapped to the
+	// number of arguments they take, or -1 if unknown.
+	globals map[astIdent]int
+
+	providers map[string]ProviderInfo
+	errs      []error
+}
+
+func (l *linter) errorf(pos scanner.Position, format string, args ...interface{}) {
+	l.errs = append(l.errs, dslError{pos: pos, err: fmt.Errorf(format, args...)})
+}
+
+// collectGlobals records every define in 'forms', and the exported defines of
+// the imported modules.  Defines nested in functions are included, as it's the
+// evaluation that decides whether they run first.
+func (l *linter) collectGlobals(forms []ast) {
+	for _, form := range forms {
+		switch form := form.(type) {
+		case astModule:
+			l.collectExports(string(form.moduleName), form.body)
+		case astSexp:
+			if name, arity, ok := parseDefine(form); ok {
+				l.globals[name] = arity
+			}
+
+			if module, ok := parseModule(form); ok {
+				l.collectExports(module, form.sexp[2:])
+			}
+			l.collectGlobals(form.sexp)
+		}
+	}
+}
+
+func (l *linter) collectExports(module string, body []ast) {
+	for _, form := range body {
+		sexp, ok := form.(astSexp)
+		if !ok {
+			continue
+		}
+
+		name, arity, ok := parseDefine(sexp)
+		if ok && shouldExport(string(name)) {
+			l.globals[astIdent(module+"."+string(name))] = arity
+		}
+	}
+}
+
+// parseDefine returns the name defined by 'sexp', and the number of arguments it
+// takes if it's a function, or -1.
+func parseDefine(sexp astSexp) (astIdent, int, bool) {
+	if len(sexp.sexp) < 3 || sexp.sexp[0] != astIdent("define") {
+		return "", 0, false
+	}
+
+	switch target := sexp.sexp[1].(type) {
+	case astIdent:
+		return target, lambdaArity(sexp.sexp[2]), true
+	case astSexp:
+		if len(target.sexp) == 0 {
+			return "", 0, false
+		}
+		name, ok := target.sexp[0].(astIdent)
+		return name, len(target.sexp) - 1, ok
+	}
+	return "", 0, false
+}
+
+// lambdaArity returns the number of arguments taken by 'value' if it's a lambda
+// expression, or -1.
+func lambdaArity(value ast) int {
+	sexp, ok := value.(astSexp)
+	if !ok || len(sexp.sexp) < 2 || sexp.sexp[0] != astIdent("lambda") {
+		return -1
+	}
+
+	params, ok := sexp.sexp[1].(astSexp)
+	if !ok {
+		return -1
+	}
+	return len(params.sexp)
+}
+
+func parseModule(sexp astSexp) (string, bool) {
+	if len(sexp.sexp) < 2 || sexp.sexp[0] != astIdent("module") {
+		return "", false
+	}
+	name, ok := sexp.sexp[1].(astString)
+	return string(name), ok
+}
+
+// walk checks 'node', which appears in the S-expression at 'pos'.  'scope' maps
+// the local variables to the number of arguments they take, or -1.
+func (l *linter) walk(node ast, pos scanner.Position, scope map[astIdent]int) {
+	switch node := node.(type) {
+	case astSexp:
+		l.walkSexp(node, scope)
+	case astIdent:
+		if !l.bound(node, scope) {
+			l.errorf(pos, "unassigned variable: %s", node)
+		}
+	}
+}
+
+func (l *linter) bound(ident astIdent, scope map[astIdent]int) bool {
+	_, builtin := funcImplMap[ident]
+	_, local := scope[ident]
+	_, global := l.globals[ident]
+	return builtin || local || global
+}
+
+func (l *linter) walkSexp(sexp astSexp, scope map[astIdent]int) {
+	if len(sexp.sexp) == 0 {
+		l.errorf(sexp.pos, "S-expressions must start with a function call: %s",
+			sexp)
+		return
+	}
+
+	args := sexp.sexp[1:]
+	switch fn := sexp.sexp[0].(type) {
+	case astIdent:
+		if impl, ok := funcImplMap[fn]; ok {
+			l.walkBuiltin(fn, impl, sexp, scope)
+			return
+		}
+
+		arity, ok := scope[fn]
+		if !ok {
+			arity, ok = l.globals[fn]
+		}
+
+		if !ok {
+			l.errorf(sexp.pos, "unknown function: %s", fn)
+		} else if arity >= 0 && len(args) != arity {
+			l.errorf(sexp.pos, "wrong number of arguments to %s: "+
+				"expected %d, found %d", fn, arity, len(args))
+		}
+	case astSexp:
+		l.walkSexp(fn, scope)
+	default:
+		l.errorf(sexp.pos, "S-expressions must start with a function call: %s",
+			sexp)
+	}
+
+	l.checkMachineAttributes(sexp)
+	for _, arg := range args {
+		l.walk(arg, sexp.pos, scope)
+	}
+}
+
+func (l *linter) walkBuiltin(fn astIdent, impl funcImpl, sexp astSexp,
+	scope map[astIdent]int) {
+
+	args := sexp.sexp[1:]
+	if len(args) < impl.minArgs {
+		l.errorf(sexp.pos, "not enough arguments: %s", fn)
+		return
+	}
+
+	if impl.maxArgs != variadic && len(args) > impl.maxArgs {
+		l.errorf(sexp.pos, "too many arguments: %s", fn)
+	}
+
+	l.checkLiterals(fn, impl, sexp)
+	l.checkMachineAttributes(sexp)
+
+	switch fn {
+	case "define":
+		if target, ok := args[0].(astSexp); ok {
+			scope = bindParams(scope, target.sexp[1:])
+		}
+		args = args[1:]
+	case "lambda":
+		if params, ok := args[0].(astSexp); ok {
+			scope = bindParams(scope, params.sexp)
+		}
+		args = args[1:]
+	case "let":
+		bindings, ok := args[0].(astSexp)
+		if !ok {
+			break
+		}
+
+		// Later bindings may refer to earlier ones.
+		scope = bindParams(scope, nil)
+		for _, b := range bindings.sexp {
+			bind, ok := b.(astSexp)
+			if !ok || len(bind.sexp) != 2 {
+				continue
+			}
+			l.walk(bind.sexp[1], bindings.pos, scope)
+			if name, ok := bind.sexp[0].(astIdent); ok {
+				scope[name] = lambdaArity(bind.sexp[1])
+			}
+		}
+		args = args[1:]
+	case "hmap":
+		// The arguments are key-value pairs, not function calls.
+		for _, arg := range args {
+			if pair, ok := arg.(astSexp); ok {
+				for _, elem := range pair.sexp {
+					l.walk(elem, sexp.pos, scope)
+				}
+			}
+		}
+		return
+	case "import":
+		return
+	case "module":
+		args = args[1:]
+	}
+
+	for _, arg := range args {
+		l.walk(arg, sexp.pos, scope)
+	}
+}
+
+// bindParams returns a copy of 'scope' with the identifiers in 'params' added.
+func bindParams(scope map[astIdent]int, params []ast) map[astIdent]int {
+	result := map[astIdent]int{}
+	for k, v := range scope {
+		result[k] = v
+	}
+
+	for _, param := range params {
+		if ident, ok := param.(astIdent); ok {
+			result[ident] = -1
+		}
+	}
+	return result
+}
+
+func literalType(arg ast) int {
+	switch arg.(type) {
+	case astString:
+		return litString
+	case astInt:
+		return litInt
+	case astFloat:
+		return litFloat
+	case astBool:
+		return litBool
+	default:
+		return 0
+	}
+}
+
+// checkLiterals checks the types and values of the literal arguments of the
+// builtin 'fn'.
+func (l *linter) checkLiterals(fn astIdent, impl funcImpl, sexp astSexp) {
+	args := sexp.sexp[1:]
+	if sig := impl.lits; sig != nil {
+		for i, arg := range args {
+			typ := sig[len(sig)-1]
+			if i < len(sig) {
+				typ = sig[i]
+			}
+
+			if lit := literalType(arg); lit != 0 && lit&typ == 0 {
+				l.errorf(sexp.pos, "bad argument to %s: %s", fn, arg)
+			}
+		}
+	}
+
+	// + either adds numbers or concatenates strings, but can't mix them.
+	if fn == "+" {
+		var seen int
+		for _, arg := range args {
+			lit := literalType(arg)
+			if lit&litString != 0 && seen&litNumber != 0 ||
+				lit&litNumber != 0 && seen&litString != 0 {
+				l.errorf(sexp.pos, "bad argument to %s: %s", fn, arg)
+				break
+			}
+			seen |= lit
+		}
+	}
+
+	if len(args) == 0 {
+		return
+	}
+
+	str, ok := args[0].(astString)
+	if !ok {
+		return
+	}
+
+	switch fn {
+	case "role":
+		if str != "Master" && str != "Worker" {
+			l.errorf(sexp.pos, "unknown role: %s", str)
+		}
+	case "provider":
+		if _, ok := l.providers[string(str)]; l.providers != nil && !ok {
+			l.errorf(sexp.pos, "unknown provider: %s", str)
+		}
+	case "label":
+		if str == PublicInternetLabel {
+			l.errorf(sexp.pos, "the %s label is reserved for the public "+
+				"internet", str)
+		} else if string(str) != strings.ToLower(string(str)) {
+			l.errorf(sexp.pos, "labels must be lowercase: %s", str)
+		}
+	}
+}
+
+// checkMachineAttributes checks the regions and sizes among the arguments of
+// 'sexp' against the provider given alongside them, if any.
+func (l *linter) checkMachineAttributes(sexp astSexp) {
+	attrs := map[astIdent][]astSexp{}
+	for _, arg := range sexp.sexp[1:] {
+		attr, ok := arg.(astSexp)
+		if !ok || len(attr.sexp) != 2 {
+			continue
+		}
+
+		fn, _ := attr.sexp[0].(astIdent)
+		if _, ok := attr.sexp[1].(astString); ok {
+			attrs[fn] = append(attrs[fn], attr)
+		}
+	}
+
+	if len(attrs["provider"]) != 1 {
+		return
+	}
+
+	provider := string(attrs["provider"][0].sexp[1].(astString))
+	info, ok := l.providers[provider]
+	if !ok {
+		return
+	}
+
+	for _, attr := range attrs["region"] {
+		if region := string(attr.sexp[1].(astString)); !valid(info.Regions, region) {
+			l.errorf(attr.pos, "unknown region for %s: %s", provider,
+				attr.sexp[1])
+		}
+	}
+
+	for _, attr := range attrs["size"] {
+		if size := string(attr.sexp[1].(astString)); !valid(info.Sizes, size) {
+			l.errorf(attr.pos, "unknown size for %s: %s", provider, attr.sexp[1])
+		}
+	}
+}
+
+// valid returns whether 'value' is in 'choices', or 'choices' is empty.
+func valid(choices []string, value string) bool {
+	if len(choices) == 0 {
+		return true
+	}
+
+	for _, choice := range choices {
+		if choice == value {
+			return true
+		}
+	}
+	return false
+}
+
+// lintEvaluated evaluates 'forms', and checks the machines, labels and
+// connections they create.  Problems are reported at the top level form that
+// created the offending atom, forms in imported modules aren't checked.  The forms
+// whose index is in 'broken' aren't evaluated, nor are the forms that use what
+// they define, or what the forms that fail to evaluate define.
+func (l *linter) lintEvaluated(forms []ast, broken map[int]bool) {
+	ctx := newEvalCtx(nil)
+	labelPos := map[string]scanner.Position{}
+	connPos := map[Connection]scanner.Position{}
+
+	failed := map[astIdent]struct{}{}
+	complete := true
+	for i, form := range forms {
+		if broken[i] || usesFailed(form, failed) {
+			markFailed(form, failed)
+			complete = false
+			continue
+		}
+
+		nMachines := len(*ctx.machines)
+		if _, err := form.eval(&ctx); err != nil {
+			l.errs = append(l.errs, err)
+			markFailed(form, failed)
+			complete = false
+			continue
+		}
+
+		sexp, ok := form.(astSexp)
+		if !ok {
+			continue
+		}
+
+		for _, m := range (*ctx.machines)[nMachines:] {
+			l.checkMachine(sexp.pos, m)
+		}
+
+		for name := range ctx.labels {
+			if _, ok := labelPos[name]; !ok {
+				labelPos[name] = sexp.pos
+			}
+		}
+
+		for conn := range ctx.connections {
+			if _, ok := connPos[conn]; !ok {
+				connPos[conn] = sexp.pos
+			}
+		}
+	}
+
+	// Whether a label can communicate depends on the whole spec.
+	if !complete {
+		return
+	}
+
+	connected := map[string]bool{}
+	for conn := range ctx.connections {
+		connected[conn.From] = true
+		connected[conn.To] = true
+
+		for _, name := range []string{conn.From, conn.To} {
+			if name == PublicInternetLabel {
+				continue
+			}
+
+			label := ctx.labels[name]
+			if pos, ok := connPos[conn]; ok && !hasEndpoints(label) {
+				l.errorf(pos, "connection to a label without containers "+
+					"or hosts: %s", name)
+			}
+		}
+	}
+
+	for name, label := range ctx.labels {
+		pos, ok := labelPos[name]
+		if ok && hasContainers(label) && !connected[name] {
+			l.errorf(pos, "label has no connections, so its containers "+
+				"can't communicate: %s", name)
+		}
+	}
+}
+
+// markFailed records the names defined by the top level 'form' in 'failed'.
+// Modules are recorded by their name.
+func markFailed(form ast, failed map[astIdent]struct{}) {
+	switch form := form.(type) {
+	case astModule:
+		failed[astIdent(form.moduleName)] = struct{}{}
+	case astSexp:
+		if name, _, ok := parseDefine(form); ok {
+			failed[name] = struct{}{}
+		}
+
+		if module, ok := parseModule(form); ok {
+			failed[astIdent(module)] = struct{}{}
+		}
+	}
+}
+
+// usesFailed returns whether 'node' refers to a name in 'failed', or a member of a
+// module in it.
+func usesFailed(node ast, failed map[astIdent]struct{}) bool {
+	switch node := node.(type) {
+	case astIdent:
+		module := strings.SplitN(string(node), ".", 2)[0]
+		_, name := failed[node]
+		_, member := failed[astIdent(module)]
+		return name || member
+	case astModule:
+		for _, form := range node.body {
+			if usesFailed(form, failed) {
+				return true
+			}
+		}
+	case astSexp:
+		for _, elem := range node.sexp {
+			if usesFailed(elem, failed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (l *linter) checkMachine(pos scanner.Position, m *astMachine) {
+	if m.role == "" {
+		l.errorf(pos, "machine has no role: %s", m)
+	}
+
+	if m.provider == "" {
+		l.errorf(pos, "machine has no provider: %s", m)
+		return
+	}
+
+	if l.providers == nil {
+		return
+	}
+
+	provider := string(m.provider)
+	info, ok := l.providers[provider]
+	if !ok {
+		l.errorf(pos, "unknown provider: %s", astString(m.provider))
+		return
+	}
+
+	if m.region != "" && !valid(info.Regions, string(m.region)) {
+		l.errorf(pos, "unknown region for %s: %s", provider,
+			astString(m.region))
+	}
+
+	if m.size != "" && !valid(info.Sizes, string(m.size)) {
+		l.errorf(pos, "unknown size for %s: %s", provider, astString(m.size))
+	}
+}
+
+func hasContainers(label astLabel) bool {
+	for _, elem := range label.elems {
+		if _, ok := elem.(*astContainer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEndpoints(label astLabel) bool {
+	for _, elem := range label.elems {
+		switch elem.(type) {
+		case *astContainer, *astHost:
+			return true
+		}
+	}
+	return false
+}
+
+// sortedErrors returns the errors found, ordered by position without duplicates.
+func (l *linter) sortedErrors() []error {
+	sort.Stable(errorSlice(l.errs))
+
+	var result []error
+	for i, err := range l.errs {
+		if i == 0 || err.Error() != l.errs[i-1].Error() {
+			result = append(result, err)
+		}
+	}
+	return result
+}
+
+type errorSlice []error
+
+func (errs errorSlice) Len() int {
+	return len(errs)
+}
+
+func (errs errorSlice) Swap(i, j int) {
+	errs[i], errs[j] = errs[j], errs[i]
+}
+
+func (errs errorSlice) Less(i, j int) bool {
+	return errorPos(errs[i]).Line < errorPos(errs[j]).Line
+}
+
+func errorPos(err error) scanner.Position {
+	if dslErr, ok := err.(dslError); ok {
+		return dslErr.innermostPos()
+	}
+	return scanner.Position{}
+}
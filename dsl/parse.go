@@ -41,8 +41,14 @@ func parseText(s *scanner.Scanner, depth int) ([]ast, error) {
 	var slice []ast
 	for {
 		switch s.Scan() {
-		case '+', '-', '/', '%', '*', '=', '<', '>', '!':
+		case '+', '-', '/', '%', '*', '=':
 			slice = append(slice, parseIdent(s.TokenText()))
+		case '<', '>', '!':
+			ident := s.TokenText()
+			if s.Peek() == '=' {
+				ident += string(s.Next())
+			}
+			slice = append(slice, parseIdent(ident))
 		case scanner.Ident:
 			ident := s.TokenText()
 			// Periods are allowed in package names.
@@ -77,17 +83,17 @@ func parseText(s *scanner.Scanner, depth int) ([]ast, error) {
 
 		case ')':
 			if depth == 0 {
-				return nil, dslError{s.Pos(), errUnbalancedParens}
+				return nil, dslError{pos: s.Pos(), err: errUnbalancedParens}
 			}
 			return slice, nil
 		case scanner.EOF:
 			if depth != 0 {
-				return nil, dslError{s.Pos(), errUnbalancedParens}
+				return nil, dslError{pos: s.Pos(), err: errUnbalancedParens}
 			}
 			return slice, nil
 
 		default:
-			return nil, dslError{s.Pos(), fmt.Errorf("bad element: %s", s.TokenText())}
+			return nil, dslError{pos: s.Pos(), err: fmt.Errorf("bad element: %s", s.TokenText())}
 		}
 	}
 }
@@ -0,0 +1,337 @@
+package engine
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/dsl"
+	"github.com/NetSys/di/join"
+	"github.com/NetSys/di/util"
+)
+
+// UpdateContainers makes the container table of 'view' reflect the containers
+// specified by 'spec'.
+func UpdateContainers(view db.Database, spec dsl.Dsl) {
+	pairs, dsls, dbcs := joinContainers(view.SelectFromContainer(nil), spec)
+	dsls, dbcs = rollingUpdate(pairs, dsls, dbcs)
+
+	for _, dbc := range dbcs {
+		view.Remove(dbc.(db.Container))
+	}
+
+	for _, dslc := range dsls {
+		pairs = append(pairs, join.Pair{L: dslc, R: view.InsertContainer()})
+	}
+
+	for _, pair := range pairs {
+		dslc := pair.L.(*dsl.Container)
+		dbc := pair.R.(db.Container)
+		view.Commit(fillContainer(dbc, dslc))
+	}
+}
+
+// rollingUpdate limits the containers added and removed according to the spec's
+// rolling updates.  Containers are grouped by their labels.  In groups with a
+// rolling update, old containers are removed only while at most MaxUnavailable of
+// the specified containers are missing or not ready, and new containers are added
+// only while there are at most MaxSurge extra.  Returns the new containers to add
+// and the old containers to remove now -- the rest wait for a later update, once
+// the replacements are ready.
+func rollingUpdate(pairs []join.Pair, dsls, dbcs []interface{}) (
+	add, remove []interface{}) {
+
+	type group struct {
+		update      dsl.RollingUpdate
+		kept        []db.Container
+		add, remove []interface{}
+	}
+
+	groups := map[string]*group{}
+	getGroup := func(labels []string) *group {
+		sorted := append([]string{}, labels...)
+		sort.Strings(sorted)
+		key := strings.Join(sorted, ",")
+		if groups[key] == nil {
+			groups[key] = &group{}
+		}
+		return groups[key]
+	}
+
+	for _, pair := range pairs {
+		dslc := pair.L.(*dsl.Container)
+		g := getGroup(dslc.Labels())
+		g.update = dslc.RollingUpdate
+		g.kept = append(g.kept, pair.R.(db.Container))
+	}
+
+	for _, dslc := range dsls {
+		g := getGroup(dslc.(*dsl.Container).Labels())
+		g.update = dslc.(*dsl.Container).RollingUpdate
+		g.add = append(g.add, dslc)
+	}
+
+	for _, dbc := range dbcs {
+		g := getGroup(dbc.(db.Container).Labels)
+		g.remove = append(g.remove, dbc)
+	}
+
+	var keys []string
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		g := groups[key]
+		if g.update == (dsl.RollingUpdate{}) {
+			add = append(add, g.add...)
+			remove = append(remove, g.remove...)
+			continue
+		}
+
+		desired := len(g.kept) + len(g.add)
+		available := 0
+		for _, dbc := range g.kept {
+			if containerReady(dbc) {
+				available++
+			}
+		}
+
+		// Old containers that aren't ready can go right away, they don't
+		// contribute to availability.
+		var oldReady []interface{}
+		for _, dbc := range g.remove {
+			if containerReady(dbc.(db.Container)) {
+				oldReady = append(oldReady, dbc)
+			} else {
+				remove = append(remove, dbc)
+			}
+		}
+		available += len(oldReady)
+
+		removeCount := available - (desired - g.update.MaxUnavailable)
+		removeCount = clamp(removeCount, len(oldReady))
+		remove = append(remove, oldReady[:removeCount]...)
+
+		remaining := len(g.kept) + len(oldReady) - removeCount
+		addCount := desired + g.update.MaxSurge - remaining
+		addCount = clamp(addCount, len(g.add))
+		add = append(add, g.add[:addCount]...)
+	}
+
+	return add, remove
+}
+
+// clamp returns 'x' limited to the range [0, max].
+func clamp(x, max int) int {
+	if x < 0 {
+		return 0
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
+
+// containerReady returns whether 'dbc' is up and passing its health check.
+func containerReady(dbc db.Container) bool {
+	return dbc.IP != "" && (dbc.HealthCheck.Type == "" || dbc.Health == db.Healthy)
+}
+
+// UpdateConnections makes the connection table of 'view' reflect the
+// connections specified by 'spec'.
+func UpdateConnections(view db.Database, spec dsl.Dsl) {
+	pairs, dsls, dbcs := joinConnections(view.SelectFromConnection(nil), spec)
+
+	for _, dbc := range dbcs {
+		view.Remove(dbc.(db.Connection))
+	}
+
+	for _, dslc := range dsls {
+		pairs = append(pairs, join.Pair{L: dslc, R: view.InsertConnection()})
+	}
+
+	for _, pair := range pairs {
+		dslc := pair.L.(dsl.Connection)
+		dbc := pair.R.(db.Connection)
+		view.Commit(fillConnection(dbc, dslc))
+	}
+}
+
+// UpdateHosts makes the host table of 'view' reflect the external hosts
+// specified by 'spec'.
+func UpdateHosts(view db.Database, spec dsl.Dsl) {
+	score := func(left, right interface{}) int {
+		dslh := left.(dsl.Host)
+		dbh := right.(db.Host)
+		if dslh.Hostname != dbh.Hostname {
+			return -1
+		}
+		return util.EditDistance(dbh.Labels, dslh.Labels())
+	}
+
+	pairs, dslhs, dbhs := join.Join(spec.QueryHosts(), view.SelectFromHost(nil),
+		score)
+
+	for _, dbh := range dbhs {
+		view.Remove(dbh.(db.Host))
+	}
+
+	for _, dslh := range dslhs {
+		pairs = append(pairs, join.Pair{L: dslh, R: view.InsertHost()})
+	}
+
+	for _, pair := range pairs {
+		dslh := pair.L.(dsl.Host)
+		dbh := pair.R.(db.Host)
+		dbh.Hostname = dslh.Hostname
+		dbh.Labels = dslh.Labels()
+		sort.Strings(dbh.Labels)
+		view.Commit(dbh)
+	}
+}
+
+// UpdateSecrets makes the secret table of 'view' reflect 'secrets', a map from
+// secret names to their values.
+func UpdateSecrets(view db.Database, secrets map[string]string) {
+	existing := map[string]struct{}{}
+	for _, dbs := range view.SelectFromSecret(nil) {
+		value, ok := secrets[dbs.Name]
+		if _, dup := existing[dbs.Name]; !ok || dup {
+			view.Remove(dbs)
+			continue
+		}
+		existing[dbs.Name] = struct{}{}
+
+		if dbs.Value != value {
+			dbs.Value = value
+			view.Commit(dbs)
+		}
+	}
+
+	for name, value := range secrets {
+		if _, ok := existing[name]; ok {
+			continue
+		}
+
+		dbs := view.InsertSecret()
+		dbs.Name = name
+		dbs.Value = value
+		view.Commit(dbs)
+	}
+}
+
+func joinContainers(dbcs []db.Container, spec dsl.Dsl) ([]join.Pair,
+	[]interface{}, []interface{}) {
+	score := func(l, r interface{}) int {
+		dslc := l.(*dsl.Container)
+		dbc := r.(db.Container)
+
+		if dbc.Image != dslc.Image ||
+			!reflect.DeepEqual(dbc.Command, dslc.Command) ||
+			util.EditDistance(dbc.Volumes, dslc.Volumes) != 0 {
+			return -1
+		}
+
+		score := util.EditDistance(dbc.Labels, dslc.Labels())
+		for k := range dbc.Placement.Exclusive {
+			if _, ok := dslc.Placement.Exclusive[k]; !ok {
+				score += 100
+			}
+		}
+
+		for k := range dslc.Placement.Exclusive {
+			if _, ok := dbc.Placement.Exclusive[k]; !ok {
+				score += 100
+			}
+		}
+
+		for k := range dbc.Placement.Colocate {
+			if _, ok := dslc.Placement.Colocate[k]; !ok {
+				score += 100
+			}
+		}
+
+		for k := range dslc.Placement.Colocate {
+			if _, ok := dbc.Placement.Colocate[k]; !ok {
+				score += 100
+			}
+		}
+
+		if !reflect.DeepEqual(dbc.Placement.Machine,
+			db.MachinePlacement(dslc.Placement.Machine)) {
+			score += 100
+		}
+
+		for k, v := range dbc.Env {
+			v2 := dslc.Env[k]
+			if v != v2 {
+				return -1
+			}
+		}
+
+		for k, v := range dbc.SecretEnv {
+			if dslc.SecretEnv[k] != v {
+				return -1
+			}
+		}
+
+		return score
+	}
+
+	return join.Join(spec.QueryContainers(), dbcs, score)
+}
+
+func fillContainer(dbc db.Container, dslc *dsl.Container) db.Container {
+	// By sorting the labels we prevent the database from getting confused
+	// when their order is non determinisitic.
+	dbc.Labels = dslc.Labels()
+	sort.Sort(sort.StringSlice(dbc.Labels))
+
+	dbc.Command = dslc.Command
+	dbc.Image = dslc.Image
+	dbc.Placement.Exclusive = dslc.Placement.Exclusive
+	dbc.Placement.Colocate = dslc.Placement.Colocate
+	dbc.Placement.Machine = db.MachinePlacement(dslc.Placement.Machine)
+	dbc.Env = dslc.Env
+	dbc.SecretEnv = dslc.SecretEnv
+	dbc.Volumes = dslc.Volumes
+	dbc.HealthCheck = db.HealthCheck(dslc.HealthCheck)
+
+	dbc.Files = nil
+	for _, f := range dslc.Files {
+		dbc.Files = append(dbc.Files, db.File(f))
+	}
+	dbc.Resources = db.Resources(dslc.Resources)
+	return dbc
+}
+
+func joinConnections(dbcs []db.Connection, spec dsl.Dsl) ([]join.Pair,
+	[]interface{}, []interface{}) {
+	score := func(left, right interface{}) int {
+		dslc := left.(dsl.Connection)
+		dbc := right.(db.Connection)
+
+		if dslc.From == dbc.From && dslc.To == dbc.To &&
+			dslc.Protocol == dbc.Protocol && dslc.Deny == dbc.Deny &&
+			dslc.MinPort == dbc.MinPort && dslc.MaxPort == dbc.MaxPort {
+			return 0
+		}
+
+		return 1
+	}
+
+	return join.Join(spec.QueryConnections(), dbcs, score)
+}
+
+func fillConnection(dbc db.Connection, dslc dsl.Connection) db.Connection {
+	dbc.From = dslc.From
+	dbc.To = dslc.To
+	dbc.Protocol = dslc.Protocol
+	dbc.Deny = dslc.Deny
+	dbc.MinPort = dslc.MinPort
+	dbc.MaxPort = dslc.MaxPort
+	return dbc
+}
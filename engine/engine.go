@@ -18,8 +18,14 @@ var myIP = util.MyIP
 
 // UpdatePolicy executes transactions on 'conn' to make it reflect a new policy, 'dsl'.
 func UpdatePolicy(conn db.Conn, dsl dsl.Dsl) error {
+	return updatePolicy(conn, dsl, db.SpecVersion{})
+}
+
+// updatePolicy is UpdatePolicy, where 'origin' describes where the policy came from
+// for the spec history.
+func updatePolicy(conn db.Conn, dsl dsl.Dsl, origin db.SpecVersion) error {
 	txn := func(db db.Database) error {
-		return updateTxn(db, dsl)
+		return updateTxn(db, dsl, origin)
 	}
 
 	if err := conn.Transact(txn); err != nil {
@@ -29,12 +35,49 @@ func UpdatePolicy(conn db.Conn, dsl dsl.Dsl) error {
 	return nil
 }
 
-func updateTxn(view db.Database, dsl dsl.Dsl) error {
-	cluster, err := clusterTxn(view, dsl)
+// PruneClusters removes every cluster whose namespace isn't in 'namespaces', along
+// with the cluster's machines.
+func PruneClusters(conn db.Conn, namespaces []string) {
+	keep := map[string]struct{}{}
+	for _, ns := range namespaces {
+		keep[ns] = struct{}{}
+	}
+
+	conn.Transact(func(view db.Database) error {
+		clusters := view.SelectFromCluster(func(c db.Cluster) bool {
+			_, ok := keep[c.Namespace]
+			return !ok
+		})
+
+		for _, cluster := range clusters {
+			machines := view.SelectFromMachine(func(m db.Machine) bool {
+				return m.ClusterID == cluster.ID
+			})
+			for _, m := range machines {
+				view.Remove(m)
+			}
+
+			admins := view.SelectFromAdministrator(
+				func(a db.Administrator) bool {
+					return a.ClusterID == cluster.ID
+				})
+			for _, a := range admins {
+				view.Remove(a)
+			}
+			view.Remove(cluster)
+		}
+		return nil
+	})
+}
+
+func updateTxn(view db.Database, dsl dsl.Dsl, origin db.SpecVersion) error {
+	cluster, err := clusterTxn(view, dsl, origin)
 	if err != nil {
 		return err
 	}
 
+	adminTxn(view, dsl, cluster)
+
 	if err = machineTxn(view, dsl, cluster); err != nil {
 		return err
 	}
@@ -51,31 +94,74 @@ func updateTxn(view db.Database, dsl dsl.Dsl) error {
 	return nil
 }
 
-func clusterTxn(view db.Database, dsl dsl.Dsl) (int, error) {
+func clusterTxn(view db.Database, dsl dsl.Dsl, origin db.SpecVersion) (int, error) {
 	Namespace := dsl.QueryString("Namespace")
 	if Namespace == "" {
 		return 0, fmt.Errorf("policy must specify a 'Namespace'")
 	}
 
+	if err := dsl.CheckDeprecated(); err != nil {
+		return 0, err
+	}
+
+	secrets, err := specSecrets(view, dsl)
+	if err != nil {
+		return 0, err
+	}
+
 	var cluster db.Cluster
-	clusters := view.SelectFromCluster(nil)
+	clusters := view.SelectFromCluster(func(c db.Cluster) bool {
+		return c.Namespace == Namespace
+	})
 	switch len(clusters) {
 	case 1:
 		cluster = clusters[0]
 	case 0:
 		cluster = view.InsertCluster()
 	default:
-		panic("Unimplemented")
+		return 0, fmt.Errorf("duplicate clusters in namespace %s", Namespace)
+	}
+
+	spec := dsl.String()
+	if cluster.Spec != spec {
+		recordVersion(view, Namespace, spec, origin)
 	}
 
 	cluster.Namespace = Namespace
-	cluster.Spec = dsl.String()
+	cluster.Spec = spec
+	cluster.Secrets = secrets
 	view.Commit(cluster)
 
 	return cluster.ID, nil
 }
 
-func aclTxn(view db.Database, dsl dsl.Dsl, clusterID int) error {
+// specSecrets returns the sorted names of the secrets used by 'spec', or an error if
+// any of them is missing from 'view'.
+func specSecrets(view db.Database, spec dsl.Dsl) ([]string, error) {
+	known := map[string]struct{}{}
+	for _, s := range view.SelectFromSecret(nil) {
+		known[s.Name] = struct{}{}
+	}
+
+	nameSet := map[string]struct{}{}
+	for _, c := range spec.QueryContainers() {
+		for _, name := range c.SecretEnv {
+			if _, ok := known[name]; !ok {
+				return nil, fmt.Errorf("undefined secret: %s", name)
+			}
+			nameSet[name] = struct{}{}
+		}
+	}
+
+	var names []string
+	for name := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func aclTxn(view db.Database, spec dsl.Dsl, clusterID int) error {
 	clusters := view.SelectFromCluster(func(c db.Cluster) bool {
 		return c.ID == clusterID
 	})
@@ -88,23 +174,93 @@ func aclTxn(view db.Database, dsl dsl.Dsl, clusterID int) error {
 	machines := view.SelectFromMachine(func(m db.Machine) bool {
 		return m.ClusterID == cluster.ID && m.PublicIP != ""
 	})
-	acls := resolveACLs(dsl.QueryStrSlice("AdminACL"))
+
+	aclSet := map[string]struct{}{}
+	for _, admin := range selectAdmins(view, clusterID) {
+		for _, acl := range resolveACLs(admin.ACLs) {
+			aclSet[acl] = struct{}{}
+		}
+	}
 
 	for _, m := range machines {
-		acls = append(acls, m.PublicIP+"/32")
+		aclSet[m.PublicIP+"/32"] = struct{}{}
 	}
 
 	// Only commit the ACLs if they change. Otherwise, the db will repeatedly
 	// log the ACLs.
+	var acls []string
+	for acl := range aclSet {
+		acls = append(acls, acl)
+	}
 	sort.Strings(acls)
-	if !reflect.DeepEqual(cluster.ACLs, acls) {
+
+	ports := publicPorts(spec)
+	if !reflect.DeepEqual(cluster.ACLs, acls) ||
+		!reflect.DeepEqual(cluster.PublicPorts, ports) {
 		cluster.ACLs = acls
+		cluster.PublicPorts = ports
 		view.Commit(cluster)
 	}
 
 	return nil
 }
 
+// publicPorts returns the sorted, deduplicated port ranges that connections in
+// `spec` expose to the public internet.
+func publicPorts(spec dsl.Dsl) []db.PortRange {
+	portSet := map[db.PortRange]struct{}{}
+	for _, conn := range spec.QueryConnections() {
+		if conn.From != dsl.PublicInternetLabel {
+			continue
+		}
+
+		// Only tcp and udp are forwarded from the internet to containers, and
+		// connections that don't specify a protocol allow both.
+		var protocols []string
+		switch conn.Protocol {
+		case "":
+			protocols = []string{"tcp", "udp"}
+		case "tcp", "udp":
+			protocols = []string{conn.Protocol}
+		}
+
+		for _, protocol := range protocols {
+			portSet[db.PortRange{
+				Protocol: protocol,
+				MinPort:  conn.MinPort,
+				MaxPort:  conn.MaxPort,
+			}] = struct{}{}
+		}
+	}
+
+	var ports []db.PortRange
+	for pr := range portSet {
+		ports = append(ports, pr)
+	}
+	sort.Sort(portRangeSlice(ports))
+	return ports
+}
+
+type portRangeSlice []db.PortRange
+
+func (prs portRangeSlice) Len() int {
+	return len(prs)
+}
+
+func (prs portRangeSlice) Swap(i, j int) {
+	prs[i], prs[j] = prs[j], prs[i]
+}
+
+func (prs portRangeSlice) Less(i, j int) bool {
+	if prs[i].MinPort != prs[j].MinPort {
+		return prs[i].MinPort < prs[j].MinPort
+	}
+	if prs[i].MaxPort != prs[j].MaxPort {
+		return prs[i].MaxPort < prs[j].MaxPort
+	}
+	return prs[i].Protocol < prs[j].Protocol
+}
+
 // toDBMachine converts machines specified in the DSL into db.Machines that can
 // be compared against what's already in the db.
 // Specifically, it sets the role of the db.Machine, the size (which may depend
@@ -136,7 +292,10 @@ func toDBMachine(machines []dsl.Machine, maxPrice float64) []db.Machine {
 			log.Errorf("No valid size for %v, skipping.", m)
 			continue
 		}
-		m.SSHKeys = dslm.SSHKeys
+		if labels := dslm.Labels(); len(labels) > 0 {
+			m.Labels = append([]string{}, labels...)
+			sort.Strings(m.Labels)
+		}
 		m.Region = dslm.Region
 		m.DiskSize = dslm.DiskSize
 		dbMachines = append(dbMachines, m)
@@ -155,7 +314,87 @@ func canBoot(machines []db.Machine) bool {
 	return hasMaster && hasWorker
 }
 
+// adminTxn makes the administrators of the cluster reflect the users in 'spec'.
+func adminTxn(view db.Database, spec dsl.Dsl, clusterID int) {
+	score := func(left, right interface{}) int {
+		user := left.(dsl.User)
+		admin := right.(db.Administrator)
+		if user.Name != admin.Name ||
+			!reflect.DeepEqual(user.SSHKeys, admin.SSHKeys) ||
+			!reflect.DeepEqual(user.ACLs, admin.ACLs) {
+			return -1
+		}
+		return 0
+	}
+
+	_, users, admins := join.Join(spec.QueryUsers(), selectAdmins(view, clusterID),
+		score)
+
+	for _, admin := range admins {
+		view.Remove(admin.(db.Administrator))
+	}
+
+	for _, u := range users {
+		user := u.(dsl.User)
+		admin := view.InsertAdministrator()
+		admin.ClusterID = clusterID
+		admin.Name = user.Name
+		admin.SSHKeys = user.SSHKeys
+		admin.ACLs = user.ACLs
+		view.Commit(admin)
+	}
+}
+
+func selectAdmins(view db.Database, clusterID int) []db.Administrator {
+	return view.SelectFromAdministrator(func(a db.Administrator) bool {
+		return a.ClusterID == clusterID
+	})
+}
+
+// adminKeys returns the SSH keys of every administrator of the cluster.
+func adminKeys(view db.Database, clusterID int) []string {
+	var keys []string
+	for _, admin := range db.SortAdministrators(selectAdmins(view, clusterID)) {
+		keys = append(keys, admin.SSHKeys...)
+	}
+	return keys
+}
+
 func machineTxn(view db.Database, dsl dsl.Dsl, clusterID int) error {
+	pairs, bootList, terminateList := joinMachines(view, dsl, clusterID)
+	sshKeys := adminKeys(view, clusterID)
+
+	for _, toTerminate := range terminateList {
+		toTerminate := toTerminate.(db.Machine)
+		view.Remove(toTerminate)
+	}
+
+	for _, bootSet := range bootList {
+		bootSet := bootSet.(db.Machine)
+
+		pairs = append(pairs, join.Pair{L: bootSet, R: view.InsertMachine()})
+	}
+
+	for _, pair := range pairs {
+		dslMachine := pair.L.(db.Machine)
+		dbMachine := pair.R.(db.Machine)
+
+		dbMachine.Role = dslMachine.Role
+		dbMachine.Size = dslMachine.Size
+		dbMachine.DiskSize = dslMachine.DiskSize
+		dbMachine.Provider = dslMachine.Provider
+		dbMachine.Region = dslMachine.Region
+		dbMachine.SSHKeys = sshKeys
+		dbMachine.Labels = dslMachine.Labels
+		dbMachine.ClusterID = clusterID
+		view.Commit(dbMachine)
+	}
+
+	return nil
+}
+
+func joinMachines(view db.Database, dsl dsl.Dsl, clusterID int) ([]join.Pair,
+	[]interface{}, []interface{}) {
 	// XXX: How best to deal with machines that don't specify enough information?
 	dslMachinesRaw := dsl.QueryMachines()
 	maxPrice, _ := dsl.QueryFloat("MaxPrice")
@@ -185,6 +424,9 @@ func machineTxn(view db.Database, dsl dsl.Dsl, clusterID int) error {
 			return -1
 		case dbMachine.DiskSize != dslMachine.DiskSize:
 			return -1
+		case util.EditDistance(dbMachine.Labels, dslMachine.Labels) != 0:
+			// Machine labels are applied at boot.
+			return -1
 		case dbMachine.PrivateIP == "":
 			return 2
 		case dbMachine.PublicIP == "":
@@ -194,34 +436,7 @@ func machineTxn(view db.Database, dsl dsl.Dsl, clusterID int) error {
 		}
 	}
 
-	pairs, bootList, terminateList := join.Join(dslMachines, dbMachines, scoreFun)
-
-	for _, toTerminate := range terminateList {
-		toTerminate := toTerminate.(db.Machine)
-		view.Remove(toTerminate)
-	}
-
-	for _, bootSet := range bootList {
-		bootSet := bootSet.(db.Machine)
-
-		pairs = append(pairs, join.Pair{L: bootSet, R: view.InsertMachine()})
-	}
-
-	for _, pair := range pairs {
-		dslMachine := pair.L.(db.Machine)
-		dbMachine := pair.R.(db.Machine)
-
-		dbMachine.Role = dslMachine.Role
-		dbMachine.Size = dslMachine.Size
-		dbMachine.DiskSize = dslMachine.DiskSize
-		dbMachine.Provider = dslMachine.Provider
-		dbMachine.Region = dslMachine.Region
-		dbMachine.SSHKeys = dslMachine.SSHKeys
-		dbMachine.ClusterID = clusterID
-		view.Commit(dbMachine)
-	}
-
-	return nil
+	return join.Join(dslMachines, dbMachines, scoreFun)
 }
 
 func resolveACLs(acls []string) []string {
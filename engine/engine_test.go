@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"text/scanner"
@@ -26,7 +27,7 @@ func TestEngine(t *testing.T) {
 (define WorkerCount 3)
 (makeList MasterCount (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
 (makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-(define AdminACL (list "1.2.3.4/32"))`
+(user "plaintext" "key" "1.2.3.4/32")`
 
 	UpdatePolicy(conn, prog(t, code))
 	err := conn.Transact(func(view db.Database) error {
@@ -62,7 +63,7 @@ func TestEngine(t *testing.T) {
 (define WorkerCount 5)
 (makeList MasterCount (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
 (makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-(define AdminACL (list "1.2.3.4/32"))`
+(user "plaintext" "key" "1.2.3.4/32")`
 
 	UpdatePolicy(conn, prog(t, code))
 	err = conn.Transact(func(view db.Database) error {
@@ -119,7 +120,7 @@ func TestEngine(t *testing.T) {
 (define WorkerCount 1)
 (makeList MasterCount (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
 (makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-(define AdminACL (list "1.2.3.4/32"))`
+(user "plaintext" "key" "1.2.3.4/32")`
 	UpdatePolicy(conn, prog(t, code))
 	err = conn.Transact(func(view db.Database) error {
 		masters := view.SelectFromMachine(func(m db.Machine) bool {
@@ -150,7 +151,7 @@ func TestEngine(t *testing.T) {
 (define WorkerCount 1)
 (makeList MasterCount (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
 (makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-(define AdminACL (list "1.2.3.4/32"))`
+(user "plaintext" "key" "1.2.3.4/32")`
 	UpdatePolicy(conn, prog(t, code))
 	err = conn.Transact(func(view db.Database) error {
 		masters := view.SelectFromMachine(func(m db.Machine) bool {
@@ -182,7 +183,7 @@ func TestEngine(t *testing.T) {
 (define WorkerCount 1)
 (makeList MasterCount (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
 (makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-(define AdminACL (list "1.2.3.4/32"))`
+(user "plaintext" "key" "1.2.3.4/32")`
 	UpdatePolicy(conn, prog(t, code))
 	err = conn.Transact(func(view db.Database) error {
 		masters := view.SelectFromMachine(func(m db.Machine) bool {
@@ -223,7 +224,7 @@ func TestEngine(t *testing.T) {
 	(define Namespace "Namespace")
 	(list (machine (provider "AmazonSpot") (size "m4.large") (role "Master")) (machine (provider "Vagrant") (size "v.large") (role "Master")))
 	(list (machine (provider "Azure") (size "a.large") (role "Worker")) (machine (provider "Google") (size "g.large") (role "Worker")))
-	(define AdminACL (list "1.2.3.4/32"))`
+	(user "plaintext" "key" "1.2.3.4/32")`
 	UpdatePolicy(conn, prog(t, code))
 	err = conn.Transact(func(view db.Database) error {
 		masters := view.SelectFromMachine(func(m db.Machine) bool {
@@ -251,7 +252,7 @@ func TestEngine(t *testing.T) {
 	(define Namespace "Namespace")
 	(list (machine (provider "AmazonSpot") (size "m4.large") (role "Master")) (machine (provider "Azure") (size "a.large") (role "Master")))
 	(list (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-	(define AdminACL (list "1.2.3.4/32"))`
+	(user "plaintext" "key" "1.2.3.4/32")`
 	UpdatePolicy(conn, prog(t, code))
 	err = conn.Transact(func(view db.Database) error {
 		masters := view.SelectFromMachine(func(m db.Machine) bool {
@@ -358,6 +359,134 @@ func TestContainer(t *testing.T) {
 	check(code, 0, 0, 0)
 }
 
+func TestFiles(t *testing.T) {
+	conn := db.New()
+
+	check := func(content string) db.Container {
+		code := fmt.Sprintf(`(label "zk" (docker "zookeeper"))
+		(setFile "zk" "/conf/zoo.cfg" "%s")`, content)
+		conn.Transact(func(view db.Database) error {
+			UpdateContainers(view, prog(t, code))
+			return nil
+		})
+
+		dbcs := conn.SelectFromContainer(nil)
+		if len(dbcs) != 1 {
+			t.Fatalf("Unexpected containers: %v", dbcs)
+		}
+
+		exp := []db.File{{Path: "/conf/zoo.cfg", Content: content, Mode: 0644}}
+		if !reflect.DeepEqual(dbcs[0].Files, exp) {
+			t.Errorf("Bad files.\nExpected: %v\nGot: %v", exp, dbcs[0].Files)
+		}
+		return dbcs[0]
+	}
+
+	// Changing a file updates the existing container rather than replacing it.
+	before := check("tickTime=2000")
+	after := check("tickTime=1000")
+	if before.ID != after.ID {
+		t.Errorf("Container replaced: %v -> %v", before, after)
+	}
+}
+
+func TestRollingUpdate(t *testing.T) {
+	conn := db.New()
+
+	update := func(image string) {
+		code := fmt.Sprintf(`(label "web" (makeList 3 (docker "%s")))
+		(healthCheck "web" "tcp" 80)
+		(rollingUpdate "web" 0 1)`, image)
+		conn.Transact(func(view db.Database) error {
+			UpdateContainers(view, prog(t, code))
+			return nil
+		})
+	}
+
+	// Bring every container up, as the workers would.
+	ready := func() {
+		conn.Transact(func(view db.Database) error {
+			for _, dbc := range view.SelectFromContainer(nil) {
+				dbc.IP = fmt.Sprintf("10.0.0.%d", dbc.ID)
+				dbc.Health = db.Healthy
+				view.Commit(dbc)
+			}
+			return nil
+		})
+	}
+
+	check := func(expOld, expNew int) {
+		var old, new int
+		for _, dbc := range conn.SelectFromContainer(nil) {
+			switch dbc.Image {
+			case "old":
+				old++
+			case "new":
+				new++
+			}
+		}
+
+		if old != expOld || new != expNew {
+			t.Errorf("Expected %d old and %d new containers, got %d and %d",
+				expOld, expNew, old, new)
+		}
+	}
+
+	// Without any replacements, the label scales up all at once.
+	update("old")
+	check(3, 0)
+	ready()
+
+	// A single surge container is added, and old containers aren't removed
+	// until it's ready.
+	update("new")
+	check(3, 1)
+	update("new")
+	check(3, 1)
+
+	ready()
+	update("new")
+	check(2, 2)
+
+	ready()
+	update("new")
+	check(1, 3)
+
+	ready()
+	update("new")
+	check(0, 3)
+}
+
+func TestHosts(t *testing.T) {
+	conn := db.New()
+
+	check := func(code string, exp map[string][]string) {
+		hosts := map[string][]string{}
+		conn.Transact(func(view db.Database) error {
+			UpdateHosts(view, prog(t, code))
+			for _, h := range view.SelectFromHost(nil) {
+				hosts[h.Hostname] = h.Labels
+			}
+			return nil
+		})
+
+		if !reflect.DeepEqual(hosts, exp) {
+			t.Error(spew.Sprintf("Bad hosts.\nExpected: %v\nGot: %v", exp, hosts))
+		}
+	}
+
+	check(`(label "ext" (host "external.org") (host "1.2.3.4"))`,
+		map[string][]string{
+			"1.2.3.4":      {"ext"},
+			"external.org": {"ext"},
+		})
+
+	check(`(label "ext" (host "external.org"))
+	(label "b" "ext")`, map[string][]string{"external.org": {"b", "ext"}})
+
+	check("", map[string][]string{})
+}
+
 func TestSort(t *testing.T) {
 	spew := spew.NewDefaultConfig()
 	spew.MaxDepth = 2
@@ -369,8 +498,7 @@ func TestSort(t *testing.T) {
 (define MasterCount 3)
 (define WorkerCount 1)
 (makeList MasterCount (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
-(makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-(define AdminACL (list))`))
+(makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))`))
 	err := conn.Transact(func(view db.Database) error {
 		machines := view.SelectFromMachine(func(m db.Machine) bool {
 			return m.Role == db.Master
@@ -398,8 +526,7 @@ func TestSort(t *testing.T) {
 (define MasterCount 2)
 (define WorkerCount 1)
 (makeList MasterCount (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
-(makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-(define AdminACL (list))`))
+(makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))`))
 	err = conn.Transact(func(view db.Database) error {
 		machines := view.SelectFromMachine(func(m db.Machine) bool {
 			return m.Role == db.Master
@@ -427,8 +554,7 @@ func TestSort(t *testing.T) {
 (define MasterCount 1)
 (define WorkerCount 1)
 (makeList MasterCount (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
-(makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
-(define AdminACL (list))`))
+(makeList WorkerCount (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))`))
 	err = conn.Transact(func(view db.Database) error {
 		machines := view.SelectFromMachine(func(m db.Machine) bool {
 			return m.Role == db.Master
@@ -464,7 +590,7 @@ func TestACLs(t *testing.T) {
 (define WorkerCount 1)
 (makeList MasterCount (machine (provider "AmazonSpot") (role "Master")))
 (makeList WorkerCount (machine (provider "AmazonSpot") (role "Worker")))
-(define AdminACL (list "1.2.3.4/32" "local"))`
+(user "plaintext" "key" "1.2.3.4/32" "local")`
 
 	myIP = func() (string, error) {
 		return "5.6.7.8", nil
@@ -545,6 +671,157 @@ func TestACLs(t *testing.T) {
 	}
 }
 
+func TestAdministrators(t *testing.T) {
+	conn := db.New()
+
+	check := func(code string, expKeys []string, expNames ...string) {
+		UpdatePolicy(conn, prog(t, code))
+		conn.Transact(func(view db.Database) error {
+			var names []string
+			for _, a := range view.SelectFromAdministrator(nil) {
+				names = append(names, a.Name)
+			}
+			sort.Strings(names)
+
+			if !reflect.DeepEqual(names, expNames) {
+				t.Errorf("bad administrators: %v, expected %v", names, expNames)
+			}
+
+			for _, m := range view.SelectFromMachine(nil) {
+				if !reflect.DeepEqual(m.SSHKeys, expKeys) {
+					t.Errorf("bad SSH keys: %v, expected %v", m.SSHKeys,
+						expKeys)
+				}
+			}
+			return nil
+		})
+	}
+
+	machines := `
+(define Namespace "Namespace")
+(machine (provider "AmazonSpot") (size "m4.large") (role "Master"))
+(machine (provider "AmazonSpot") (size "m4.large") (role "Worker"))`
+
+	check(machines+`
+(label "admins" (user "plaintext" "a" "1.2.3.4/32") (user "plaintext" "b"))`,
+		[]string{"a", "b"}, "", "")
+
+	check(machines+`(user "plaintext" "b")`, []string{"b"}, "")
+	check(machines, nil)
+
+	// The ACLs of users replaced AdminACL, so it isn't silently ignored.
+	err := UpdatePolicy(conn, prog(t, machines+`(define AdminACL (list "local"))`))
+	if err == nil || !strings.Contains(err.Error(), "AdminACL") {
+		t.Errorf("expected an AdminACL error, got %v", err)
+	}
+}
+
+func TestPublicPorts(t *testing.T) {
+	conn := db.New()
+
+	check := func(code string, exp []db.PortRange) {
+		UpdatePolicy(conn, prog(t, code))
+		conn.Transact(func(view db.Database) error {
+			clusters := view.SelectFromCluster(nil)
+			if len(clusters) != 1 {
+				t.Errorf("bad clusters: %v", clusters)
+				return nil
+			}
+
+			if !reflect.DeepEqual(clusters[0].PublicPorts, exp) {
+				t.Errorf("bad public ports: %v, expected %v",
+					clusters[0].PublicPorts, exp)
+			}
+			return nil
+		})
+	}
+
+	base := `
+(define Namespace "Namespace")
+(machine (provider "AmazonSpot") (size "m4.large") (role "Master"))
+(label "a" (docker "alpine"))
+(label "b" (docker "alpine"))`
+
+	check(base+`
+(connect 80 "public" "a")
+(connect 80 "public" "b")
+(connect (list 10000 20000) "public" "b")
+(connect (tcp 22) "public" "a")
+(connect (icmp) "public" "a")
+(connect 443 "a" "public")
+(connect 8080 "a" "b")`,
+		[]db.PortRange{
+			{Protocol: "tcp", MinPort: 22, MaxPort: 22},
+			{Protocol: "tcp", MinPort: 80, MaxPort: 80},
+			{Protocol: "udp", MinPort: 80, MaxPort: 80},
+			{Protocol: "tcp", MinPort: 10000, MaxPort: 20000},
+			{Protocol: "udp", MinPort: 10000, MaxPort: 20000},
+		})
+
+	check(base+`(connect (udp 53) "public" "a")`,
+		[]db.PortRange{{Protocol: "udp", MinPort: 53, MaxPort: 53}})
+	check(base, nil)
+}
+
+func TestMultipleClusters(t *testing.T) {
+	conn := db.New()
+
+	spec := func(namespace string, workers int) string {
+		return fmt.Sprintf(`
+(define Namespace "%s")
+(machine (provider "AmazonSpot") (size "m4.large") (role "Master"))
+(makeList %d (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))`,
+			namespace, workers)
+	}
+
+	// Returns the number of machines in each namespace.
+	check := func() map[string]int {
+		counts := map[string]int{}
+		conn.Transact(func(view db.Database) error {
+			for _, c := range view.SelectFromCluster(nil) {
+				counts[c.Namespace] = len(view.SelectFromMachine(
+					func(m db.Machine) bool {
+						return m.ClusterID == c.ID
+					}))
+			}
+			return nil
+		})
+		return counts
+	}
+
+	if err := UpdatePolicy(conn, prog(t, spec("staging", 1))); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdatePolicy(conn, prog(t, spec("production", 3))); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]int{"staging": 2, "production": 4}
+	if counts := check(); !reflect.DeepEqual(counts, exp) {
+		t.Errorf("bad clusters: %v, expected %v", counts, exp)
+	}
+
+	// Updating one cluster leaves the other alone.
+	UpdatePolicy(conn, prog(t, spec("staging", 2)))
+	exp = map[string]int{"staging": 3, "production": 4}
+	if counts := check(); !reflect.DeepEqual(counts, exp) {
+		t.Errorf("bad clusters: %v, expected %v", counts, exp)
+	}
+
+	PruneClusters(conn, []string{"production"})
+	exp = map[string]int{"production": 4}
+	if counts := check(); !reflect.DeepEqual(counts, exp) {
+		t.Errorf("bad clusters: %v, expected %v", counts, exp)
+	}
+
+	conn.Transact(func(view db.Database) error {
+		if machines := view.SelectFromMachine(nil); len(machines) != 4 {
+			t.Errorf("staging machines weren't removed: %v", machines)
+		}
+		return nil
+	})
+}
+
 func prog(t *testing.T, code string) dsl.Dsl {
 	var sc scanner.Scanner
 	result, err := dsl.New(*sc.Init(strings.NewReader(code)), []string{})
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"text/scanner"
+	"time"
+
+	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/dsl"
+)
+
+// The number of spec versions kept for each namespace.
+const historyLength = 100
+
+// UpdatePolicyFrom is UpdatePolicy for a spec loaded from the file 'source', which
+// is recorded in the spec history.
+func UpdatePolicyFrom(conn db.Conn, spec dsl.Dsl, source string) error {
+	return updatePolicy(conn, spec, db.SpecVersion{Source: source})
+}
+
+// Rollback redeploys the spec recorded as 'version' to its namespace.
+func Rollback(conn db.Conn, version int) error {
+	versions := conn.SelectFromSpecVersion(func(v db.SpecVersion) bool {
+		return v.Version == version
+	})
+	if len(versions) != 1 {
+		return fmt.Errorf("unknown spec version: %d", version)
+	}
+	target := versions[0]
+
+	// The recorded spec is already evaluated, so there's nothing to import.
+	var sc scanner.Scanner
+	spec, err := dsl.New(*sc.Init(strings.NewReader(target.Spec)), []string{})
+	if err != nil {
+		return err
+	}
+
+	return updatePolicy(conn, spec, db.SpecVersion{
+		Source:   target.Source,
+		Rollback: target.Version,
+	})
+}
+
+// RolledBack returns whether the namespace of 'spec' was rolled back since 'spec'
+// was last deployed to it.  The controller leaves such rollbacks in place until
+// the spec changes.
+func RolledBack(conn db.Conn, spec dsl.Dsl) bool {
+	namespace := spec.QueryString("Namespace")
+	versions := db.SortSpecVersions(conn.SelectFromSpecVersion(
+		func(v db.SpecVersion) bool {
+			return v.Namespace == namespace
+		}))
+
+	if len(versions) == 0 || versions[len(versions)-1].Rollback == 0 {
+		return false
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Rollback == 0 {
+			return versions[i].Spec == spec.String()
+		}
+	}
+	return false
+}
+
+// recordVersion adds 'spec' to the history of 'namespace', and forgets the oldest
+// versions of the namespace beyond the historyLength most recent.
+func recordVersion(view db.Database, namespace, spec string, origin db.SpecVersion) {
+	latest := 0
+	for _, v := range view.SelectFromSpecVersion(nil) {
+		if v.Version > latest {
+			latest = v.Version
+		}
+	}
+
+	version := view.InsertSpecVersion()
+	version.Version = latest + 1
+	version.Namespace = namespace
+	version.Time = time.Now()
+	version.Source = origin.Source
+	version.Rollback = origin.Rollback
+	version.Spec = spec
+	view.Commit(version)
+
+	versions := db.SortSpecVersions(view.SelectFromSpecVersion(
+		func(v db.SpecVersion) bool {
+			return v.Namespace == namespace
+		}))
+	for i := 0; i < len(versions)-historyLength; i++ {
+		view.Remove(versions[i])
+	}
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/NetSys/di/db"
+)
+
+func TestHistory(t *testing.T) {
+	conn := db.New()
+
+	spec := func(image string) string {
+		return fmt.Sprintf(`(define Namespace "ns")
+		(label "web" (docker "%s"))`, image)
+	}
+
+	deploy := func(code string) {
+		if err := UpdatePolicyFrom(conn, prog(t, code), "config.spec"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkSpec := func(exp string) {
+		var clusters []db.Cluster
+		conn.ReadTransact(func(view db.Database) error {
+			clusters = view.SelectFromCluster(nil)
+			return nil
+		})
+		if len(clusters) != 1 || clusters[0].Spec != prog(t, exp).String() {
+			t.Errorf("Bad cluster: %v, expected spec %s", clusters, exp)
+		}
+	}
+
+	deploy(spec("v1"))
+	deploy(spec("v2"))
+	deploy(spec("v2"))
+	checkSpec(spec("v2"))
+
+	versions := db.SortSpecVersions(conn.SelectFromSpecVersion(nil))
+	if len(versions) != 2 || versions[0].Version != 1 ||
+		versions[1].Version != 2 || versions[1].Source != "config.spec" {
+		t.Fatalf("Bad history: %v", versions)
+	}
+
+	if RolledBack(conn, prog(t, spec("v2"))) {
+		t.Error("Unexpected rollback before rolling back")
+	}
+
+	if err := Rollback(conn, 1); err != nil {
+		t.Fatal(err)
+	}
+	checkSpec(spec("v1"))
+
+	versions = db.SortSpecVersions(conn.SelectFromSpecVersion(nil))
+	if len(versions) != 3 || versions[2].Rollback != 1 ||
+		versions[2].Source != "config.spec" {
+		t.Errorf("Bad history after rollback: %v", versions)
+	}
+
+	// The rollback holds until the spec changes.
+	if !RolledBack(conn, prog(t, spec("v2"))) {
+		t.Error("Expected the rollback to hold for an unchanged spec")
+	}
+	if RolledBack(conn, prog(t, spec("v3"))) {
+		t.Error("Expected a new spec to override the rollback")
+	}
+
+	if err := Rollback(conn, 7); err == nil ||
+		err.Error() != "unknown spec version: 7" {
+		t.Errorf("Expected an unknown version error, got: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"text/scanner"
+
+	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/dsl"
+)
+
+// A Plan describes the changes that applying a spec would make to a deployment.
+type Plan struct {
+	BootMachines      []db.Machine
+	TerminateMachines []db.Machine
+
+	AddContainers    []db.Container
+	RemoveContainers []db.Container
+
+	AddConnections    []db.Connection
+	RemoveConnections []db.Connection
+}
+
+// MakePlan computes the Plan that UpdatePolicy would carry out if it were called
+// with 'spec'.  It only reads from 'conn', so the database is left untouched.
+//
+// The controller doesn't track containers and connections directly, instead it
+// hands the spec to the minions.  Thus they are planned by replaying the
+// currently deployed spec into a scratch database, just as the master minion
+// would, and then diffing that against the new spec.
+func MakePlan(conn db.Conn, spec dsl.Dsl) (Plan, error) {
+	namespace := spec.QueryString("Namespace")
+	if namespace == "" {
+		return Plan{}, fmt.Errorf("policy must specify a 'Namespace'")
+	}
+
+	var plan Plan
+	var deployed string
+	conn.ReadTransact(func(view db.Database) error {
+		clusterID := 0
+		clusters := view.SelectFromCluster(func(c db.Cluster) bool {
+			return c.Namespace == namespace
+		})
+		if len(clusters) > 0 {
+			clusterID = clusters[0].ID
+			deployed = clusters[0].Spec
+		}
+
+		_, boot, terminate := joinMachines(view, spec, clusterID)
+		for _, m := range boot {
+			plan.BootMachines = append(plan.BootMachines, m.(db.Machine))
+		}
+		for _, m := range terminate {
+			plan.TerminateMachines = append(plan.TerminateMachines,
+				m.(db.Machine))
+		}
+		return nil
+	})
+
+	scratch := db.New()
+	if deployed != "" {
+		var sc scanner.Scanner
+		old, err := dsl.New(*sc.Init(strings.NewReader(deployed)), []string{})
+		if err != nil {
+			return Plan{}, fmt.Errorf("failed to parse deployed spec: %s", err)
+		}
+
+		scratch.Transact(func(view db.Database) error {
+			UpdateContainers(view, old)
+			UpdateConnections(view, old)
+			return nil
+		})
+	}
+
+	scratch.ReadTransact(func(view db.Database) error {
+		_, add, remove := joinContainers(view.SelectFromContainer(nil), spec)
+		for _, dslc := range add {
+			plan.AddContainers = append(plan.AddContainers,
+				fillContainer(db.Container{}, dslc.(*dsl.Container)))
+		}
+		for _, dbc := range remove {
+			plan.RemoveContainers = append(plan.RemoveContainers,
+				dbc.(db.Container))
+		}
+
+		// Connections always pair up, but a pair whose fields differ replaces
+		// one connection with another.
+		pairs, add, remove := joinConnections(view.SelectFromConnection(nil),
+			spec)
+		for _, pair := range pairs {
+			dbc := pair.R.(db.Connection)
+			if fillConnection(dbc, pair.L.(dsl.Connection)) != dbc {
+				add = append(add, pair.L)
+				remove = append(remove, dbc)
+			}
+		}
+		for _, dslc := range add {
+			plan.AddConnections = append(plan.AddConnections,
+				fillConnection(db.Connection{}, dslc.(dsl.Connection)))
+		}
+		for _, dbc := range remove {
+			plan.RemoveConnections = append(plan.RemoveConnections,
+				dbc.(db.Connection))
+		}
+		return nil
+	})
+
+	return plan, nil
+}
+
+// Empty returns true if carrying out 'p' wouldn't change anything.
+func (p Plan) Empty() bool {
+	return len(p.BootMachines) == 0 && len(p.TerminateMachines) == 0 &&
+		len(p.AddContainers) == 0 && len(p.RemoveContainers) == 0 &&
+		len(p.AddConnections) == 0 && len(p.RemoveConnections) == 0
+}
+
+func (p Plan) String() string {
+	if p.Empty() {
+		return "No changes.\n"
+	}
+
+	var lines []string
+	for _, m := range p.BootMachines {
+		lines = append(lines, "+ "+m.String())
+	}
+	for _, m := range p.TerminateMachines {
+		lines = append(lines, "- "+m.String())
+	}
+	for _, c := range p.AddContainers {
+		lines = append(lines, "+ "+c.String())
+	}
+	for _, c := range p.RemoveContainers {
+		lines = append(lines, "- "+c.String())
+	}
+	for _, c := range p.AddConnections {
+		lines = append(lines, "+ "+c.String())
+	}
+	for _, c := range p.RemoveConnections {
+		lines = append(lines, "- "+c.String())
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
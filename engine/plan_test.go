@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/NetSys/di/db"
+)
+
+func TestPlan(t *testing.T) {
+	conn := db.New()
+
+	code := `
+(define Namespace "Namespace")
+(makeList 1 (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
+(makeList 2 (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
+(label "a" (makeList 2 (docker "alpine")))
+(connect 80 "a" "a")`
+
+	plan, err := MakePlan(conn, prog(t, code))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.BootMachines) != 3 || len(plan.TerminateMachines) != 0 ||
+		len(plan.AddContainers) != 2 || len(plan.RemoveContainers) != 0 ||
+		len(plan.AddConnections) != 1 || len(plan.RemoveConnections) != 0 {
+		t.Errorf("bad plan: %s", plan)
+	}
+
+	// Planning must not modify the database.
+	conn.Transact(func(view db.Database) error {
+		if machines := view.SelectFromMachine(nil); len(machines) != 0 {
+			t.Errorf("unexpected machines: %v", machines)
+		}
+		return nil
+	})
+
+	UpdatePolicy(conn, prog(t, code))
+	plan, err = MakePlan(conn, prog(t, code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() {
+		t.Errorf("expected an empty plan: %s", plan)
+	}
+
+	code = `
+(define Namespace "Namespace")
+(makeList 1 (machine (provider "AmazonSpot") (size "m4.large") (role "Master")))
+(makeList 1 (machine (provider "AmazonSpot") (size "m4.large") (role "Worker")))
+(label "a" (docker "alpine"))
+(label "b" (docker "ubuntu"))
+(connect 80 "a" "b")`
+	plan, err = MakePlan(conn, prog(t, code))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.BootMachines) != 0 || len(plan.TerminateMachines) != 1 ||
+		len(plan.AddContainers) != 1 || len(plan.RemoveContainers) != 1 ||
+		len(plan.AddConnections) != 1 || len(plan.RemoveConnections) != 1 {
+		t.Errorf("bad plan: %s", plan)
+	}
+
+	if plan.AddContainers[0].Image != "ubuntu" ||
+		plan.RemoveContainers[0].Image != "alpine" {
+		t.Errorf("bad container plan: %s", plan)
+	}
+
+	if _, err := MakePlan(conn, prog(t, `(label "a" (docker "alpine"))`)); err == nil {
+		t.Error("expected an error for a spec without a Namespace")
+	}
+}
@@ -8,6 +8,7 @@ type Node struct {
 type Connection struct {
 	From *Node
 	To   *Node
+	Deny bool
 }
 
 type Graph struct {
@@ -35,11 +36,15 @@ func (g Graph) getNode(label string) *Node {
 	return &node
 }
 
-func (g *Graph) addConnection(from string, to string) {
+func (g *Graph) addConnection(from string, to string, deny bool) {
 	fromNode := g.getNode(from)
 	toNode := g.getNode(to)
-	fromNode.Connections[to] = toNode
-	g.Connections = append(g.Connections, Connection{From: fromNode, To: toNode})
+	// Denied edges are drawn, but can't be used to reach other nodes.
+	if !deny {
+		fromNode.Connections[to] = toNode
+	}
+	g.Connections = append(g.Connections,
+		Connection{From: fromNode, To: toNode, Deny: deny})
 }
 
 // find all nodes reachable from the given node
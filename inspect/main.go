@@ -50,7 +50,7 @@ func main() {
 
 	graph := makeGraph()
 	for _, conn := range spec.QueryConnections() {
-		graph.addConnection(conn.From, conn.To)
+		graph.addConnection(conn.From, conn.To, conn.Deny)
 	}
 
 	slug := ""
@@ -45,14 +45,19 @@ func Graphviz(slug string, graph Graph, containerLabels map[string][]*dsl.Contai
 	}()
 
 	dotfile := "strict digraph {\n"
-	fmt_string := "    %s -> %s\n"
+	fmt_string := "    %s -> %s%s\n"
 
 	for _, edge := range graph.Connections {
+		attrs := ""
+		if edge.Deny {
+			attrs = " [color=red, style=dashed]"
+		}
 		dotfile +=
 			fmt.Sprintf(
 				fmt_string,
 				getImageNamesForLabel(containerLabels, string(edge.From.Name)),
 				getImageNamesForLabel(containerLabels, string(edge.To.Name)),
+				attrs,
 			)
 	}
 
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
 	"strings"
 	"time"
 
@@ -37,6 +38,10 @@ const (
 
 	// SchedulerLabelPair is the key/value pair, used by the scheduler.
 	SchedulerLabelPair = SchedulerLabelKey + "=" + SchedulerLabelValue
+
+	// HealthCheckLabel holds the JSON encoded health check of a container, so
+	// that the worker running the container knows how to probe it.
+	HealthCheckLabel = systemLabelPrefix + "HealthCheck"
 )
 
 var errNoSuchContainer = errors.New("container does not exist")
@@ -52,6 +57,11 @@ type Container struct {
 	Pid    int
 	Env    map[string]string
 	Labels map[string]string
+	Binds  []string
+	Node   string // The swarm node running the container, if any.
+
+	Memory    int64 // In bytes.
+	CPUShares int64
 }
 
 // A Client to the local docker daemon.
@@ -59,6 +69,7 @@ type Client interface {
 	Run(opts RunOptions) error
 	Exec(name string, cmd ...string) error
 	ExecVerbose(name string, cmd ...string) ([]byte, []byte, error)
+	ExecStatus(id string, cmd ...string) (int, error)
 	Remove(name string) error
 	RemoveID(id string) error
 	Pull(image string) error
@@ -66,6 +77,7 @@ type Client interface {
 	Get(id string) (Container, error)
 	WriteToContainer(id, src, dst, archiveName string, permission int) error
 	GetFromContainer(id string, src string) (string, error)
+	GetFileFromContainer(id string, src string) (string, int, error)
 }
 
 // RunOptions changes the behavior of the Run function.
@@ -81,6 +93,9 @@ type RunOptions struct {
 	PidMode     string
 	Privileged  bool
 	VolumesFrom []string
+
+	Memory    int64 // In bytes.
+	CPUShares int64
 }
 
 type pullRequest struct {
@@ -147,18 +162,22 @@ func (dk docker) Run(opts RunOptions) error {
 		}
 	}
 
-	id, err := dk.create(opts.Name, opts.Image, opts.Args, opts.Labels, opts.Env)
-	if err != nil {
-		return err
-	}
-
 	hc := dkc.HostConfig{
 		Binds:       opts.Binds,
 		NetworkMode: opts.NetworkMode,
 		PidMode:     opts.PidMode,
 		Privileged:  opts.Privileged,
 		VolumesFrom: opts.VolumesFrom,
+		Memory:      opts.Memory,
+		CPUShares:   opts.CPUShares,
+	}
+
+	id, err := dk.create(opts.Name, opts.Image, opts.Args, opts.Labels, opts.Env,
+		&hc)
+	if err != nil {
+		return err
 	}
+
 	if err = dk.StartContainer(id, &hc); err != nil {
 		if _, ok := err.(*dkc.ContainerAlreadyRunning); ok {
 			return nil
@@ -210,6 +229,35 @@ func (dk docker) ExecVerbose(name string, cmd ...string) ([]byte, []byte, error)
 	return outBuff.Bytes(), outBuff.Bytes(), nil
 }
 
+// ExecStatus runs 'cmd' in the container with ID 'id', and returns its exit status.
+func (dk docker) ExecStatus(id string, cmd ...string) (int, error) {
+	exec, err := dk.CreateExec(dkc.CreateExecOptions{
+		Container:    id,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Attaching to the output makes StartExec block until 'cmd' exits.
+	err = dk.StartExec(exec.ID, dkc.StartExecOptions{
+		OutputStream: ioutil.Discard,
+		ErrorStream:  ioutil.Discard,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	inspect, err := dk.InspectExec(exec.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	return inspect.ExitCode, nil
+}
+
 // WriteToContainer writes the contents of SRC into the file at path DST on the
 // container with id ID. Overwrites DST if it already exists.
 func (dk docker) WriteToContainer(id, src, dst, archiveName string, permission int) error {
@@ -234,6 +282,13 @@ func (dk docker) WriteToContainer(id, src, dst, archiveName string, permission i
 // GetFromContainer returns a string containing the content of the file named
 // SRC on the container with id ID.
 func (dk docker) GetFromContainer(id string, src string) (string, error) {
+	content, _, err := dk.GetFileFromContainer(id, src)
+	return content, err
+}
+
+// GetFileFromContainer returns the content of the file named SRC on the container
+// with id ID, along with its permission bits.
+func (dk docker) GetFileFromContainer(id string, src string) (string, int, error) {
 	var buffIn bytes.Buffer
 	var buffOut bytes.Buffer
 	err := dk.DownloadFromContainer(id, dkc.DownloadFromContainerOptions{
@@ -241,24 +296,27 @@ func (dk docker) GetFromContainer(id string, src string) (string, error) {
 		Path:         src,
 	})
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	writer := io.Writer(&buffOut)
 
-	for tr := tar.NewReader(&buffIn); err != io.EOF; _, err = tr.Next() {
-
+	mode := 0
+	tr := tar.NewReader(&buffIn)
+	for hdr, err := tr.Next(); err != io.EOF; hdr, err = tr.Next() {
 		if err != nil {
-			return "", err
+			return "", 0, err
 		}
 
+		mode = int(hdr.Mode & 0777)
+
 		_, err = io.Copy(writer, tr)
 		if err != nil {
-			return "", err
+			return "", 0, err
 		}
 	}
 
-	return buffOut.String(), nil
+	return buffOut.String(), mode, nil
 }
 
 func (dk docker) Remove(name string) error {
@@ -334,7 +392,7 @@ func (dk docker) Get(id string) (Container, error) {
 		}
 	}
 
-	return Container{
+	container := Container{
 		Name:   c.Name,
 		ID:     c.ID,
 		IP:     c.NetworkSettings.IPAddress,
@@ -344,11 +402,24 @@ func (dk docker) Get(id string) (Container, error) {
 		Pid:    c.State.Pid,
 		Env:    env,
 		Labels: c.Config.Labels,
-	}, nil
+	}
+
+	if c.Node != nil {
+		container.Node = c.Node.Name
+	}
+
+	if c.HostConfig != nil {
+		container.Binds = c.HostConfig.Binds
+		container.Memory = c.HostConfig.Memory
+		container.CPUShares = c.HostConfig.CPUShares
+	}
+
+	return container, nil
 }
 
 func (dk docker) create(name, image string, args []string,
-	labels map[string]string, env map[string]struct{}) (string, error) {
+	labels map[string]string, env map[string]struct{},
+	hc *dkc.HostConfig) (string, error) {
 	if err := dk.Pull(image); err != nil {
 		return "", err
 	}
@@ -364,8 +435,9 @@ func (dk docker) create(name, image string, args []string,
 	}
 
 	container, err := dk.CreateContainer(dkc.CreateContainerOptions{
-		Name:   name,
-		Config: &dkc.Config{Image: string(image), Cmd: args, Labels: labels, Env: envList},
+		Name:       name,
+		Config:     &dkc.Config{Image: string(image), Cmd: args, Labels: labels, Env: envList},
+		HostConfig: hc,
 	})
 	if err != nil {
 		return "", err
@@ -22,6 +22,7 @@ func main() {
 	conn := db.New()
 	dk := docker.New("unix:///var/run/docker.sock")
 	go minionServerRun(conn)
+	go runUpdates(conn)
 	go supervisor.Run(conn, dk)
 	go scheduler.Run(conn)
 
@@ -0,0 +1,182 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/minion/consensus"
+	"github.com/NetSys/di/minion/docker"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The number of consecutive failed probes after which a container is unhealthy.
+const unhealthyThreshold = 3
+
+// Probes that haven't finished within probeTimeout are considered failed.
+const probeTimeout = 5 * time.Second
+
+// healthRun probes the containers running on this worker according to their health
+// checks.  The results are recorded in the container table, where they're used to
+// pull unhealthy containers out of load balancing, and in the consensus store so
+// that the master can replace them.
+func healthRun(conn db.Conn, store consensus.Store, dk docker.Client) {
+	failures := map[string]int{}
+	reported := map[string]string{}
+	for range conn.TriggerTick(10, db.MinionTable).C {
+		minions := conn.SelectFromMinion(nil)
+		if len(minions) != 1 || minions[0].Role != db.Worker {
+			continue
+		}
+
+		containers := conn.SelectFromContainer(func(dbc db.Container) bool {
+			return dbc.SchedID != "" && dbc.IP != "" &&
+				dbc.HealthCheck.Type != ""
+		})
+
+		results := probeAll(dk, containers)
+		health := updateHealth(failures, containers, results)
+
+		conn.Transact(func(view db.Database) error {
+			for _, dbc := range view.SelectFromContainer(nil) {
+				if h, ok := health[dbc.SchedID]; ok && h != dbc.Health {
+					dbc.Health = h
+					view.Commit(dbc)
+				}
+			}
+			return nil
+		})
+
+		writeHealth(store, reported, health)
+	}
+}
+
+// probeAll runs the health checks of 'containers' in parallel and returns whether
+// each passed, keyed by SchedID.
+func probeAll(dk docker.Client, containers []db.Container) map[string]bool {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]bool{}
+
+	wg.Add(len(containers))
+	for _, dbc := range containers {
+		dbc := dbc
+		go func() {
+			defer wg.Done()
+
+			done := make(chan error, 1)
+			go func() { done <- probe(dk, dbc) }()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(probeTimeout):
+				err = fmt.Errorf("timed out")
+			}
+
+			if err != nil {
+				log.WithError(err).WithField("container", dbc.SchedID).Debug(
+					"Health check failed.")
+			}
+
+			lock.Lock()
+			results[dbc.SchedID] = err == nil
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+func probe(dk docker.Client, dbc db.Container) error {
+	check := dbc.HealthCheck
+	timeout := strconv.Itoa(int(probeTimeout / time.Second))
+	port := strconv.Itoa(check.Port)
+	namespace := networkNS(dbc.SchedID)
+
+	switch check.Type {
+	case "tcp":
+		return probeExec(namespace, "nc", "-z", "-w", timeout, dbc.IP, port)
+	case "http":
+		url := fmt.Sprintf("http://%s:%s%s", dbc.IP, port, check.Path)
+		return probeExec(namespace, "wget", "-q", "-T", timeout, "-O", "/dev/null",
+			url)
+	case "exec":
+		status, err := dk.ExecStatus(dbc.SchedID, check.Command...)
+		if err != nil {
+			return err
+		}
+		if status != 0 {
+			return fmt.Errorf("exit status %d", status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown health check type: %s", check.Type)
+	}
+}
+
+// probeExec runs a command in the network namespace 'namespace'.  Stored in a
+// variable so we can mock it out for the unit tests.
+var probeExec = func(namespace string, args ...string) error {
+	args = append([]string{"netns", "exec", namespace}, args...)
+	return exec.Command("ip", args...).Run()
+}
+
+// updateHealth folds the probe 'results' into the count of consecutive 'failures'
+// of each container, and returns the resulting health of 'containers'.
+func updateHealth(failures map[string]int, containers []db.Container,
+	results map[string]bool) map[string]string {
+	health := map[string]string{}
+	for _, dbc := range containers {
+		id := dbc.SchedID
+		switch {
+		case results[id]:
+			failures[id] = 0
+			health[id] = db.Healthy
+		case failures[id]+1 >= unhealthyThreshold:
+			failures[id] = unhealthyThreshold
+			health[id] = db.Unhealthy
+		default:
+			failures[id]++
+			health[id] = dbc.Health
+		}
+	}
+
+	// Forget about containers that no longer exist.
+	for id := range failures {
+		if _, ok := health[id]; !ok {
+			delete(failures, id)
+		}
+	}
+
+	return health
+}
+
+// writeHealth publishes 'health' to the consensus store.  'reported' tracks what
+// was written previously, so only changes are written.
+func writeHealth(store consensus.Store, reported map[string]string,
+	health map[string]string) {
+	for id, h := range health {
+		if h == "" || reported[id] == h {
+			continue
+		}
+
+		path := fmt.Sprintf("%s/%s/Health", containerDir, id)
+		if err := store.Set(path, h); err != nil {
+			log.WithError(err).Warn("Failed to write container health.")
+			continue
+		}
+		reported[id] = h
+	}
+
+	for id := range reported {
+		if _, ok := health[id]; !ok {
+			delete(reported, id)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package network
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/NetSys/di/db"
+)
+
+func TestUpdateHealth(t *testing.T) {
+	failures := map[string]int{}
+	containers := []db.Container{{SchedID: "a"}, {SchedID: "b"}}
+
+	check := func(results map[string]bool, exp map[string]string) {
+		health := updateHealth(failures, containers, results)
+		if !reflect.DeepEqual(health, exp) {
+			t.Errorf("Wrong health.\nExpected:\n%v\n\nGot:\n%v\n", exp, health)
+		}
+
+		for i := range containers {
+			containers[i].Health = health[containers[i].SchedID]
+		}
+	}
+
+	check(map[string]bool{"a": true}, map[string]string{"a": db.Healthy, "b": ""})
+
+	// A single failure doesn't make a container unhealthy.
+	check(map[string]bool{}, map[string]string{"a": db.Healthy, "b": ""})
+	check(map[string]bool{}, map[string]string{"a": db.Healthy, "b": db.Unhealthy})
+	check(map[string]bool{}, map[string]string{"a": db.Unhealthy, "b": db.Unhealthy})
+
+	// But a single success makes it healthy again.
+	check(map[string]bool{"b": true},
+		map[string]string{"a": db.Unhealthy, "b": db.Healthy})
+
+	containers = containers[1:]
+	check(map[string]bool{"b": true}, map[string]string{"b": db.Healthy})
+	if _, ok := failures["a"]; ok {
+		t.Error("Failures of a removed container weren't forgotten.")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	oldProbeExec := probeExec
+	defer func() { probeExec = oldProbeExec }()
+
+	var cmd []string
+	probeExec = func(namespace string, args ...string) error {
+		cmd = append([]string{namespace}, args...)
+		return errors.New("failed")
+	}
+
+	dbc := db.Container{
+		SchedID:     "0123456789abcdef",
+		IP:          "10.1.2.3",
+		HealthCheck: db.HealthCheck{Type: "tcp", Port: 80},
+	}
+	if err := probe(nil, dbc); err == nil {
+		t.Error("Expected probe to fail.")
+	}
+
+	exp := []string{"0123456789abc_ns", "nc", "-z", "-w", "5", "10.1.2.3", "80"}
+	if !reflect.DeepEqual(cmd, exp) {
+		t.Errorf("Wrong probe.\nExpected:\n%v\n\nGot:\n%v\n", exp, cmd)
+	}
+
+	dbc.HealthCheck = db.HealthCheck{Type: "http", Port: 8080, Path: "/health"}
+	probe(nil, dbc)
+	exp = []string{"0123456789abc_ns", "wget", "-q", "-T", "5", "-O", "/dev/null",
+		"http://10.1.2.3:8080/health"}
+	if !reflect.DeepEqual(cmd, exp) {
+		t.Errorf("Wrong probe.\nExpected:\n%v\n\nGot:\n%v\n", exp, cmd)
+	}
+}
@@ -28,9 +28,10 @@ const gatewayMAC = "02:00:0a:00:00:01"
 func Run(conn db.Conn, store consensus.Store, dk docker.Client) {
 	go readStoreRun(conn, store)
 	go writeStoreRun(conn, store)
+	go healthRun(conn, store, dk)
 
 	for range conn.TriggerTick(5, db.MinionTable, db.ContainerTable,
-		db.ConnectionTable, db.LabelTable, db.EtcdTable).C {
+		db.ConnectionTable, db.LabelTable, db.HostTable, db.EtcdTable).C {
 		runWorker(conn, dk)
 		runMaster(conn)
 	}
@@ -45,7 +46,7 @@ func runMaster(conn db.Conn) {
 	var labels []db.Label
 	var containers []db.Container
 	var connections []db.Connection
-	conn.Transact(func(view db.Database) error {
+	conn.ReadTransact(func(view db.Database) error {
 		etcds = view.SelectFromEtcd(nil)
 
 		labels = view.SelectFromLabel(func(label db.Label) bool {
@@ -167,19 +168,34 @@ func updateACLs(connections []db.Connection, labels []db.Label,
 	}
 
 	matchSet := map[string]struct{}{}
+	denySet := map[string]struct{}{}
 	for _, conn := range connections {
+		// Connections to the public internet and external hosts don't have a
+		// logical port, so there's no ACL to write.  Containers reach them
+		// through the default gateway on the di-int bridge of their worker,
+		// and that traffic never enters the logical switch.  Instead, it's
+		// restricted by the worker's OpenFlow rules, which only forward the
+		// allowed ports and addresses to the gateway, where it's NATed.
+		toIP := labelIPMap[conn.To]
+		if toIP == "" {
+			continue
+		}
+
 		for _, fromDbc := range labelDbcMap[conn.From] {
 			fromIP := fromDbc.IP
-			toIP := labelIPMap[conn.To]
-			min := conn.MinPort
-			max := conn.MaxPort
 
-			match := fmt.Sprintf("ip4.src==%s && ip4.dst==%s && "+
-				"(icmp || %d <= udp.dst <= %d || %[3]d <= tcp.dst <= %[4]d)",
-				fromIP, toIP, min, max)
-			reverse := fmt.Sprintf("ip4.src==%s && ip4.dst==%s && "+
-				"(icmp || %d <= udp.src <= %d || %[3]d <= tcp.src <= %[4]d)",
-				toIP, fromIP, min, max)
+			match := fmt.Sprintf("ip4.src==%s && ip4.dst==%s && %s",
+				fromIP, toIP, protocolMatch(conn, "dst"))
+
+			// Dropping the initiator's packets is enough to deny a
+			// connection, so the replies are left alone.
+			if conn.Deny {
+				denySet[match] = struct{}{}
+				continue
+			}
+
+			reverse := fmt.Sprintf("ip4.src==%s && ip4.dst==%s && %s",
+				toIP, fromIP, protocolMatch(conn, "src"))
 
 			matchSet[match] = struct{}{}
 			matchSet[reverse] = struct{}{}
@@ -216,6 +232,23 @@ func updateACLs(connections []db.Connection, labels []db.Label,
 			})
 	}
 
+	// Denies take precedence over the allows.
+	for match := range denySet {
+		acls = append(acls,
+			ovsdb.Acl{
+				Priority:  2,
+				Direction: "to-lport",
+				Action:    "drop",
+				Match:     match,
+			},
+			ovsdb.Acl{
+				Priority:  2,
+				Direction: "from-lport",
+				Action:    "drop",
+				Match:     match,
+			})
+	}
+
 	_, lonelyACLS, lonelyOVS := join.Join(acls, ovsdbACLs,
 		func(left, right interface{}) int {
 			acl := left.(ovsdb.Acl)
@@ -248,3 +281,19 @@ func updateACLs(connections []db.Connection, labels []db.Label,
 		}
 	}
 }
+
+// protocolMatch returns the part of an ACL match that restricts traffic to the
+// protocol and ports of `conn`.  `field` is "dst" for traffic from the
+// connection's initiator, and "src" for the replies.
+func protocolMatch(conn db.Connection, field string) string {
+	min, max := conn.MinPort, conn.MaxPort
+	switch conn.Protocol {
+	case "icmp":
+		return "icmp"
+	case "tcp", "udp":
+		return fmt.Sprintf("%d <= %s.%s <= %d", min, conn.Protocol, field, max)
+	default:
+		return fmt.Sprintf("(icmp || %d <= udp.%s <= %d || "+
+			"%[1]d <= tcp.%[2]s <= %[3]d)", min, field, max)
+	}
+}
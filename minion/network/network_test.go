@@ -295,6 +295,62 @@ func TestACL(t *testing.T) {
 				Priority: 1,
 			}},
 		true)
+
+	// Test denying one member of a label that's otherwise allowed
+	var expACLs []ovsdb.Acl
+	for _, ip := range []string{redContainerIP, blueContainerIP} {
+		for _, dir := range []string{"to-lport", "from-lport"} {
+			expACLs = append(expACLs,
+				ovsdb.Acl{Direction: dir,
+					Match:    fmt.Sprintf(matchFmt, ip, yellowLabelIP, 80, 80),
+					Action:   "allow",
+					Priority: 1,
+				},
+				ovsdb.Acl{Direction: dir,
+					Match:    fmt.Sprintf(reverseFmt, yellowLabelIP, ip, 80, 80),
+					Action:   "allow",
+					Priority: 1,
+				})
+		}
+	}
+	for _, dir := range []string{"to-lport", "from-lport"} {
+		expACLs = append(expACLs, ovsdb.Acl{Direction: dir,
+			Match: fmt.Sprintf("ip4.src==%s && ip4.dst==%s && "+
+				"80 <= tcp.dst <= 80", redContainerIP, yellowLabelIP),
+			Action:   "drop",
+			Priority: 2,
+		})
+	}
+	checkAcl([]db.Connection{
+		{From: "redBlue",
+			To:      "yellow",
+			MinPort: 80,
+			MaxPort: 80},
+		{From: "red",
+			To:       "yellow",
+			Protocol: "tcp",
+			MinPort:  80,
+			MaxPort:  80,
+			Deny:     true}},
+		allLabels,
+		allContainers,
+		expACLs,
+		true)
+}
+
+func TestProtocolMatch(t *testing.T) {
+	check := func(protocol string, field, exp string) {
+		conn := db.Connection{Protocol: protocol, MinPort: 80, MaxPort: 81}
+		if res := protocolMatch(conn, field); res != exp {
+			t.Errorf("bad %q match: %s, expected %s", protocol, res, exp)
+		}
+	}
+
+	check("", "dst", "(icmp || 80 <= udp.dst <= 81 || 80 <= tcp.dst <= 81)")
+	check("", "src", "(icmp || 80 <= udp.src <= 81 || 80 <= tcp.src <= 81)")
+	check("tcp", "dst", "80 <= tcp.dst <= 81")
+	check("udp", "src", "80 <= udp.src <= 81")
+	check("icmp", "dst", "icmp")
 }
 
 type ACLList []ovsdb.Acl
@@ -325,7 +381,8 @@ func (lst ACLList) Less(i, j int) bool {
 // Because we only use a single switch, this ovsdb mock assumes that all configuration
 // changes apply to the same switch.
 type fakeOvsdb struct {
-	acls []ovsdb.Acl
+	acls    []ovsdb.Acl
+	ofPorts map[string]int // The OpenFlow port numbers of the interfaces.
 }
 
 func (odb *fakeOvsdb) CreateACL(lswitch string, dir string, priority int,
@@ -396,6 +453,9 @@ func (odb *fakeOvsdb) DeleteOFPort(bridge, name string) error {
 }
 
 func (odb *fakeOvsdb) GetOFPortNo(name string) (int, error) {
+	if port, ok := odb.ofPorts[name]; ok {
+		return port, nil
+	}
 	return -1, nil
 }
 
@@ -94,8 +94,10 @@ func readContainerTransact(view db.Database, dir directory) {
 	for _, container := range view.SelectFromContainer(nil) {
 		container.IP = ""
 		var labels []string
+		var files []db.File
 		if children, ok := dir[container.SchedID]; ok {
 			json.Unmarshal([]byte(children["Labels"]), &labels)
+			json.Unmarshal([]byte(children["Files"]), &files)
 
 			container.IP = children["IP"]
 			ip := net.ParseIP(container.IP).To4()
@@ -106,9 +108,14 @@ func readContainerTransact(view db.Database, dir directory) {
 		}
 
 		if worker {
-			// Masters get their labels from the policy, workers from the
-			// consensus store.
+			// Masters get their labels and files from the policy, workers
+			// from the consensus store.
 			container.Labels = labels
+			container.Files = files
+		} else {
+			// Workers probe the health of their own containers, masters
+			// learn it from the consensus store.
+			container.Health = dir[container.SchedID]["Health"]
 		}
 
 		view.Commit(container)
@@ -181,6 +188,7 @@ func writeStoreContainers(store consensus.Store, containers []db.Container) erro
 	syncDir(store, dir, containerDir, ids)
 	syncIPs(store, dir, containerDir, net.IPv4(10, 0, 0, 0))
 	syncLabels(store, dir, containerDir, containers)
+	syncFiles(store, dir, containerDir, containers)
 
 	return nil
 }
@@ -395,6 +403,42 @@ func syncLabels(store consensus.Store, dir directory, path string,
 	}
 }
 
+func syncFiles(store consensus.Store, dir directory, path string,
+	containers []db.Container) {
+
+	idFileMap := map[string][]db.File{}
+	for _, container := range containers {
+		if container.SchedID != "" {
+			idFileMap[container.SchedID] = container.Files
+		}
+	}
+
+	for id, children := range dir {
+		// The policy already sorts files by path.
+		files := idFileMap[id]
+		if files == nil {
+			files = []db.File{}
+		}
+
+		jsByte, err := json.Marshal(files)
+		if err != nil {
+			panic("Not Reached")
+		}
+		js := string(jsByte)
+
+		if js == children["Files"] {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s/Files", path, id)
+		if err := store.Set(key, js); err != nil {
+			log.WithField("path", path).Error("Failed to set file key.")
+			continue
+		}
+		dir[id]["Files"] = js
+	}
+}
+
 func getDirectory(store consensus.Store, path string) (directory, error) {
 	tree, err := store.GetTree(path)
 	if err != nil {
@@ -36,7 +36,8 @@ func testReadContainerTransact(t *testing.T, view db.Database) {
 	view.Commit(container)
 
 	dir := directory(map[string]map[string]string{
-		"a": {"IP": "1.0.0.0", "Labels": `["e"]`},
+		"a": {"IP": "1.0.0.0", "Labels": `["e"]`,
+			"Files": `[{"Path":"/x","Content":"y","Mode":420}]`},
 		"b": {"IP": "2.0.0.0", "Labels": `["e", "f"]`},
 	})
 
@@ -44,9 +45,11 @@ func testReadContainerTransact(t *testing.T, view db.Database) {
 
 	ipMap := map[string]string{}
 	labelMap := map[string][]string{}
+	fileMap := map[string][]db.File{}
 	for _, c := range view.SelectFromContainer(nil) {
 		ipMap[c.SchedID] = c.IP
 		labelMap[c.SchedID] = c.Labels
+		fileMap[c.SchedID] = c.Files
 	}
 
 	expIPMap := map[string]string{
@@ -67,6 +70,15 @@ func testReadContainerTransact(t *testing.T, view db.Database) {
 	if !eq(labelMap, expLabelMap) {
 		t.Error(spew.Sprintf("Found %s, Expected: %s", ipMap, expIPMap))
 	}
+
+	expFileMap := map[string][]db.File{
+		"a": {{Path: "/x", Content: "y", Mode: 0644}},
+		"b": nil,
+		"c": nil,
+	}
+	if !eq(fileMap, expFileMap) {
+		t.Error(spew.Sprintf("Found %s, Expected: %s", fileMap, expFileMap))
+	}
 }
 
 func TestReadLabelTransact(t *testing.T) {
@@ -166,6 +178,46 @@ func TestSyncLabels(t *testing.T) {
 	}
 }
 
+func TestSyncFiles(t *testing.T) {
+	store := consensus.NewMock()
+	store.Mkdir("/test/a")
+	store.Mkdir("/test/b")
+	dir, _ := getDirectory(store, "/test")
+
+	containers := []db.Container{
+		{SchedID: "a", Files: []db.File{{Path: "/x", Content: "y", Mode: 0644}}},
+		{SchedID: "b"},
+	}
+
+	syncFiles(store, dir, "/test", containers)
+	newDir, _ := getDirectory(store, "/test")
+	if !eq(dir, newDir) {
+		t.Error(spew.Sprintf("syncFiles did not update dir.\n"+
+			"Found %s\nExpected %s", dir, newDir))
+	}
+
+	expDir := directory(map[string]map[string]string{
+		"a": {"Files": `[{"Path":"/x","Content":"y","Mode":420}]`},
+		"b": {"Files": "[]"},
+	})
+	if !eq(dir, expDir) {
+		t.Error(spew.Sprintf("syncFiles Found %s\nExpected %s", dir, expDir))
+	}
+
+	containers[0].Files[0].Mode = 0600
+	syncFiles(store, dir, "/test", containers)
+	newDir, _ = getDirectory(store, "/test")
+	if !eq(dir, newDir) {
+		t.Error(spew.Sprintf("syncFiles did not update dir.\n"+
+			"Found %s\nExpected %s", dir, newDir))
+	}
+
+	expDir["a"]["Files"] = `[{"Path":"/x","Content":"y","Mode":384}]`
+	if !eq(dir, expDir) {
+		t.Error(spew.Sprintf("syncFiles Found %s\nExpected %s", dir, expDir))
+	}
+}
+
 func TestSyncDir(t *testing.T) {
 	store := consensus.NewMock()
 	store.Mkdir("/test")
@@ -3,6 +3,7 @@ package network
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -12,6 +13,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/NetSys/di/db"
 	"github.com/NetSys/di/dsl"
@@ -84,6 +87,23 @@ type OFRule struct {
 	actions string
 }
 
+// An inclusive range of ports.
+type portRange struct {
+	min, max int
+}
+
+// A range of ports of a single transport protocol.
+type protoPorts struct {
+	protocol string
+	portRange
+}
+
+// An extHost is the resolved form of a db.Host.
+type extHost struct {
+	hostname string   // Empty if the host was given as an IP or CIDR.
+	nets     []string // Formatted as nw_dst in `ovs-ofctl dump-flows`.
+}
+
 // Query the database for any running containers and for each container running on this
 // host, do the following:
 //    - Create a pair of virtual interfaces for the container if it's new and
@@ -126,7 +146,8 @@ func runWorker(conn db.Conn, dk docker.Client) {
 	var labels []db.Label
 	var containers []db.Container
 	var connections []db.Connection
-	conn.Transact(func(view db.Database) error {
+	var dbHosts []db.Host
+	conn.ReadTransact(func(view db.Database) error {
 		containers = view.SelectFromContainer(func(c db.Container) bool {
 			return c.SchedID != "" && c.IP != "" && c.Mac != ""
 		})
@@ -134,8 +155,10 @@ func runWorker(conn db.Conn, dk docker.Client) {
 			return l.IP != ""
 		})
 		connections = view.SelectFromConnection(nil)
+		dbHosts = view.SelectFromHost(nil)
 		return nil
 	})
+	hosts := resolver.resolveHosts(dbHosts)
 
 	updateNamespaces(containers)
 	updateVeths(containers)
@@ -145,14 +168,15 @@ func runWorker(conn db.Conn, dk docker.Client) {
 	}
 	if exists, err := linkExists("", diBridge); exists {
 		updateDefaultGw()
-		updateOpenFlow(dk, containers, labels, connections)
+		updateOpenFlow(dk, containers, labels, connections, hosts)
 	} else if err != nil {
 		log.WithError(err).Error("failed to check if link exists")
 	}
 	updateNameservers(dk, containers)
 	updateContainerIPs(containers, labels)
 	updateRoutes(containers)
-	updateEtcHosts(dk, containers, labels, connections)
+	updateEtcHosts(dk, containers, labels, connections, hosts)
+	updateFiles(dk, containers)
 	updateLoopback(containers)
 }
 
@@ -436,10 +460,9 @@ func generateTargetNatRules(containers []db.Container, connections []db.Connecti
 		"-A POSTROUTING -s 10.0.0.0/8 -o eth0 -j MASQUERADE",
 	}
 
-	protocols := []string{"tcp", "udp"}
 	// Map each container IP to all ports on which it can receive packets
 	// from the public internet.
-	portsFromWeb := make(map[string]map[int]struct{})
+	portsFromWeb := make(map[string]map[protoPorts]struct{})
 
 	for _, dbc := range containers {
 		for _, conn := range connections {
@@ -455,22 +478,29 @@ func generateTargetNatRules(containers []db.Container, connections []db.Connecti
 				}
 
 				if _, ok := portsFromWeb[dbc.IP]; !ok {
-					portsFromWeb[dbc.IP] = make(map[int]struct{})
+					portsFromWeb[dbc.IP] = make(map[protoPorts]struct{})
 				}
 
-				portsFromWeb[dbc.IP][conn.MinPort] = struct{}{}
+				for _, pp := range connProtoPorts(conn) {
+					portsFromWeb[dbc.IP][pp] = struct{}{}
+				}
 			}
 		}
 	}
 
-	// Map the container's port to the same port of the host.
+	// Map the container's ports to the same ports of the host.
 	for ip, ports := range portsFromWeb {
-		for port := range ports {
-			for _, protocol := range protocols {
-				strRules = append(strRules, fmt.Sprintf("-A PREROUTING -i eth0 "+
-					"-p %s -m %s --dport %d -j DNAT --to-destination %s:%d",
-					protocol, protocol, port, ip, port))
+		for pp := range ports {
+			dport := fmt.Sprintf("%d", pp.min)
+			dest := fmt.Sprintf("%s:%d", ip, pp.min)
+			if pp.min != pp.max {
+				dport = fmt.Sprintf("%d:%d", pp.min, pp.max)
+				dest = fmt.Sprintf("%s:%d-%d", ip, pp.min, pp.max)
 			}
+
+			strRules = append(strRules, fmt.Sprintf("-A PREROUTING -i eth0 "+
+				"-p %s -m %s --dport %s -j DNAT --to-destination %s",
+				pp.protocol, pp.protocol, dport, dest))
 		}
 	}
 
@@ -885,8 +915,9 @@ func generateCurrentRoutes(namespace string) ([]route, error) {
 // XXX: The multipath action doesn't perform well.  We should migrate away from it
 // choosing datapath recirculation instead.
 func updateOpenFlow(dk docker.Client, containers []db.Container, labels []db.Label,
-	connections []db.Connection) {
-	targetOF, err := generateTargetOpenFlow(dk, containers, labels, connections)
+	connections []db.Connection, hosts map[string][]extHost) {
+	targetOF, err := generateTargetOpenFlow(dk, containers, labels, connections,
+		hosts)
 	if err != nil {
 		log.WithError(err).Error("failed to get target OpenFlow flows")
 		return
@@ -958,7 +989,8 @@ func generateCurrentOpenFlow(dk docker.Client) ([]OFRule, error) {
 // dump-flows. To achieve this, we have some rather ugly hacks that handle
 // a few special cases.
 func generateTargetOpenFlow(dk docker.Client, containers []db.Container,
-	labels []db.Label, connections []db.Connection) ([]OFRule, error) {
+	labels []db.Label, connections []db.Connection,
+	hosts map[string][]extHost) ([]OFRule, error) {
 
 	dflGatewayMAC, err := getMac("", diBridge)
 	if err != nil {
@@ -1000,56 +1032,96 @@ func generateTargetOpenFlow(dk docker.Client, containers []db.Container,
 				"actions=output:%d", 0, ofVeth, ofDI),
 		}...)
 
-		protocols := []string{"tcp", "udp"}
-
-		portsToWeb := make(map[int]struct{})
-		portsFromWeb := make(map[int]struct{})
+		// Whether the container may speak to anything outside the cluster, and
+		// whether it may use ICMP to do so.
+		external := false
+		icmp := false
+		portsToWeb := make(map[protoPorts]struct{})
+		portsFromWeb := make(map[protoPorts]struct{})
+		portsToHosts := make(map[string]map[protoPorts]struct{}) // Keyed by nw_dst.
 		for _, l := range dbc.Labels {
 			for _, conn := range connections {
+				ports := connProtoPorts(conn)
+				allowsICMP := conn.Protocol == "" || conn.Protocol == "icmp"
 				if conn.From == l && conn.To == dsl.PublicInternetLabel {
-					portsToWeb[conn.MinPort] = struct{}{}
+					external = true
+					icmp = icmp || allowsICMP
+					for _, pp := range ports {
+						portsToWeb[pp] = struct{}{}
+					}
 				} else if conn.From == dsl.PublicInternetLabel && conn.To == l {
-					portsFromWeb[conn.MinPort] = struct{}{}
+					external = true
+					icmp = icmp || allowsICMP
+					for _, pp := range ports {
+						portsFromWeb[pp] = struct{}{}
+					}
+				} else if conn.From == l {
+					for _, h := range hosts[conn.To] {
+						external = true
+						icmp = icmp || allowsICMP
+						for _, nw := range h.nets {
+							if _, ok := portsToHosts[nw]; !ok {
+								portsToHosts[nw] = make(map[protoPorts]struct{})
+							}
+							for _, pp := range ports {
+								portsToHosts[nw][pp] = struct{}{}
+							}
+						}
+					}
 				}
 			}
 		}
 
 		// LOCAL is the default di-int port created with the bridge.
 		egressRule := fmt.Sprintf("table=0 priority=%d,in_port=%d,", 5000, ofVeth) +
-			"%s,%s," + fmt.Sprintf("dl_dst=%s actions=LOCAL", dflGatewayMAC)
+			"%s," + fmt.Sprintf("dl_dst=%s actions=LOCAL", dflGatewayMAC)
 		ingressRule := fmt.Sprintf("table=0 priority=%d,in_port=LOCAL,", 5000) +
-			"%s,%s," + fmt.Sprintf("dl_dst=%s actions=%d", dbcMac, ofVeth)
-
-		for port := range portsFromWeb {
-			for _, protocol := range protocols {
-				egressPort := fmt.Sprintf("tp_src=%d", port)
-				rules = append(rules, fmt.Sprintf(egressRule, protocol, egressPort))
+			"%s," + fmt.Sprintf("dl_dst=%s actions=%d", dbcMac, ofVeth)
 
-				ingressPort := fmt.Sprintf("tp_dst=%d", port)
-				rules = append(rules, fmt.Sprintf(ingressRule, protocol, ingressPort))
+		for pp := range portsFromWeb {
+			for _, m := range ofPortMatches(pp.protocol, "tp_src", pp.portRange) {
+				rules = append(rules, fmt.Sprintf(egressRule, m))
+			}
+			for _, m := range ofPortMatches(pp.protocol, "tp_dst", pp.portRange) {
+				rules = append(rules, fmt.Sprintf(ingressRule, m))
 			}
 		}
 
-		for port := range portsToWeb {
-			for _, protocol := range protocols {
-				egressPort := fmt.Sprintf("tp_dst=%d", port)
-				rules = append(rules, fmt.Sprintf(egressRule, protocol, egressPort))
+		for pp := range portsToWeb {
+			for _, m := range ofPortMatches(pp.protocol, "tp_dst", pp.portRange) {
+				rules = append(rules, fmt.Sprintf(egressRule, m))
+			}
+			for _, m := range ofPortMatches(pp.protocol, "tp_src", pp.portRange) {
+				rules = append(rules, fmt.Sprintf(ingressRule, m))
+			}
+		}
 
-				ingressPort := fmt.Sprintf("tp_src=%d", port)
-				rules = append(rules, fmt.Sprintf(ingressRule, protocol, ingressPort))
+		// Unlike the public internet, external hosts may only be reached at
+		// their own addresses.
+		for nw, ports := range portsToHosts {
+			for pp := range ports {
+				for _, m := range ofPortMatches(pp.protocol, "tp_dst", pp.portRange) {
+					rules = append(rules, fmt.Sprintf(egressRule,
+						fmt.Sprintf("%s,nw_dst=%s", m, nw)))
+				}
+				for _, m := range ofPortMatches(pp.protocol, "tp_src", pp.portRange) {
+					rules = append(rules, fmt.Sprintf(ingressRule,
+						fmt.Sprintf("%s,nw_src=%s", m, nw)))
+				}
 			}
 		}
 
-		var arpDst string
-		if len(portsToWeb) > 0 || len(portsFromWeb) > 0 {
-			// Allow ICMP
+		if icmp {
 			rules = append(rules,
 				fmt.Sprintf("table=0 priority=%d,icmp,in_port=%d,dl_dst=%s"+
 					" actions=LOCAL", 5000, ofVeth, dflGatewayMAC))
 			rules = append(rules,
 				fmt.Sprintf("table=0 priority=%d,icmp,in_port=LOCAL,dl_dst=%s"+
 					" actions=output:%d", 5000, dbcMac, ofVeth))
+		}
 
+		var arpDst string
+		if external {
 			arpDst = fmt.Sprintf("%d,LOCAL", ofDI)
 		} else {
 			arpDst = fmt.Sprintf("%d", ofDI)
@@ -1069,6 +1141,11 @@ func generateTargetOpenFlow(dk docker.Client, containers []db.Container,
 
 	LabelMacs := make(map[string]map[string]struct{})
 	for _, dbc := range containers {
+		// Unhealthy containers are left out of load balancing.
+		if dbc.Health == db.Unhealthy {
+			continue
+		}
+
 		for _, l := range dbc.Labels {
 			if _, ok := LabelMacs[l]; !ok {
 				LabelMacs[l] = make(map[string]struct{})
@@ -1136,6 +1213,51 @@ func generateTargetOpenFlow(dk docker.Client, containers []db.Container,
 	return targetRules, nil
 }
 
+// connProtoPorts returns the tcp and udp ports opened by 'conn'.  Connections
+// without a protocol open both.
+func connProtoPorts(conn db.Connection) []protoPorts {
+	pr := portRange{conn.MinPort, conn.MaxPort}
+	switch conn.Protocol {
+	case "":
+		return []protoPorts{{"tcp", pr}, {"udp", pr}}
+	case "tcp", "udp":
+		return []protoPorts{{conn.Protocol, pr}}
+	default:
+		return nil
+	}
+}
+
+// ofPortMatches returns OpenFlow matches on 'protocol' that together cover the
+// ports 'pr' of 'field'.  OpenFlow can't match on ranges directly, so the range is
+// split into blocks of ports that share a prefix, and each block is matched with a
+// mask.  The matches are formatted as in the output of `ovs-ofctl dump-flows`.
+func ofPortMatches(protocol, field string, pr portRange) []string {
+	var matches []string
+	for port := pr.min; port <= pr.max; {
+		// Grow the block for as long as it's aligned and within the range.
+		size := 1
+		for size < 1<<16 && port%(size*2) == 0 && port+size*2-1 <= pr.max {
+			size *= 2
+		}
+
+		mask := 0xffff &^ (size - 1)
+		switch mask {
+		case 0xffff:
+			matches = append(matches, fmt.Sprintf("%s,%s=%d", protocol, field,
+				port))
+		case 0:
+			// A match on every port is no match at all.
+			matches = append(matches, protocol)
+		default:
+			matches = append(matches, fmt.Sprintf("%s,%s=0x%x/0x%x", protocol,
+				field, port, mask))
+		}
+
+		port += size
+	}
+	return matches
+}
+
 // updateNameservers assigns each container the same nameservers as the host.
 func updateNameservers(dk docker.Client, containers []db.Container) {
 	hostResolv, err := ioutil.ReadFile("/etc/resolv.conf")
@@ -1166,7 +1288,7 @@ func updateNameservers(dk docker.Client, containers []db.Container) {
 }
 
 func updateEtcHosts(dk docker.Client, containers []db.Container, labels []db.Label,
-	connections []db.Connection) {
+	connections []db.Connection, hosts map[string][]extHost) {
 
 	labelIP := make(map[string]string) /* Map label name to its IP. */
 	conns := make(map[string][]string) /* Map label to a list of all labels it connect to. */
@@ -1176,7 +1298,8 @@ func updateEtcHosts(dk docker.Client, containers []db.Container, labels []db.Lab
 	}
 
 	for _, conn := range connections {
-		if conn.To == dsl.PublicInternetLabel || conn.From == dsl.PublicInternetLabel {
+		if conn.To == dsl.PublicInternetLabel || conn.From == dsl.PublicInternetLabel ||
+			conn.Deny {
 			continue
 		}
 		conns[conn.From] = append(conns[conn.From], conn.To)
@@ -1191,7 +1314,7 @@ func updateEtcHosts(dk docker.Client, containers []db.Container, labels []db.Lab
 			return
 		}
 
-		newHosts := generateEtcHosts(dbc, labelIP, conns)
+		newHosts := generateEtcHosts(dbc, labelIP, conns, hosts)
 
 		if newHosts != currHosts {
 			err = dk.WriteToContainer(id, newHosts, "/etc", "hosts", 0644)
@@ -1202,8 +1325,34 @@ func updateEtcHosts(dk docker.Client, containers []db.Container, labels []db.Lab
 	}
 }
 
+// updateFiles writes the files specified for each container whose contents differ
+// from those in the container.  The files are written in place, so the containers
+// keep running.
+func updateFiles(dk docker.Client, containers []db.Container) {
+	for _, dbc := range containers {
+		for _, file := range dbc.Files {
+			// A file that can't be read probably doesn't exist yet.
+			curr, mode, err := dk.GetFileFromContainer(dbc.SchedID,
+				file.Path)
+			if err == nil && curr == file.Content && mode == file.Mode {
+				continue
+			}
+
+			// Docker creates missing parent directories when extracting the
+			// archive at the root.
+			name := strings.TrimPrefix(file.Path, "/")
+			err = dk.WriteToContainer(dbc.SchedID, file.Content, "/", name,
+				file.Mode)
+			if err != nil {
+				log.WithError(err).WithField("path", file.Path).Error(
+					"Failed to update file")
+			}
+		}
+	}
+}
+
 func generateEtcHosts(dbc db.Container, labelIP map[string]string,
-	conns map[string][]string) string {
+	conns map[string][]string, external map[string][]extHost) string {
 
 	type entry struct {
 		ip, host string
@@ -1236,6 +1385,17 @@ func generateEtcHosts(dbc db.Container, labelIP map[string]string,
 			if ip := labelIP[toLabel]; ip != "" {
 				newHosts[entry{ip, toLabel + ".di"}] = struct{}{}
 			}
+
+			// Pin external hostnames to the addresses the OpenFlow rules
+			// allow, in case DNS answers differently inside the container.
+			for _, h := range external[toLabel] {
+				if h.hostname == "" {
+					continue
+				}
+				for _, ip := range h.nets {
+					newHosts[entry{ip, h.hostname}] = struct{}{}
+				}
+			}
 		}
 	}
 
@@ -1248,6 +1408,184 @@ func generateEtcHosts(dbc db.Container, labelIP map[string]string,
 	return strings.Join(hosts, "\n") + "\n"
 }
 
+// lookupIP resolves hostnames.  Stored in a variable so we can mock it out for
+// the unit tests.
+var lookupIP = net.LookupIP
+
+const (
+	// How long a resolved hostname is trusted.  The resolver doesn't report the
+	// TTLs of the records it returns, so they're all kept for the same period.
+	hostTTL = 5 * time.Minute
+
+	// How long to wait before retrying a hostname that failed to resolve.
+	hostRetry = 30 * time.Second
+
+	// How long a lookup may take before it's considered failed.
+	lookupTimeout = 10 * time.Second
+)
+
+// The hosts resolved by runWorker.
+var resolver = newHostResolver()
+
+// A hostResolver resolves the hostnames of external hosts in the background, so
+// that a slow DNS server doesn't hold up the worker.
+type hostResolver struct {
+	sync.Mutex
+	cache map[string]*cachedHost
+
+	lookups sync.WaitGroup // The lookups in progress.
+}
+
+type cachedHost struct {
+	host     extHost
+	resolved bool // Whether the hostname has ever resolved.
+	expiry   time.Time
+	pending  bool
+}
+
+func newHostResolver() *hostResolver {
+	return &hostResolver{cache: map[string]*cachedHost{}}
+}
+
+// resolveHosts maps each label to the external hosts it applies to.  Hostnames are
+// looked up in the background when they aren't cached or their addresses expire,
+// and the addresses they last resolved to are used in the meantime.  Hosts that
+// have never resolved are left out, so containers can't reach them until they do.
+func (r *hostResolver) resolveHosts(hosts []db.Host) map[string][]extHost {
+	r.Lock()
+	defer r.Unlock()
+
+	result := make(map[string][]extHost)
+	seen := map[string]struct{}{}
+	for _, h := range hosts {
+		seen[h.Hostname] = struct{}{}
+		resolved, ok, err := parseHost(h.Hostname)
+		if !ok {
+			resolved, ok = r.lookup(h.Hostname)
+		}
+
+		if err != nil {
+			log.WithError(err).WithField("host", h.Hostname).Warn(
+				"Failed to resolve host.")
+			continue
+		} else if !ok {
+			continue
+		}
+
+		for _, l := range h.Labels {
+			result[l] = append(result[l], resolved)
+		}
+	}
+
+	// Forget the hosts that were removed from the spec.
+	for hostname := range r.cache {
+		if _, ok := seen[hostname]; !ok {
+			delete(r.cache, hostname)
+		}
+	}
+	return result
+}
+
+// lookup returns the cached addresses of 'hostname', if it has any, and refreshes
+// them if they've expired.  The caller must hold the lock.
+func (r *hostResolver) lookup(hostname string) (extHost, bool) {
+	c := r.cache[hostname]
+	if c == nil {
+		c = &cachedHost{}
+		r.cache[hostname] = c
+	}
+
+	if !c.pending && !time.Now().Before(c.expiry) {
+		c.pending = true
+		r.lookups.Add(1)
+		go r.refresh(hostname, c)
+	}
+	return c.host, c.resolved
+}
+
+func (r *hostResolver) refresh(hostname string, c *cachedHost) {
+	defer r.lookups.Done()
+	host, err := lookupHost(hostname)
+
+	r.Lock()
+	defer r.Unlock()
+
+	c.pending = false
+	if err != nil {
+		log.WithError(err).WithField("host", hostname).Warn(
+			"Failed to resolve host.")
+		c.expiry = time.Now().Add(hostRetry)
+		return
+	}
+
+	c.host = host
+	c.resolved = true
+	c.expiry = time.Now().Add(hostTTL)
+}
+
+// parseHost parses hosts given as an IP address or CIDR block, which need no
+// lookup.  Returns false if 'hostname' is neither.
+func parseHost(hostname string) (extHost, bool, error) {
+	if _, ipnet, err := net.ParseCIDR(hostname); err == nil {
+		if ipnet.IP.To4() == nil {
+			return extHost{}, true, fmt.Errorf("not an IPv4 network")
+		}
+
+		// dump-flows leaves the prefix length off of single addresses.
+		if ones, _ := ipnet.Mask.Size(); ones == 32 {
+			return extHost{nets: []string{ipnet.IP.String()}}, true, nil
+		}
+		return extHost{nets: []string{ipnet.String()}}, true, nil
+	}
+
+	if ip := net.ParseIP(hostname); ip != nil {
+		if ip.To4() == nil {
+			return extHost{}, true, fmt.Errorf("not an IPv4 address")
+		}
+		return extHost{nets: []string{ip.String()}}, true, nil
+	}
+	return extHost{}, false, nil
+}
+
+// lookupHost resolves 'hostname' to its IPv4 addresses.
+func lookupHost(hostname string) (extHost, error) {
+	type answer struct {
+		ips []net.IP
+		err error
+	}
+
+	answers := make(chan answer, 1)
+	go func() {
+		ips, err := lookupIP(hostname)
+		answers <- answer{ips, err}
+	}()
+
+	var ans answer
+	select {
+	case ans = <-answers:
+	case <-time.After(lookupTimeout):
+		return extHost{}, errors.New("lookup timed out")
+	}
+
+	if ans.err != nil {
+		return extHost{}, ans.err
+	}
+
+	var nets []string
+	for _, ip := range ans.ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			nets = append(nets, ip4.String())
+		}
+	}
+
+	if len(nets) == 0 {
+		return extHost{}, fmt.Errorf("no IPv4 addresses")
+	}
+
+	sort.Strings(nets)
+	return extHost{hostname: hostname, nets: nets}, nil
+}
+
 func namespaceExists(namespace string) (bool, error) {
 	nsFullPath := fmt.Sprintf("%s/%s", nsPath, namespace)
 	file, err := os.Lstat(nsFullPath)
@@ -1,11 +1,16 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/minion/docker"
+	"github.com/NetSys/di/ovsdb"
 )
 
 func TestNoConnections(t *testing.T) {
@@ -17,7 +22,7 @@ func TestNoConnections(t *testing.T) {
 		Labels:  []string{"green"},
 	}
 
-	actual := generateEtcHosts(dbc, labels, connections)
+	actual := generateEtcHosts(dbc, labels, connections, nil)
 	exp := "1.1.1.1         abcdefghijkl" + localhosts()
 
 	if exp != actual {
@@ -35,7 +40,7 @@ func TestImplementsSingleLabel(t *testing.T) {
 		Labels:  []string{"red"},
 	}
 
-	actual := generateEtcHosts(dbc, labels, connections)
+	actual := generateEtcHosts(dbc, labels, connections, nil)
 	exp := `1.1.1.1         abcdefghijkl
 10.0.0.2        blue.di
 10.0.0.3        green.di` + localhosts()
@@ -55,7 +60,7 @@ func TestImplementsMultipleLabels(t *testing.T) {
 		Labels:  []string{"red", "blue"},
 	}
 
-	actual := generateEtcHosts(dbc, labels, connections)
+	actual := generateEtcHosts(dbc, labels, connections, nil)
 	exp := `1.1.1.1         abcdefghijkl
 10.0.0.1        red.di
 10.0.0.2        blue.di
@@ -79,7 +84,7 @@ func TestDuplicateConnections(t *testing.T) {
 
 	connections["blue"] = append(connections["blue"], "green")
 
-	actual := generateEtcHosts(dbc, labels, connections)
+	actual := generateEtcHosts(dbc, labels, connections, nil)
 	exp := `1.1.1.1         abcdefghijkl
 10.0.0.1        red.di
 10.0.0.2        blue.di
@@ -92,6 +97,143 @@ func TestDuplicateConnections(t *testing.T) {
 	}
 }
 
+func TestEtcHostsExternal(t *testing.T) {
+	labels, connections := defaultLabelsConnections()
+	dbc := db.Container{
+		ID:      5,
+		SchedID: "abcdefghijklmnopqrstuvwxyz",
+		IP:      "1.1.1.1",
+		Labels:  []string{"blue"},
+	}
+
+	connections["blue"] = append(connections["blue"], "ext")
+	hosts := map[string][]extHost{"ext": {
+		{hostname: "external.org", nets: []string{"104.16.0.1", "104.16.0.2"}},
+		{nets: []string{"9.9.9.0/24"}},
+	}}
+
+	actual := generateEtcHosts(dbc, labels, connections, hosts)
+	exp := `1.1.1.1         abcdefghijkl
+10.0.0.1        red.di
+104.16.0.1      external.org
+104.16.0.2      external.org` + localhosts()
+
+	if exp != actual {
+		t.Error(fmt.Sprintf("Generated wrong /etc/hosts for external hosts."+
+			"\nExpected:\n%s\n\nGot:\n%s\n", exp, actual))
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	oldLookupIP := lookupIP
+	defer func() { lookupIP = oldLookupIP }()
+
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host != "external.org" {
+			return nil, errors.New("no such host")
+		}
+		return []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("::1"),
+			net.ParseIP("8.8.4.4")}, nil
+	}
+
+	hosts := []db.Host{
+		{Hostname: "external.org", Labels: []string{"a", "b"}},
+		{Hostname: "1.2.3.4", Labels: []string{"a"}},
+		{Hostname: "10.1.0.0/16", Labels: []string{"b"}},
+		{Hostname: "1.2.3.4/32", Labels: []string{"c"}},
+		{Hostname: "missing.org", Labels: []string{"c"}},
+	}
+
+	// Hostnames are looked up in the background, so at first only addresses
+	// and networks resolve.
+	r := newHostResolver()
+	actual := r.resolveHosts(hosts)
+	exp := map[string][]extHost{
+		"a": {{nets: []string{"1.2.3.4"}}},
+		"b": {{nets: []string{"10.1.0.0/16"}}},
+		"c": {{nets: []string{"1.2.3.4"}}},
+	}
+	if !reflect.DeepEqual(actual, exp) {
+		t.Errorf("Resolved wrong hosts.\nExpected:\n%v\n\nGot:\n%v\n",
+			exp, actual)
+	}
+
+	r.lookups.Wait()
+	actual = r.resolveHosts(hosts)
+	external := extHost{hostname: "external.org",
+		nets: []string{"8.8.4.4", "8.8.8.8"}}
+	exp = map[string][]extHost{
+		"a": {external, {nets: []string{"1.2.3.4"}}},
+		"b": {external, {nets: []string{"10.1.0.0/16"}}},
+		"c": {{nets: []string{"1.2.3.4"}}},
+	}
+	if !reflect.DeepEqual(actual, exp) {
+		t.Errorf("Resolved wrong hosts.\nExpected:\n%v\n\nGot:\n%v\n",
+			exp, actual)
+	}
+
+	// Resolved hostnames aren't looked up again until they expire.
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host != "missing.org" {
+			t.Errorf("unexpected lookup of %s", host)
+		}
+		return nil, errors.New("no such host")
+	}
+	if actual = r.resolveHosts(hosts); !reflect.DeepEqual(actual, exp) {
+		t.Errorf("Resolved wrong hosts.\nExpected:\n%v\n\nGot:\n%v\n",
+			exp, actual)
+	}
+
+	// Hosts removed from the spec are forgotten.
+	r.lookups.Wait()
+	r.resolveHosts(nil)
+	if len(r.cache) != 0 {
+		t.Errorf("stale hosts cached: %v", r.cache)
+	}
+}
+
+func TestUpdateFiles(t *testing.T) {
+	dk := &fileDocker{files: map[string]db.File{}}
+	containers := []db.Container{{
+		SchedID: "a",
+		Files: []db.File{
+			{Path: "/etc/a.conf", Content: "a", Mode: 0644},
+			{Path: "/etc/b.conf", Content: "b", Mode: 0600},
+		},
+	}}
+
+	// Missing files are created.
+	updateFiles(dk, containers)
+	exp := map[string]db.File{
+		"a:/etc/a.conf": {Path: "etc/a.conf", Content: "a", Mode: 0644},
+		"a:/etc/b.conf": {Path: "etc/b.conf", Content: "b", Mode: 0600},
+	}
+	if !reflect.DeepEqual(dk.files, exp) || dk.writes != 2 {
+		t.Errorf("bad files after %d writes.\nExpected:\n%v\n\nGot:\n%v\n",
+			dk.writes, exp, dk.files)
+	}
+
+	// Files that are up to date are left alone.
+	dk.writes = 0
+	updateFiles(dk, containers)
+	if dk.writes != 0 {
+		t.Errorf("unchanged files written %d times", dk.writes)
+	}
+
+	// A change in either the content or the mode rewrites the file.
+	containers[0].Files[0].Content = "new"
+	containers[0].Files[1].Mode = 0644
+	updateFiles(dk, containers)
+	exp = map[string]db.File{
+		"a:/etc/a.conf": {Path: "etc/a.conf", Content: "new", Mode: 0644},
+		"a:/etc/b.conf": {Path: "etc/b.conf", Content: "b", Mode: 0644},
+	}
+	if !reflect.DeepEqual(dk.files, exp) || dk.writes != 2 {
+		t.Errorf("bad files after %d writes.\nExpected:\n%v\n\nGot:\n%v\n",
+			dk.writes, exp, dk.files)
+	}
+}
+
 func TestMakeIPRule(t *testing.T) {
 	inp := "-A INPUT -p tcp -i eth0 -m multiport --dports 465,110,995 -j ACCEPT"
 	rule, _ := makeIPRule(inp)
@@ -236,6 +378,123 @@ func TestGenerateCurrentNatRules(t *testing.T) {
 	}
 }
 
+func TestGenerateTargetNatRules(t *testing.T) {
+	containers := []db.Container{
+		{IP: "10.0.0.2", Labels: []string{"web"}},
+		{IP: "10.0.0.3", Labels: []string{"media"}},
+	}
+	connections := []db.Connection{
+		{From: "public", To: "web", MinPort: 80, MaxPort: 80},
+		{From: "public", To: "media", MinPort: 10000, MaxPort: 20000},
+		{From: "web", To: "public", MinPort: 443, MaxPort: 443},
+		{From: "public", To: "web", Protocol: "tcp", MinPort: 8080,
+			MaxPort: 8080},
+		{From: "public", To: "media", Protocol: "icmp"},
+	}
+
+	actual := map[ipRule]struct{}{}
+	for _, rule := range generateTargetNatRules(containers, connections) {
+		actual[rule] = struct{}{}
+	}
+
+	for _, r := range []string{
+		"-A PREROUTING -i eth0 -p tcp -m tcp --dport 80 " +
+			"-j DNAT --to-destination 10.0.0.2:80",
+		"-A PREROUTING -i eth0 -p udp -m udp --dport 80 " +
+			"-j DNAT --to-destination 10.0.0.2:80",
+		"-A PREROUTING -i eth0 -p tcp -m tcp --dport 10000:20000 " +
+			"-j DNAT --to-destination 10.0.0.3:10000-20000",
+		"-A PREROUTING -i eth0 -p udp -m udp --dport 10000:20000 " +
+			"-j DNAT --to-destination 10.0.0.3:10000-20000",
+		"-A PREROUTING -i eth0 -p tcp -m tcp --dport 8080 " +
+			"-j DNAT --to-destination 10.0.0.2:8080",
+	} {
+		rule, _ := makeIPRule(r)
+		if _, ok := actual[rule]; !ok {
+			t.Errorf("Missing NAT rule: %s", r)
+		}
+	}
+
+	// The default policies, MASQUERADE, and the five DNAT rules.
+	if len(actual) != 10 {
+		t.Errorf("Wrong number of NAT rules: %v", actual)
+	}
+}
+
+func TestExternalICMP(t *testing.T) {
+	id := "abcdefghijklmnopqrstuvwxyz"
+	_, vethOut := veths(id)
+	_, peerDI := patchPorts(id)
+	client := fakeOvsdb{ofPorts: map[string]int{vethOut: 1, peerDI: 2}}
+	ovsdb.Open = func() (ovsdb.Ovsdb, error) {
+		return &client, nil
+	}
+
+	containers := []db.Container{{
+		SchedID: id,
+		IP:      "10.0.0.2",
+		Mac:     "02:00:0a:00:00:02",
+		Labels:  []string{"db"},
+	}}
+
+	icmpRules := func(conn db.Connection) int {
+		rules, err := generateTargetOpenFlow(nil, containers, nil,
+			[]db.Connection{conn}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		for _, rule := range rules {
+			if strings.Contains(rule.match, "icmp") {
+				n++
+			}
+		}
+		return n
+	}
+
+	tcp := db.Connection{From: "public", To: "db", MinPort: 5432, MaxPort: 5432,
+		Protocol: "tcp"}
+	if n := icmpRules(tcp); n != 0 {
+		t.Errorf("tcp connection allowed ICMP in %d rules", n)
+	}
+
+	for _, protocol := range []string{"", "icmp"} {
+		conn := tcp
+		conn.Protocol = protocol
+		if n := icmpRules(conn); n != 2 {
+			t.Errorf("%q connection allowed ICMP in %d rules, expected 2",
+				protocol, n)
+		}
+	}
+}
+
+func TestOFPortMatches(t *testing.T) {
+	check := func(min, max int, exp ...string) {
+		actual := ofPortMatches("udp", "tp_dst", portRange{min, max})
+		if !reflect.DeepEqual(actual, exp) {
+			t.Errorf("Wrong matches for [%d, %d].\nExpected:\n%v\n\nGot:\n%v\n",
+				min, max, exp, actual)
+		}
+	}
+
+	check(80, 80, "udp,tp_dst=80")
+	check(0, 65535, "udp")
+	check(8080, 8083, "udp,tp_dst=0x1f90/0xfffc")
+	check(10000, 20000,
+		"udp,tp_dst=0x2710/0xfff0",
+		"udp,tp_dst=0x2720/0xffe0",
+		"udp,tp_dst=0x2740/0xffc0",
+		"udp,tp_dst=0x2780/0xff80",
+		"udp,tp_dst=0x2800/0xf800",
+		"udp,tp_dst=0x3000/0xf000",
+		"udp,tp_dst=0x4000/0xf800",
+		"udp,tp_dst=0x4800/0xfc00",
+		"udp,tp_dst=0x4c00/0xfe00",
+		"udp,tp_dst=0x4e00/0xffe0",
+		"udp,tp_dst=20000")
+}
+
 func TestMakeOFRule(t *testing.T) {
 	flows := []string{
 		"cookie=0x0, duration=997.526s, table=0, n_packets=0, " +
@@ -349,3 +608,32 @@ func rules() string {
 -A POSTROUTING -s 11.0.0.0/8,10.0.0.0/8 -o eth0 -j MASQUERADE
 -A POSTROUTING -s 10.0.3.0/24 ! -d 10.0.3.0/24 -j MASQUERADE`
 }
+
+// fileDocker is a docker client whose containers hold only the files written to
+// them, keyed by container ID and path.
+type fileDocker struct {
+	docker.Client
+
+	files  map[string]db.File
+	writes int
+}
+
+func (dk *fileDocker) WriteToContainer(id, src, dst, archiveName string,
+	permission int) error {
+
+	dk.writes++
+	dk.files[id+":"+dst+archiveName] = db.File{
+		Path:    archiveName,
+		Content: src,
+		Mode:    permission,
+	}
+	return nil
+}
+
+func (dk *fileDocker) GetFileFromContainer(id, src string) (string, int, error) {
+	file, ok := dk.files[id+":"+src]
+	if !ok {
+		return "", 0, errors.New("no such file")
+	}
+	return file.Content, file.Mode, nil
+}
@@ -63,6 +63,7 @@ type MinionConfig struct {
 	Role      MinionConfig_Role `protobuf:"varint,2,opt,name=role,enum=MinionConfig_Role" json:"role,omitempty"`
 	PrivateIP string            `protobuf:"bytes,3,opt,name=PrivateIP" json:"PrivateIP,omitempty"`
 	Spec      string            `protobuf:"bytes,4,opt,name=Spec" json:"Spec,omitempty"`
+	Secrets   map[string]string `protobuf:"bytes,5,rep,name=Secrets" json:"Secrets,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 }
 
 func (m *MinionConfig) Reset()                    { *m = MinionConfig{} }
@@ -70,6 +71,13 @@ func (m *MinionConfig) String() string            { return proto.CompactTextStri
 func (*MinionConfig) ProtoMessage()               {}
 func (*MinionConfig) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
+func (m *MinionConfig) GetSecrets() map[string]string {
+	if m != nil {
+		return m.Secrets
+	}
+	return nil
+}
+
 type Reply struct {
 	Success bool   `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
 	Error   string `protobuf:"bytes,2,opt,name=Error" json:"Error,omitempty"`
@@ -221,23 +229,25 @@ var _Minion_serviceDesc = grpc.ServiceDesc{
 }
 
 var fileDescriptor0 = []byte{
-	// 280 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x5c, 0x90, 0xcd, 0x4e, 0xbb, 0x40,
-	0x14, 0xc5, 0xcb, 0x47, 0x29, 0x5c, 0xe8, 0x9f, 0xfe, 0x67, 0x45, 0x58, 0x35, 0xb3, 0x91, 0x18,
-	0x33, 0x26, 0xf5, 0x09, 0xfc, 0x20, 0x86, 0x18, 0x5a, 0x02, 0x26, 0xae, 0x05, 0xaf, 0x86, 0xa4,
-	0x32, 0x38, 0x4c, 0x4d, 0x7c, 0x01, 0x7d, 0x6d, 0x87, 0x89, 0x89, 0xc5, 0xdd, 0xbd, 0x99, 0x73,
-	0xee, 0xf9, 0x9d, 0x01, 0xbf, 0xaf, 0xcf, 0xfb, 0x9a, 0xf5, 0x82, 0x4b, 0x4e, 0xbf, 0x0c, 0x08,
-	0xf2, 0xb6, 0x6b, 0x79, 0x77, 0xcd, 0xbb, 0xe7, 0xf6, 0x85, 0x00, 0x98, 0xd9, 0x4d, 0x64, 0xac,
-	0x8d, 0xc4, 0x23, 0x6b, 0xb0, 0x05, 0xdf, 0x63, 0x64, 0xaa, 0xed, 0xdf, 0x86, 0xb0, 0x63, 0x21,
-	0x2b, 0xd5, 0x0b, 0xf9, 0x0f, 0x5e, 0x21, 0xda, 0xf7, 0x47, 0x89, 0x59, 0x11, 0x59, 0xda, 0x14,
-	0x80, 0x5d, 0xf5, 0xd8, 0x44, 0xf6, 0xb8, 0xd1, 0x04, 0x6c, 0x2d, 0x74, 0xc1, 0xde, 0xee, 0xb6,
-	0xe9, 0x6a, 0xa6, 0x02, 0x9c, 0x87, 0x5d, 0x79, 0x97, 0x96, 0x2b, 0x63, 0x9c, 0xf3, 0xcb, 0xea,
-	0x5e, 0xcd, 0x26, 0x3d, 0x81, 0x79, 0x89, 0xfd, 0xfe, 0x83, 0x84, 0xb0, 0xa8, 0x0e, 0x4d, 0x83,
-	0xc3, 0xa0, 0x31, 0x5c, 0xb2, 0x84, 0x79, 0x2a, 0x04, 0x17, 0x9a, 0xc3, 0xa3, 0x1e, 0x2c, 0x4a,
-	0x7c, 0x3b, 0xe0, 0x20, 0x69, 0x0c, 0x7e, 0x2a, 0x9b, 0xa7, 0x1c, 0x5f, 0x6b, 0x14, 0x03, 0xf1,
-	0xc1, 0xca, 0x8a, 0xd1, 0x65, 0x25, 0xde, 0xe6, 0xd3, 0x50, 0xc7, 0x35, 0x30, 0x39, 0x85, 0xb0,
-	0x42, 0x39, 0xa9, 0xb9, 0x9c, 0x94, 0x89, 0x1d, 0xa6, 0xb3, 0xe9, 0x8c, 0x9c, 0x41, 0x78, 0xfb,
-	0x47, 0xeb, 0xb2, 0x9f, 0xbc, 0x78, 0xea, 0x52, 0x6a, 0x0a, 0xee, 0x15, 0xe7, 0x72, 0x84, 0x20,
-	0x01, 0x3b, 0x62, 0xf9, 0xbd, 0x58, 0x3b, 0xfa, 0xa7, 0x2f, 0xbe, 0x03, 0x00, 0x00, 0xff, 0xff,
-	0x3c, 0xf0, 0x7a, 0x3a, 0x78, 0x01, 0x00, 0x00,
+	// 320 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5d, 0x91, 0x4d, 0x4b, 0xc3, 0x40,
+	0x10, 0x86, 0xcd, 0x47, 0xdb, 0x64, 0x92, 0xda, 0xba, 0xa7, 0x90, 0x53, 0xd9, 0x8b, 0x41, 0x65,
+	0x85, 0x7a, 0x11, 0x6f, 0x7e, 0x04, 0x29, 0xd2, 0x0f, 0x12, 0xc1, 0x73, 0x13, 0x47, 0x09, 0xd6,
+	0x6c, 0xdc, 0x6c, 0x0b, 0xfd, 0x03, 0xfe, 0x52, 0x7f, 0x88, 0x9b, 0x6d, 0xc1, 0xc6, 0xdb, 0x0c,
+	0xf3, 0xbe, 0xef, 0x3c, 0xb3, 0x0b, 0x5e, 0x95, 0x5d, 0x56, 0x19, 0xab, 0x04, 0x97, 0x9c, 0xfe,
+	0x18, 0xe0, 0x4f, 0x8b, 0xb2, 0xe0, 0xe5, 0x3d, 0x2f, 0xdf, 0x8a, 0x77, 0x02, 0x60, 0x4e, 0x1e,
+	0x02, 0x63, 0x64, 0x44, 0x2e, 0x19, 0x81, 0x2d, 0xf8, 0x0a, 0x03, 0x53, 0x75, 0xc7, 0x63, 0xc2,
+	0x0e, 0x85, 0x2c, 0x51, 0x13, 0x72, 0x02, 0xee, 0x42, 0x14, 0x9b, 0xa5, 0xc4, 0xc9, 0x22, 0xb0,
+	0xb4, 0xc9, 0x07, 0x3b, 0xad, 0x30, 0x0f, 0x6c, 0xdd, 0x9d, 0x43, 0x2f, 0xc5, 0x5c, 0xa0, 0xac,
+	0x83, 0xce, 0xc8, 0x8a, 0xbc, 0x71, 0xd8, 0x4e, 0xd9, 0x0f, 0xe3, 0x52, 0x8a, 0x6d, 0xc8, 0xc0,
+	0x3f, 0xec, 0x89, 0x07, 0xd6, 0x07, 0x6e, 0xf7, 0x30, 0x7d, 0xe8, 0x6c, 0x96, 0xab, 0xf5, 0x8e,
+	0xc6, 0xbd, 0x31, 0xaf, 0x0d, 0x1a, 0x81, 0xad, 0x29, 0x1c, 0xb0, 0x67, 0xf3, 0x59, 0x3c, 0x3c,
+	0x52, 0xf4, 0xdd, 0x97, 0x79, 0xf2, 0x14, 0x27, 0x43, 0xa3, 0xa9, 0xa7, 0xb7, 0xe9, 0xb3, 0xaa,
+	0x4d, 0x7a, 0x0a, 0x9d, 0x04, 0xab, 0xd5, 0x96, 0x0c, 0x14, 0xcf, 0x3a, 0xcf, 0xb1, 0xae, 0x75,
+	0xac, 0xd3, 0xc4, 0xc6, 0x42, 0x70, 0xb1, 0x8b, 0xa5, 0x2e, 0xf4, 0x12, 0xfc, 0x5a, 0x63, 0x2d,
+	0x69, 0x08, 0x5e, 0x2c, 0xf3, 0xd7, 0x29, 0x7e, 0x66, 0x28, 0xea, 0x06, 0x66, 0xb2, 0x68, 0x5c,
+	0x56, 0xe4, 0x8e, 0xbf, 0x0d, 0x15, 0xae, 0xef, 0x20, 0x67, 0x30, 0x48, 0x51, 0xb6, 0xde, 0xb0,
+	0xdf, 0xba, 0x31, 0xec, 0x32, 0xbd, 0x9b, 0x1e, 0x91, 0x0b, 0x18, 0x3c, 0xfe, 0xd3, 0x3a, 0x6c,
+	0xbf, 0x2f, 0x6c, 0xbb, 0x94, 0x9a, 0x82, 0x73, 0xc7, 0xb9, 0x6c, 0x20, 0x88, 0xcf, 0x0e, 0x58,
+	0xfe, 0x12, 0xb3, 0xae, 0xfe, 0xc6, 0xab, 0x5f, 0xe4, 0x8d, 0x69, 0x93, 0xd5, 0x01, 0x00, 0x00,
 }
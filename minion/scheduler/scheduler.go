@@ -67,10 +67,28 @@ func Run(conn db.Conn) {
 }
 
 func syncDB(view db.Database, dkcsArg []docker.Container) ([]string, []db.Container) {
+	// Unhealthy containers mustn't pair with any row, lest they be kept.
+	unhealthy := map[string]struct{}{}
+	for _, dbc := range view.SelectFromContainer(nil) {
+		if dbc.Health == db.Unhealthy && dbc.SchedID != "" {
+			log.WithField("container", dbc).Info("Replace unhealthy container.")
+			unhealthy[dbc.SchedID] = struct{}{}
+		}
+	}
+
+	secrets := map[string]string{}
+	for _, s := range view.SelectFromSecret(nil) {
+		secrets[s.Name] = s.Value
+	}
+
 	score := func(left, right interface{}) int {
 		dbc := left.(db.Container)
 		dkc := right.(docker.Container)
 
+		if _, ok := unhealthy[dkc.ID]; ok {
+			return -1
+		}
+
 		// Depending on the container, the command in the database could be
 		// either The command plus it's arguments, or just it's arguments.  To
 		// handle that case, we check both.
@@ -84,6 +102,13 @@ func syncDB(view db.Database, dkcsArg []docker.Container) ([]string, []db.Contai
 			}
 		}
 
+		// Containers are rebooted when a secret they use changes.
+		for key, name := range dbc.SecretEnv {
+			if value, ok := secrets[name]; !ok || dkc.Env[key] != value {
+				return -1
+			}
+		}
+
 		var dkcLabels []string
 		for label, value := range dkc.Labels {
 			if !docker.IsUserLabel(label) || value != docker.LabelTrueValue {
@@ -95,6 +120,14 @@ func syncDB(view db.Database, dkcsArg []docker.Container) ([]string, []db.Contai
 		switch {
 		case dkc.Image != dbc.Image:
 			return -1
+		case dkc.Memory != megabytes(dbc.Memory):
+			return -1
+		case dkc.CPUShares != int64(dbc.CPUShares):
+			return -1
+		case util.EditDistance(dkc.Binds, dbc.Volumes) != 0:
+			return -1
+		case dkc.Labels[docker.HealthCheckLabel] != healthCheckLabel(dbc):
+			return -1
 		case len(dbcCmd) != 0 && !strEq(dbcCmd, cmd1) && !strEq(dbcCmd, cmd2):
 			return -1
 		case dkc.ID == dbc.SchedID:
@@ -107,7 +140,12 @@ func syncDB(view db.Database, dkcsArg []docker.Container) ([]string, []db.Contai
 
 	for _, pair := range pairs {
 		dbc := pair.L.(db.Container)
-		dbc.SchedID = pair.R.(docker.Container).ID
+		dkc := pair.R.(docker.Container)
+		if dbc.SchedID != dkc.ID {
+			// The health belonged to the container previously scheduled.
+			dbc.Health = ""
+		}
+		dbc.SchedID = dkc.ID
 		view.Commit(dbc)
 	}
 
@@ -117,13 +155,45 @@ func syncDB(view db.Database, dkcsArg []docker.Container) ([]string, []db.Contai
 	}
 
 	var boot []db.Container
-	for _, dbc := range dbcs {
-		boot = append(boot, dbc.(db.Container))
+	for _, dbcIface := range dbcs {
+		dbc := dbcIface.(db.Container)
+		env, err := resolveEnv(dbc, secrets)
+		if err != nil {
+			log.WithError(err).WithField("container", dbc).Warn(
+				"Failed to boot container.")
+			continue
+		}
+
+		dbc.Env = env
+		boot = append(boot, dbc)
 	}
 
 	return term, boot
 }
 
+// resolveEnv returns the environment of 'dbc' with the values of its secrets,
+// taken from 'secrets', filled in.
+func resolveEnv(dbc db.Container, secrets map[string]string) (map[string]string,
+	error) {
+	if len(dbc.SecretEnv) == 0 {
+		return dbc.Env, nil
+	}
+
+	env := map[string]string{}
+	for key, value := range dbc.Env {
+		env[key] = value
+	}
+
+	for key, name := range dbc.SecretEnv {
+		value, ok := secrets[name]
+		if !ok {
+			return nil, fmt.Errorf("undefined secret: %s", name)
+		}
+		env[key] = value
+	}
+	return env, nil
+}
+
 func strEq(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
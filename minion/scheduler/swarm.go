@@ -1,7 +1,9 @@
 package scheduler
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -13,31 +15,84 @@ import (
 
 type swarm struct {
 	dk docker.Client
+
+	// Maps the volumes of each kind of container to the nodes that hold them.
+	// Entries outlive the containers that created them so that a replacement
+	// lands on a node with an old container's data.  They're rebuilt from the
+	// running containers when a new master takes over, so the volumes of
+	// containers that weren't running at the time are forgotten.
+	volumeNodes map[volumeKey]map[string]struct{}
+
+	// The nodes running each kind of container with volumes, as of the last
+	// call to list().
+	runningNodes map[volumeKey]map[string]struct{}
+}
+
+// A volumeKey identifies the volumes of a kind of container.  Containers with the
+// same volumes but different images or labels are unrelated, even if their volumes
+// share a source, because a host directory on one node has nothing to do with the
+// directory of the same name on another.
+type volumeKey struct {
+	image, labels, binds string
 }
 
 func newSwarm(dk docker.Client) scheduler {
-	return swarm{dk}
+	return &swarm{dk: dk, volumeNodes: map[volumeKey]map[string]struct{}{}}
 }
 
-func (s swarm) list() ([]docker.Container, error) {
-	return s.dk.List(map[string][]string{"label": {docker.SchedulerLabelPair}})
+func (s *swarm) list() ([]docker.Container, error) {
+	dkcs, err := s.dk.List(map[string][]string{"label": {docker.SchedulerLabelPair}})
+	if err != nil {
+		return nil, err
+	}
+
+	s.runningNodes = map[volumeKey]map[string]struct{}{}
+	for _, dkc := range dkcs {
+		if dkc.Node == "" || len(dkc.Binds) == 0 {
+			continue
+		}
+
+		var labels []string
+		for label, value := range dkc.Labels {
+			if docker.IsUserLabel(label) && value == docker.LabelTrueValue {
+				labels = append(labels, docker.ParseUserLabel(label))
+			}
+		}
+
+		key := makeVolumeKey(dkc.Image, labels, dkc.Binds)
+		addNode(s.volumeNodes, key, dkc.Node)
+		addNode(s.runningNodes, key, dkc.Node)
+	}
+	return dkcs, nil
 }
 
-func (s swarm) boot(dbcs []db.Container) {
+func (s *swarm) boot(dbcs []db.Container) {
 	var wg sync.WaitGroup
 	wg.Add(len(dbcs))
 
+	// The nodes chosen for the containers in this call, so that replacements of
+	// the same kind are spread across the nodes holding their volumes.
+	claimed := map[volumeKey]map[string]struct{}{}
+
 	logChn := make(chan string, 1)
 	for _, dbc := range dbcs {
 		dbc := dbc
+		labels := makeLabels(dbc)
+		env := makeEnv(dbc)
+		if node := s.volumeNode(dbc, claimed); node != "" {
+			env[fmt.Sprintf("constraint:node==%s", node)] = struct{}{}
+		}
+
 		go func() {
-			labels := makeLabels(dbc)
-			env := makeEnv(dbc)
 			err := s.dk.Run(docker.RunOptions{
 				Image:  dbc.Image,
 				Args:   dbc.Command,
 				Env:    env,
 				Labels: labels,
+				Binds:  dbc.Volumes,
+
+				Memory:    megabytes(dbc.Memory),
+				CPUShares: int64(dbc.CPUShares),
 			})
 			if err != nil {
 				msg := fmt.Sprintf("Failed to start container %s: %s",
@@ -63,6 +118,64 @@ func (s swarm) boot(dbcs []db.Container) {
 	}
 }
 
+// volumeNode returns the node holding volumes of the same kind of container as
+// 'dbc' that no such container is using, or "" if there isn't one.  The node is
+// added to 'claimed', so that it isn't returned again.
+func (s *swarm) volumeNode(dbc db.Container,
+	claimed map[volumeKey]map[string]struct{}) string {
+	if len(dbc.Volumes) == 0 {
+		return ""
+	}
+
+	key := makeVolumeKey(dbc.Image, dbc.Labels, dbc.Volumes)
+	var nodes []string
+	for node := range s.volumeNodes[key] {
+		_, running := s.runningNodes[key][node]
+		_, taken := claimed[key][node]
+		if !running && !taken {
+			nodes = append(nodes, node)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return ""
+	}
+
+	sort.Strings(nodes)
+	addNode(claimed, key, nodes[0])
+	return nodes[0]
+}
+
+func makeVolumeKey(image string, labels, binds []string) volumeKey {
+	labels = append([]string{}, labels...)
+	binds = append([]string{}, binds...)
+	sort.Strings(labels)
+	sort.Strings(binds)
+	return volumeKey{image: image, labels: strings.Join(labels, ","),
+		binds: strings.Join(binds, ",")}
+}
+
+func addNode(nodes map[volumeKey]map[string]struct{}, key volumeKey, node string) {
+	if nodes[key] == nil {
+		nodes[key] = map[string]struct{}{}
+	}
+	nodes[key][node] = struct{}{}
+}
+
+// healthCheckLabel encodes the health check of 'dbc' for the worker that will probe
+// it.  Returns the empty string if 'dbc' has no health check.
+func healthCheckLabel(dbc db.Container) string {
+	if dbc.HealthCheck.Type == "" {
+		return ""
+	}
+
+	js, err := json.Marshal(dbc.HealthCheck)
+	if err != nil {
+		panic("Not Reached")
+	}
+	return string(js)
+}
+
 func makeLabels(dbc db.Container) map[string]string {
 	labels := map[string]string{
 		docker.SchedulerLabelKey: docker.SchedulerLabelValue,
@@ -70,9 +183,17 @@ func makeLabels(dbc db.Container) map[string]string {
 	for _, lb := range dbc.Labels {
 		labels[docker.UserLabel(lb)] = docker.LabelTrueValue
 	}
+	if check := healthCheckLabel(dbc); check != "" {
+		labels[docker.HealthCheckLabel] = check
+	}
 	return labels
 }
 
+// megabytes converts 'mb' to bytes, the unit docker expects memory limits in.
+func megabytes(mb int) int64 {
+	return int64(mb) * 1024 * 1024
+}
+
 func makeEnv(dbc db.Container) map[string]struct{} {
 	env := make(map[string]struct{})
 	for _, label := range dbc.Labels {
@@ -89,7 +210,34 @@ func makeEnv(dbc db.Container) map[string]struct{} {
 				env[affinityStr] = struct{}{}
 			}
 		}
+
+		// Followers are placed next to their anchor, not the other way around,
+		// so that the anchor has somewhere to go when neither is running.
+		for colocate := range dbc.Placement.Colocate {
+			if colocate[1] == label && !hasLabel(dbc, colocate[0]) {
+				affinityStr := fmt.Sprintf("affinity:%s==%s",
+					docker.UserLabel(colocate[0]),
+					docker.LabelTrueValue)
+				env[affinityStr] = struct{}{}
+			}
+		}
 	}
+
+	machine := dbc.Placement.Machine
+	constraints := map[string]string{
+		docker.SystemLabel("role"):     machine.Role,
+		docker.SystemLabel("region"):   machine.Region,
+		docker.SystemLabel("provider"): machine.Provider,
+	}
+	for _, label := range machine.Labels {
+		constraints[docker.UserLabel(label)] = docker.LabelTrueValue
+	}
+	for key, value := range constraints {
+		if value != "" {
+			env[fmt.Sprintf("constraint:%s==%s", key, value)] = struct{}{}
+		}
+	}
+
 	for key, value := range dbc.Env {
 		envStr := fmt.Sprintf("%s=%s", key, value)
 		env[envStr] = struct{}{}
@@ -97,7 +245,16 @@ func makeEnv(dbc db.Container) map[string]struct{} {
 	return env
 }
 
-func (s swarm) terminate(ids []string) {
+func hasLabel(dbc db.Container, label string) bool {
+	for _, l := range dbc.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *swarm) terminate(ids []string) {
 	var wg sync.WaitGroup
 	wg.Add(len(ids))
 	defer wg.Wait()
@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/minion/docker"
+)
+
+func TestVolumePlacement(t *testing.T) {
+	dk := &fakeSwarm{}
+	s := newSwarm(dk).(*swarm)
+
+	binds := []string{"/data:/var/lib/db"}
+	running := func(id, node string) docker.Container {
+		return docker.Container{
+			ID:     id,
+			Image:  "db",
+			Labels: makeLabels(db.Container{Labels: []string{"db"}}),
+			Binds:  binds,
+			Node:   node,
+		}
+	}
+	dbc := db.Container{Image: "db", Labels: []string{"db"}, Volumes: binds}
+
+	// Two replicas leave their volumes on n1 and n2.
+	dk.containers = []docker.Container{running("a", "n1"), running("b", "n2")}
+	if _, err := s.list(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replacement of the container on n1 goes back to n1, not to n2 which
+	// is still using its volumes.
+	dk.containers = []docker.Container{running("b", "n2")}
+	s.list()
+	s.boot([]db.Container{dbc})
+	if nodes := dk.bootedNodes(); !reflect.DeepEqual(nodes, []string{"n1"}) {
+		t.Errorf("replacement placed on %v, expected n1", nodes)
+	}
+
+	// Replicas booted together don't claim the same node.
+	dk.containers = nil
+	s.list()
+	s.boot([]db.Container{dbc, dbc})
+	if nodes := dk.bootedNodes(); !reflect.DeepEqual(nodes,
+		[]string{"n1", "n2"}) {
+		t.Errorf("replicas placed on %v, expected n1 and n2", nodes)
+	}
+
+	// The volumes of a different kind of container are unrelated.
+	other := dbc
+	other.Image = "cache"
+	s.boot([]db.Container{other})
+	if nodes := dk.bootedNodes(); len(nodes) != 0 {
+		t.Errorf("unrelated container placed on %v", nodes)
+	}
+}
+
+// fakeSwarm is a docker client that lists 'containers', and records the containers
+// it's asked to run.
+type fakeSwarm struct {
+	docker.Client
+
+	containers []docker.Container
+
+	sync.Mutex
+	runs []docker.RunOptions
+}
+
+func (dk *fakeSwarm) List(filters map[string][]string) ([]docker.Container, error) {
+	return dk.containers, nil
+}
+
+func (dk *fakeSwarm) Run(opts docker.RunOptions) error {
+	dk.Lock()
+	defer dk.Unlock()
+	dk.runs = append(dk.runs, opts)
+	return nil
+}
+
+// bootedNodes returns the nodes the containers run since the last call were
+// constrained to, sorted.
+func (dk *fakeSwarm) bootedNodes() []string {
+	dk.Lock()
+	defer dk.Unlock()
+
+	nodes := []string{}
+	for _, opts := range dk.runs {
+		for env := range opts.Env {
+			if strings.HasPrefix(env, "constraint:node==") {
+				nodes = append(nodes,
+					strings.TrimPrefix(env, "constraint:node=="))
+			}
+		}
+	}
+	dk.runs = nil
+
+	sort.Strings(nodes)
+	return nodes
+}
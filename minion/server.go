@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/engine"
 	"github.com/NetSys/di/minion/pb"
 
 	"golang.org/x/net/context"
@@ -41,7 +42,7 @@ func minionServerRun(conn db.Conn) {
 func (s server) GetMinionConfig(cts context.Context,
 	_ *pb.Request) (*pb.MinionConfig, error) {
 	var minionRows []db.Minion
-	s.Transact(func(view db.Database) error {
+	s.ReadTransact(func(view db.Database) error {
 		minionRows = view.SelectFromMinion(nil)
 		return nil
 	})
@@ -80,8 +81,11 @@ func (s server) SetMinionConfig(ctx context.Context,
 		minion.MinionID = msg.ID
 		minion.Role = db.Role(msg.Role)
 		minion.PrivateIP = msg.PrivateIP
+		minion.Spec = msg.Spec
 		view.Commit(minion)
 
+		// The secrets must be in place before the containers that use them.
+		engine.UpdateSecrets(view, msg.Secrets)
 		updatePolicy(view, minion.Role, msg.Spec)
 
 		return nil
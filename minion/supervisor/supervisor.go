@@ -1,6 +1,7 @@
 package supervisor
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
@@ -148,6 +149,13 @@ func (sv *supervisor) runAppTransact(view db.Database,
 		dbc.Pid = dkc.Pid
 		dbc.Image = dkc.Image
 		dbc.Command = append([]string{dkc.Path}, dkc.Args...)
+
+		dbc.HealthCheck = db.HealthCheck{}
+		if js, ok := dkc.Labels[docker.HealthCheckLabel]; ok {
+			if err := json.Unmarshal([]byte(js), &dbc.HealthCheck); err != nil {
+				log.WithError(err).Warnf("Malformed health check: %s", js)
+			}
+		}
 		view.Commit(dbc)
 	}
 
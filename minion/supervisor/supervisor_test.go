@@ -521,6 +521,10 @@ func (f fakeDocker) ExecVerbose(name string, cmd ...string) ([]byte, []byte, err
 	panic("Supervisor does not ExecVerbose()")
 }
 
+func (f fakeDocker) ExecStatus(id string, cmd ...string) (int, error) {
+	panic("Supervisor does not ExecStatus()")
+}
+
 func (f fakeDocker) RemoveID(id string) error {
 	panic("Supervisor does not RemoveID()")
 }
@@ -538,7 +542,12 @@ func (f fakeDocker) WriteToContainer(id, src, dst, archiveName string, permissio
 }
 
 func (f fakeDocker) GetFromContainer(id string, src string) (string, error) {
-	panic("Supervisor does not WriteToContainer()")
+	panic("Supervisor does not GetFromContainer()")
+}
+
+func (f fakeDocker) GetFileFromContainer(id string, src string) (string, int,
+	error) {
+	panic("Supervisor does not GetFileFromContainer()")
 }
 
 func swarmArgsMaster(ip string) []string {
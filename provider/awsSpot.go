@@ -103,7 +103,7 @@ func (clst awsSpotCluster) Boot(bootSet []Machine) error {
 	bootReqMap := make(map[bootReq]int64) // From boot request to an instance count.
 	for _, m := range bootSet {
 		br := bootReq{
-			cfg:      cloudConfigUbuntu(m.SSHKeys, "wily"),
+			cfg:      cloudConfigUbuntu(m, "wily"),
 			size:     m.Size,
 			region:   m.Region,
 			diskSize: m.DiskSize,
@@ -395,7 +395,17 @@ OuterLoop:
 	return errors.New("timed out")
 }
 
-func (clst *awsSpotCluster) updateSecurityGroups(acls []string) error {
+// An awsPort identifies a security group permission that opens a port range to
+// the public internet.
+type awsPort struct {
+	protocol string
+	from, to int64
+}
+
+const publicCIDR = "0.0.0.0/0"
+
+func (clst *awsSpotCluster) updateSecurityGroups(acls []string,
+	ports []db.PortRange) error {
 	for _, session := range clst.sessions {
 		resp, err := session.DescribeSecurityGroups(
 			&ec2.DescribeSecurityGroupsInput{
@@ -431,9 +441,19 @@ func (clst *awsSpotCluster) updateSecurityGroups(acls []string) error {
 			permMap[acl] = true
 		}
 
+		portMap := publicPortMap(ports)
+
 		groupIngressExists := false
-		for i, p := range ingress {
-			if (i > 0 || p.FromPort != nil || p.ToPort != nil ||
+		for _, p := range ingress {
+			if isPublicPort(p) {
+				key := awsPort{*p.IpProtocol, *p.FromPort, *p.ToPort}
+				if _, ok := portMap[key]; ok {
+					portMap[key] = false
+					continue
+				}
+			}
+
+			if (p.FromPort != nil || p.ToPort != nil ||
 				*p.IpProtocol != "-1") && p.UserIdGroupPairs == nil {
 				log.Info("Revoke ingress security group: ", *p)
 				_, err = session.RevokeSecurityGroupIngress(
@@ -509,15 +529,54 @@ func (clst *awsSpotCluster) updateSecurityGroups(acls []string) error {
 				return err
 			}
 		}
+
+		for port, install := range portMap {
+			if !install {
+				continue
+			}
+
+			log.Info("Add public port: ", port)
+			_, err = session.AuthorizeSecurityGroupIngress(
+				&ec2.AuthorizeSecurityGroupIngressInput{
+					CidrIp:     aws.String(publicCIDR),
+					GroupName:  aws.String(clst.namespace),
+					IpProtocol: aws.String(port.protocol),
+					FromPort:   aws.Int64(port.from),
+					ToPort:     aws.Int64(port.to)})
+
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// publicPortMap returns the permissions that open 'ports' to the public internet,
+// each mapped to true.
+func publicPortMap(ports []db.PortRange) map[awsPort]bool {
+	portMap := make(map[awsPort]bool)
+	for _, pr := range ports {
+		portMap[awsPort{pr.Protocol, int64(pr.MinPort), int64(pr.MaxPort)}] = true
+	}
+	return portMap
+}
+
+// isPublicPort returns true if `p` opens a tcp or udp port range to the entire
+// internet, and nothing else.
+func isPublicPort(p *ec2.IpPermission) bool {
+	return p.IpProtocol != nil && p.FromPort != nil && p.ToPort != nil &&
+		(*p.IpProtocol == "tcp" || *p.IpProtocol == "udp") &&
+		len(p.UserIdGroupPairs) == 0 && len(p.IpRanges) == 1 &&
+		p.IpRanges[0].CidrIp != nil && *p.IpRanges[0].CidrIp == publicCIDR
+}
+
 func (clst *awsSpotCluster) watchACLs(conn db.Conn, clusterID int) {
 	for range clst.aclTrigger.C {
 		var acls []string
-		conn.Transact(func(view db.Database) error {
+		var ports []db.PortRange
+		conn.ReadTransact(func(view db.Database) error {
 			clusters := view.SelectFromCluster(func(c db.Cluster) bool {
 				return c.ID == clusterID
 			})
@@ -530,9 +589,10 @@ func (clst *awsSpotCluster) watchACLs(conn db.Conn, clusterID int) {
 			}
 
 			acls = clusters[0].ACLs
+			ports = clusters[0].PublicPorts
 			return nil
 		})
 
-		clst.updateSecurityGroups(acls)
+		clst.updateSecurityGroups(acls, ports)
 	}
 }
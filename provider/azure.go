@@ -124,7 +124,7 @@ func (clst *azureCluster) Boot(bootSet []Machine) error {
 
 	for _, m := range bootSet {
 		name := "di-" + uuid.NewV4().String()
-		if err := clst.instanceNew(name, m.Size, cloudConfigUbuntu(m.SSHKeys, "wily")); err != nil {
+		if err := clst.instanceNew(name, m.Size, cloudConfigUbuntu(m, "wily")); err != nil {
 			return err
 		}
 	}
@@ -2,14 +2,17 @@ package provider
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/NetSys/di/minion/docker"
 )
 
 const (
 	minionImage = "quay.io/netsys/di-minion:latest"
 )
 
-func cloudConfigUbuntu(keys []string, ubuntuVersion string) string {
+func cloudConfigUbuntu(m Machine, ubuntuVersion string) string {
 	cloudConfig := `#!/bin/bash
 
 initialize_ovs() {
@@ -47,7 +50,7 @@ initialize_docker() {
 	# The below empty ExecStart deletes the official one installed by docker daemon.
 	ExecStart=
 	ExecStart=/usr/bin/docker daemon --bridge=none \
-	-H "${PRIVATE_IPv4}:2375" -H unix:///var/run/docker.sock \
+	-H "${PRIVATE_IPv4}:2375" -H unix:///var/run/docker.sock %[4]s \
 
 	[Install]
 	WantedBy=multi-user.target
@@ -130,11 +133,32 @@ systemctl restart {ovs,docker,minion}.service
 echo -n "Completed Boot Script: " >> /var/log/bootscript.log
 date >> /var/log/bootscript.log
     `
-	cloudConfig = fmt.Sprintf(cloudConfig, minionImage, strings.Join(keys, "\n"), ubuntuVersion)
+	cloudConfig = fmt.Sprintf(cloudConfig, minionImage, strings.Join(m.SSHKeys, "\n"),
+		ubuntuVersion, engineLabels(m))
 
 	return cloudConfig
 }
 
+// engineLabels returns the flags that label the docker daemon of 'm' with its
+// attributes, which the scheduler uses to pin containers to machines.
+func engineLabels(m Machine) string {
+	labels := map[string]string{
+		docker.SystemLabel("role"):     m.Role.String(),
+		docker.SystemLabel("region"):   m.Region,
+		docker.SystemLabel("provider"): string(m.Provider),
+	}
+	for _, l := range m.Labels {
+		labels[docker.UserLabel(l)] = docker.LabelTrueValue
+	}
+
+	var flags []string
+	for k, v := range labels {
+		flags = append(flags, fmt.Sprintf(`--label="%s=%s"`, k, v))
+	}
+	sort.Strings(flags)
+	return strings.Join(flags, " ")
+}
+
 func cloudConfigCoreOS(keys []string) string {
 	cloudConfig := `#cloud-config
 
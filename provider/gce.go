@@ -55,6 +55,7 @@ type gceCluster struct {
 	ipv4Range string // ipv4 range of the internal network
 	intFW     string // gce internal firewall name
 	extFW     string // gce external firewall name
+	pubFW     string // gce firewall for ports open to the public internet
 
 	ns         string     // cluster namespace
 	id         int        // the id of the cluster, used externally
@@ -85,6 +86,7 @@ func (clst *gceCluster) Start(conn db.Conn, clusterID int, namespace string) err
 	clst.ipv4Range = "192.168.0.0/16"
 	clst.intFW = fmt.Sprintf("%s-internal", clst.ns)
 	clst.extFW = fmt.Sprintf("%s-external", clst.ns)
+	clst.pubFW = fmt.Sprintf("%s-public", clst.ns)
 
 	if err := clst.netInit(); err != nil {
 		log.WithError(err).Debug("failed to start up gce network")
@@ -137,7 +139,7 @@ func (clst *gceCluster) Boot(bootSet []Machine) error {
 	var names []string
 	for _, m := range bootSet {
 		name := "di-" + uuid.NewV4().String()
-		_, err := clst.instanceNew(name, m.Size, m.Region, cloudConfigUbuntu(m.SSHKeys, "wily"))
+		_, err := clst.instanceNew(name, m.Size, m.Region, cloudConfigUbuntu(m, "wily"))
 		if err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
@@ -179,10 +181,8 @@ func (clst *gceCluster) Stop(machines []Machine) error {
 
 // Disconnect
 func (clst *gceCluster) Disconnect() {
-	panic("disconnect(): unimplemented! Check the comments on what this should actually do")
-	// should cancel the ACL watch
-	// should delete the instances
-	// should delete the network
+	clst.aclTrigger.Stop()
+	// XXX: The network and firewalls created by Start() should be deleted too.
 }
 
 func (clst *gceCluster) PickBestSize(ram dsl.Range, cpu dsl.Range,
@@ -347,32 +347,108 @@ func (clst *gceCluster) instanceDel(name, zone string) (*compute.Operation, erro
 	return op, err
 }
 
-func (clst *gceCluster) updateSecurityGroups(acls []string) error {
+func (clst *gceCluster) updateSecurityGroups(acls []string,
+	ports []db.PortRange) error {
 	list, err := gceService.Firewalls.List(clst.projID).Do()
 	if err != nil {
 		return err
 	}
-	var fw *compute.Firewall
+	var fw, pubFW *compute.Firewall
 	for _, val := range list.Items {
-		if val.Name == clst.extFW {
+		switch val.Name {
+		case clst.extFW:
 			fw = val
-			break
+		case clst.pubFW:
+			pubFW = val
 		}
 	}
-	sort.Strings(fw.SourceRanges)
+
+	var ops []*compute.Operation
 	sort.Strings(acls)
-	if fw == nil || reflect.DeepEqual(fw.SourceRanges, acls) {
-		return nil
+	if fw != nil {
+		sort.Strings(fw.SourceRanges)
+		if !reflect.DeepEqual(fw.SourceRanges, acls) {
+			op, err := clst.firewallPatch(clst.extFW, acls)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, op)
+		}
 	}
 
-	op, err := clst.firewallPatch(clst.extFW, acls)
+	op, err := clst.updatePublicFirewall(pubFW, ports)
 	if err != nil {
 		return err
 	}
-	if err = clst.operationWait([]*compute.Operation{op}, global); err != nil {
-		return err
+	if op != nil {
+		ops = append(ops, op)
 	}
-	return nil
+
+	return clst.operationWait(ops, global)
+}
+
+// Creates, patches, or deletes the public firewall so that exactly `ports` are
+// reachable from the internet.  Returns nil if no change was necessary.
+func (clst *gceCluster) updatePublicFirewall(fw *compute.Firewall,
+	ports []db.PortRange) (*compute.Operation, error) {
+	if len(ports) == 0 {
+		if fw == nil {
+			return nil, nil
+		}
+		log.Debug("deleting public firewall")
+		return gceService.Firewalls.Delete(clst.projID, clst.pubFW).Do()
+	}
+
+	allowed := publicAllowed(ports)
+	if fw == nil {
+		log.Debug("creating public firewall")
+		firewall := &compute.Firewall{
+			Name: clst.pubFW,
+			Network: fmt.Sprintf("%s/global/networks/%s",
+				clst.baseURL,
+				clst.ns),
+			Allowed:      allowed,
+			SourceRanges: []string{"0.0.0.0/0"},
+		}
+		return gceService.Firewalls.Insert(clst.projID, firewall).Do()
+	}
+
+	if reflect.DeepEqual(fw.Allowed, allowed) {
+		return nil, nil
+	}
+
+	firewall := &compute.Firewall{
+		Name: clst.pubFW,
+		Network: fmt.Sprintf("%s/global/networks/%s",
+			clst.baseURL,
+			clst.ns),
+		Allowed: allowed,
+	}
+	return gceService.Firewalls.Patch(clst.projID, clst.pubFW, firewall).Do()
+}
+
+// publicAllowed converts `ports` into the rules of a GCE firewall, one for each
+// protocol.
+func publicAllowed(ports []db.PortRange) []*compute.FirewallAllowed {
+	protoPorts := map[string][]string{}
+	for _, pr := range ports {
+		protoPorts[pr.Protocol] = append(protoPorts[pr.Protocol], pr.Ports())
+	}
+
+	var protocols []string
+	for protocol := range protoPorts {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	var allowed []*compute.FirewallAllowed
+	for _, protocol := range protocols {
+		allowed = append(allowed, &compute.FirewallAllowed{
+			IPProtocol: protocol,
+			Ports:      protoPorts[protocol],
+		})
+	}
+	return allowed
 }
 
 // Creates the network for the cluster.
@@ -463,7 +539,8 @@ func (clst *gceCluster) firewallPatch(name string, ips []string) (*compute.Opera
 func (clst *gceCluster) watchACLs(conn db.Conn, clusterID int) {
 	for range clst.aclTrigger.C {
 		var acls []string
-		conn.Transact(func(view db.Database) error {
+		var ports []db.PortRange
+		conn.ReadTransact(func(view db.Database) error {
 			clusters := view.SelectFromCluster(func(c db.Cluster) bool {
 				return c.ID == clusterID
 			})
@@ -476,10 +553,11 @@ func (clst *gceCluster) watchACLs(conn db.Conn, clusterID int) {
 			}
 
 			acls = clusters[0].ACLs
+			ports = clusters[0].PublicPorts
 			return nil
 		})
 
-		clst.updateSecurityGroups(acls)
+		clst.updateSecurityGroups(acls, ports)
 	}
 }
 
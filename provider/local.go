@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/dsl"
+	"github.com/NetSys/di/minion/docker"
+	"github.com/NetSys/di/util"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	localSock = "unix:///var/run/docker.sock"
+
+	// The image of the containers that stand in for machines.  Each runs its own
+	// docker daemon, so that the minions don't share their system containers.
+	localImage = "docker:1.9.1-dind"
+)
+
+// The script run by each local machine.  Like the cloud config of real machines,
+// it starts a docker daemon listening on the machine's IP, and then the minion.
+const localBootScript = `dind docker daemon --host=unix:///var/run/docker.sock \
+	--host=tcp://0.0.0.0:2375 --storage-driver=vfs --bridge=none %[1]s &
+
+until docker info >/dev/null 2>&1; do sleep 1; done
+
+mkdir -p /var/run/netns
+docker run -d --net=host --name=minion --privileged --restart=always \
+	-v /var/run/docker.sock:/var/run/docker.sock \
+	-v /proc:/hostproc:ro -v /var/run/netns:/var/run/netns:rw %[2]s
+
+wait`
+
+var (
+	localNamespaceLabel = docker.SystemLabel("namespace")
+	localSizeLabel      = docker.SystemLabel("size")
+)
+
+// The local provider boots each machine as a privileged docker-in-docker container
+// on the local docker daemon.  Each gets its own IP on the docker bridge, and its
+// own docker daemon on which the minion runs.  The host must have the openvswitch
+// kernel module loaded, as the machines share its kernel.
+type localCluster struct {
+	namespace string
+	dk        docker.Client
+}
+
+func (clst *localCluster) Start(conn db.Conn, clusterID int, namespace string) error {
+	dk := docker.New(localSock)
+	if _, err := dk.List(nil); err != nil {
+		return err
+	}
+
+	clst.namespace = namespace
+	clst.dk = dk
+	return nil
+}
+
+func (clst localCluster) Boot(bootSet []Machine) error {
+	for _, m := range bootSet {
+		name := fmt.Sprintf("di-%s-%s", clst.namespace,
+			util.ShortUUID(uuid.NewV4().String()))
+		m.Provider = db.Local
+		script := fmt.Sprintf(localBootScript, engineLabels(m), minionImage)
+		err := clst.dk.Run(docker.RunOptions{
+			Name:  name,
+			Image: localImage,
+			Args:  []string{"sh", "-c", script},
+			Labels: map[string]string{
+				localNamespaceLabel: clst.namespace,
+				localSizeLabel:      m.Size,
+			},
+			Privileged: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (clst localCluster) Get() ([]Machine, error) {
+	filter := map[string][]string{
+		"label": {localNamespaceLabel + "=" + clst.namespace},
+	}
+	containers, err := clst.dk.List(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var machines []Machine
+	for _, c := range containers {
+		machines = append(machines, Machine{
+			ID:        c.ID,
+			PublicIP:  c.IP,
+			PrivateIP: c.IP,
+			Provider:  db.Local,
+			Size:      c.Labels[localSizeLabel],
+		})
+	}
+	return machines, nil
+}
+
+func (clst localCluster) Stop(machines []Machine) error {
+	for _, m := range machines {
+		if err := clst.dk.RemoveID(m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (clst localCluster) Disconnect() {}
+
+func (clst localCluster) PickBestSize(ram dsl.Range, cpu dsl.Range,
+	maxPrice float64) string {
+	// The containers aren't limited, so the size is merely descriptive.
+	return fmt.Sprintf("%g,%g", ram.Min, cpu.Min)
+}
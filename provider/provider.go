@@ -1,6 +1,8 @@
 package provider
 
 import (
+	"sort"
+
 	"github.com/NetSys/di/db"
 	"github.com/NetSys/di/dsl"
 )
@@ -15,6 +17,8 @@ type Machine struct {
 	SSHKeys   []string
 	Provider  db.Provider
 	Region    string
+	Role      db.Role
+	Labels    []string
 }
 
 // Provider defines an interface for interacting with cloud providers.
@@ -43,6 +47,8 @@ func New(dbp db.Provider) Provider {
 		return &azureCluster{}
 	case db.Vagrant:
 		return &vagrantCluster{}
+	case db.Local:
+		return &localCluster{}
 	default:
 		panic("Unimplemented")
 	}
@@ -61,3 +67,40 @@ func GroupBy(machines []Machine) map[db.Provider][]Machine {
 
 	return machineMap
 }
+
+// LintInfo returns the regions and sizes offered by each provider, for checking
+// specs with `dsl.Lint`.  Providers that accept any region or size have empty
+// lists.
+func LintInfo() map[string]dsl.ProviderInfo {
+	var awsRegions []string
+	for region := range amis {
+		awsRegions = append(awsRegions, region)
+	}
+	sort.Strings(awsRegions)
+
+	return map[string]dsl.ProviderInfo{
+		string(db.AmazonSpot): {
+			Regions: awsRegions,
+			Sizes:   descriptionSizes(awsDescriptions),
+		},
+		string(db.Google): {
+			Regions: supportedZones,
+			Sizes:   descriptionSizes(googleDescriptions),
+		},
+		string(db.Azure):   {},
+		string(db.Vagrant): {},
+		string(db.Local):   {},
+	}
+}
+
+func descriptionSizes(descriptions []description) []string {
+	var sizes []string
+	seen := map[string]struct{}{}
+	for _, d := range descriptions {
+		if _, ok := seen[d.size]; !ok {
+			seen[d.size] = struct{}{}
+			sizes = append(sizes, d.size)
+		}
+	}
+	return sizes
+}
@@ -1,8 +1,17 @@
 package provider
 
 import (
-	"github.com/NetSys/di/dsl"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/NetSys/di/db"
+	"github.com/NetSys/di/dsl"
+	"github.com/NetSys/di/minion/docker"
+
+	"github.com/davecgh/go-spew/spew"
+	compute "google.golang.org/api/compute/v1"
 )
 
 func TestConstraints(t *testing.T) {
@@ -60,3 +69,180 @@ func TestConstraints(t *testing.T) {
 	checkConstraint(testDescriptions, dsl.Range{Min: 3},
 		dsl.Range{}, 0, "size4")
 }
+
+func TestEngineLabels(t *testing.T) {
+	m := Machine{
+		Provider: db.Google,
+		Region:   "us-east1-b",
+		Role:     db.Worker,
+		Labels:   []string{"ssd"},
+	}
+
+	exp := `--label="di.system.label.provider=Google" ` +
+		`--label="di.system.label.region=us-east1-b" ` +
+		`--label="di.system.label.role=Worker" ` +
+		`--label="di.user.label.ssd=1"`
+	if labels := engineLabels(m); labels != exp {
+		t.Errorf("bad engine labels. Expected %s, got %s", exp, labels)
+	}
+}
+
+func TestPublicAllowed(t *testing.T) {
+	allowed := publicAllowed([]db.PortRange{
+		{Protocol: "tcp", MinPort: 80, MaxPort: 80},
+		{Protocol: "udp", MinPort: 53, MaxPort: 53},
+		{Protocol: "tcp", MinPort: 10000, MaxPort: 20000},
+	})
+
+	exp := []*compute.FirewallAllowed{
+		{IPProtocol: "tcp", Ports: []string{"80", "10000-20000"}},
+		{IPProtocol: "udp", Ports: []string{"53"}},
+	}
+	if !reflect.DeepEqual(allowed, exp) {
+		t.Errorf("bad firewall rules: %s, expected %s", spew.Sdump(allowed),
+			spew.Sdump(exp))
+	}
+
+	// A protocol without ports mustn't appear, as GCE would open all of them.
+	allowed = publicAllowed([]db.PortRange{{Protocol: "udp", MinPort: 53,
+		MaxPort: 53}})
+	if len(allowed) != 1 || allowed[0].IPProtocol != "udp" {
+		t.Errorf("bad firewall rules: %s", spew.Sdump(allowed))
+	}
+}
+
+func TestPublicPortMap(t *testing.T) {
+	portMap := publicPortMap([]db.PortRange{
+		{Protocol: "tcp", MinPort: 80, MaxPort: 80},
+		{Protocol: "udp", MinPort: 10000, MaxPort: 20000},
+	})
+
+	exp := map[awsPort]bool{
+		{"tcp", 80, 80}:       true,
+		{"udp", 10000, 20000}: true,
+	}
+	if !reflect.DeepEqual(portMap, exp) {
+		t.Errorf("bad public ports: %v, expected %v", portMap, exp)
+	}
+}
+
+func TestLintInfo(t *testing.T) {
+	info := LintInfo()
+	if len(info) != 5 {
+		t.Errorf("expected 5 providers, found %d", len(info))
+	}
+
+	aws := info[string(db.AmazonSpot)]
+	if len(aws.Regions) != len(amis) {
+		t.Errorf("expected %d AmazonSpot regions, found %v", len(amis),
+			aws.Regions)
+	}
+
+	seen := map[string]bool{}
+	for _, size := range aws.Sizes {
+		if seen[size] {
+			t.Errorf("duplicate AmazonSpot size: %s", size)
+		}
+		seen[size] = true
+	}
+
+	if !seen["m4.large"] {
+		t.Errorf("expected m4.large in AmazonSpot sizes: %v", aws.Sizes)
+	}
+
+	if !reflect.DeepEqual(info[string(db.Google)].Regions, supportedZones) {
+		t.Errorf("bad Google regions: %v", info[string(db.Google)].Regions)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dk := &fakeDocker{containers: map[string]docker.Container{}}
+	clst := localCluster{namespace: "ns", dk: dk}
+
+	err := clst.Boot([]Machine{{Size: "2,1", Role: db.Worker}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dk.runs) != 1 {
+		t.Fatalf("expected one container, found %v", dk.runs)
+	}
+
+	opts := dk.runs[0]
+	if opts.Image != localImage || !opts.Privileged || len(opts.Binds) != 0 {
+		t.Errorf("machine container shares the host's daemon: %+v", opts)
+	}
+
+	script := opts.Args[len(opts.Args)-1]
+	for _, exp := range []string{engineLabels(Machine{Provider: db.Local,
+		Role: db.Worker}), "--host=tcp://0.0.0.0:2375", minionImage} {
+		if !strings.Contains(script, exp) {
+			t.Errorf("boot script lacks %s: %s", exp, script)
+		}
+	}
+
+	machines, err := clst.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []Machine{{ID: opts.Name, PublicIP: "172.17.0.2",
+		PrivateIP: "172.17.0.2", Provider: db.Local, Size: "2,1"}}
+	if !reflect.DeepEqual(machines, exp) {
+		t.Errorf("bad machines: %v, expected %v", machines, exp)
+	}
+
+	// Machines of other namespaces are ignored.
+	other := localCluster{namespace: "other", dk: dk}
+	if machines, _ := other.Get(); len(machines) != 0 {
+		t.Errorf("unexpected machines: %v", machines)
+	}
+
+	if err := clst.Stop(machines); err != nil {
+		t.Fatal(err)
+	}
+
+	if machines, _ := clst.Get(); len(machines) != 0 {
+		t.Errorf("machines remain after Stop: %v", machines)
+	}
+}
+
+type fakeDocker struct {
+	docker.Client
+
+	runs       []docker.RunOptions
+	containers map[string]docker.Container
+}
+
+func (f *fakeDocker) Run(opts docker.RunOptions) error {
+	f.runs = append(f.runs, opts)
+	f.containers[opts.Name] = docker.Container{
+		ID:     opts.Name,
+		Name:   opts.Name,
+		Image:  opts.Image,
+		IP:     fmt.Sprintf("172.17.0.%d", len(f.runs)+1),
+		Labels: opts.Labels,
+	}
+	return nil
+}
+
+func (f *fakeDocker) List(filters map[string][]string) ([]docker.Container, error) {
+	var result []docker.Container
+	for _, c := range f.containers {
+		match := true
+		for _, label := range filters["label"] {
+			kv := strings.SplitN(label, "=", 2)
+			match = match && c.Labels[kv[0]] == kv[1]
+		}
+
+		if match {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeDocker) RemoveID(id string) error {
+	delete(f.containers, id)
+	return nil
+}
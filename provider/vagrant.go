@@ -31,7 +31,7 @@ func (clst vagrantCluster) Boot(bootSet []Machine) error {
 	wg.Add(len(bootSet))
 	for _, m := range bootSet {
 		id := uuid.NewV4().String()
-		err := vagrant.Init(cloudConfigUbuntu(m.SSHKeys, "vivid"), m.Size, id)
+		err := vagrant.Init(cloudConfigUbuntu(m, "vivid"), m.Size, id)
 		if err != nil {
 			vagrant.Destroy(id)
 			return err
@@ -3,7 +3,7 @@
 (import "exspark")
 
 (define Namespace "CHANGE_ME")
-(define AdminACL (list "local"))
+(user "github" "nlsun" "local")
 
 (let ((masterCount 1)
       (workerCount 2))
@@ -13,8 +13,7 @@
     (list (provider "AmazonSpot")
           (region "us-west-2")
           (size "m4.large")
-          (diskSize 32)
-          (githubKey "nlsun"))))
+          (diskSize 32))))
 
 (let ((prefix "di")
       (nSparkMaster 2)
@@ -17,7 +17,7 @@
 (import "exwp")
 
 (define Namespace "CHANGE_ME")
-(define AdminACL (list "local"))
+(user "github" "nlsun" "local")
 
 (let ((masterCount 1)
       (workerCount 1)) 
@@ -27,8 +27,7 @@
     (list (provider "AmazonSpot")
           (region "us-west-2")
           (size "m4.large")
-          (diskSize 32)
-          (githubKey "nlsun"))))
+          (diskSize 32))))
 
 (let ((prefix "di")
       (nMemcached 2)
@@ -14,21 +14,27 @@
         (strings.Itoa id)
         mysqlDefaultArgs))
 
+// Keep the database of the container labeled 'l' in a volume so that it survives
+// the container being replaced.
+(define (persist l)
+  (mount l (volume (sprintf "%s-data" (labelName l)) "/var/lib/mysql"))
+  l)
+
 // Return a list where the first arg is the list of master labels and the
 // second arg is the list of slave labels
 (define (create prefix nSlave)
   // The ids for masters and slaves CANNOT overlap
   (let ((masterPrefix (sprintf "%s-dbm" prefix))
         (slavePrefix (sprintf "%s-dbs" prefix)))
-    (let ((masterLabel (labels.Docker
-                         (list masterPrefix 1)
-                         (mysqlMasterArgs 1))))
+    (let ((masterLabel (persist (labels.Docker
+                                  (list masterPrefix 1)
+                                  (mysqlMasterArgs 1)))))
       (hmap ("master" (list masterLabel))
             ("slave" (map
                             (lambda (i)
-                              (labels.Docker
-                                (list slavePrefix i)
-                                (mysqlSlaveArgs masterLabel i)))
+                              (persist (labels.Docker
+                                         (list slavePrefix i)
+                                         (mysqlSlaveArgs masterLabel i))))
                             (range 2 (+ 2 nSlave))))))))
 
 (define (link masterList slaveList)
@@ -4,12 +4,18 @@
 
 (define image "quay.io/netsys/zookeeper")
 
+// Keep the data of the container labeled 'l' in a volume so that it survives the
+// container being replaced.
+(define (persist l)
+  (mount l (volume (sprintf "%s-data" (labelName l)) "/tmp/zookeeper"))
+  l)
+
 (define (create prefix n)
   (let ((labelNames (strings.Range prefix n))
         // XXX labels.StrToHostname breaks abstraction
         (zooHosts (strings.Join (map labels.StrToHostname labelNames) ","))
         (zooDockers (makeList n (docker image zooHosts))))
-    (map label labelNames zooDockers)))
+    (map persist (map label labelNames zooDockers))))
 
 (define (link zoos)
   (connect (list 1000 65535) zoos zoos))